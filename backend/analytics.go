@@ -0,0 +1,286 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
+)
+
+// The analytics are computed over the workouts done in a date range, put
+// into day, week or month long buckets. Dates are the milliseconds of a
+// local midnight, so the client sends its utc offset for the buckets to
+// start at its midnights. Weeks start on monday. Weights are in kg and
+// warmup sets don't count.
+
+const maxBuckets = 1000
+
+type bucketing struct {
+	size   string // day, week or month
+	offset int64  // the utc offset in milliseconds
+}
+
+// Get the start of the bucket the date is in
+func (b bucketing) start(date int64) int64 {
+	t := time.UnixMilli(date + b.offset).UTC()
+	t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch b.size {
+	case "week":
+		t = t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+	case "month":
+		t = t.AddDate(0, 0, 1-t.Day())
+	}
+	return t.UnixMilli() - b.offset
+}
+
+func (b bucketing) next(start int64) int64 {
+	t := time.UnixMilli(start + b.offset).UTC()
+	switch b.size {
+	case "day":
+		t = t.AddDate(0, 0, 1)
+	case "week":
+		t = t.AddDate(0, 0, 7)
+	case "month":
+		t = t.AddDate(0, 1, 0)
+	}
+	return t.UnixMilli() - b.offset
+}
+
+// The buckets a range of dates is split into, in order
+type buckets struct {
+	bucketing
+	list    []AnalyticsBucketJSON
+	indexes map[int64]int // by start
+}
+
+// Create every bucket in the range, so periods without any workouts are zero
+func newBuckets(b bucketing, from int64, to int64, grouped bool) (*buckets, bool) {
+	result := &buckets{bucketing: b, indexes: map[int64]int{}}
+	for start := b.start(from); start <= to; start = b.next(start) {
+		if len(result.list) == maxBuckets {
+			return nil, false
+		}
+		bucket := AnalyticsBucketJSON{Start: start}
+		if grouped {
+			bucket.Values = map[string]float64{}
+		}
+		result.indexes[start] = len(result.list)
+		result.list = append(result.list, bucket)
+	}
+	return result, true
+}
+
+func (b *buckets) get(date int64) *AnalyticsBucketJSON {
+	return &b.list[b.indexes[b.start(date)]]
+}
+
+// Parse the date range and the bucketing shared by the analytics
+func parseAnalyticsRange(
+	w http.ResponseWriter, r *http.Request,
+) (int64, int64, bucketing, bool) {
+	from, okFrom := getQuery[int64](w, r, "from")
+	if !okFrom {
+		return 0, 0, bucketing{}, false
+	}
+	to, okTo := getQuery[int64](w, r, "to")
+	if !okTo {
+		return 0, 0, bucketing{}, false
+	}
+	size, okSize := getOptionalQuery(w, r, "bucket", "week")
+	if !okSize {
+		return 0, 0, bucketing{}, false
+	}
+	offset, okOffset := getOptionalQuery[int64](w, r, "utcOffset", 0)
+	if !okOffset {
+		return 0, 0, bucketing{}, false
+	}
+
+	if !slices.Contains([]string{"day", "week", "month"}, size) {
+		respond(w, http.StatusBadRequest, "Bucket must be day, week or month")
+		return 0, 0, bucketing{}, false
+	}
+	if offset < -14*60 || offset > 14*60 {
+		respond(w, http.StatusBadRequest, "Invalid utc offset")
+		return 0, 0, bucketing{}, false
+	}
+	if from > to {
+		respond(w, http.StatusBadRequest, "The range must start before it ends")
+		return 0, 0, bucketing{}, false
+	}
+	return from, to, bucketing{size, offset * 60 * 1000}, true
+}
+
+// Respond with the buckets of an analytic over the range, filled in by the
+// function from the training sets in the range. Empty buckets are left out
+// when the analytic doesn't make sense without any sets.
+func (a *API) respondWithSetAnalytic(
+	w http.ResponseWriter, r *http.Request, userID int32,
+	grouped bool, skipEmpty bool, fill func(b *buckets, row database.GetTrainingSetsRow),
+) {
+	from, to, bucketing, ok := parseAnalyticsRange(w, r)
+	if !ok {
+		return
+	}
+	buckets, ok := newBuckets(bucketing, from, to, grouped)
+	if !ok {
+		respond(w, http.StatusBadRequest, "Too many buckets in the range")
+		return
+	}
+
+	rows, err := a.queries.GetTrainingSets(a.ctx, database.GetTrainingSetsParams{
+		Userid: userID, FromDate: from, ToDate: to,
+	})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get the analytics")
+		return
+	}
+	for _, row := range rows {
+		fill(buckets, row)
+	}
+	if skipEmpty {
+		buckets.list = slices.DeleteFunc(buckets.list, func(b AnalyticsBucketJSON) bool {
+			return b.Total == 0
+		})
+	}
+	respond(w, http.StatusOK, map[string]any{"buckets": buckets.list})
+}
+
+// Get the tonnage (weight times reps) lifted in each bucket, split by
+// exercise or by the primary muscles of the exercises. Exercises that
+// aren't in the catalog count toward the "other" muscle group.
+func (a *API) GetVolumeAnalytics(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	groupBy, okGroup := getOptionalQuery(w, r, "groupBy", "exercise")
+	if !okGroup {
+		return
+	}
+	if groupBy != "exercise" && groupBy != "muscle" {
+		respond(w, http.StatusBadRequest, "Group by must be exercise or muscle")
+		return
+	}
+
+	fill := func(b *buckets, row database.GetTrainingSetsRow) {
+		tonnage := toKg(row.Weight, row.Weightunit) * float64(row.Reps)
+		bucket := b.get(row.Date)
+		bucket.Total += tonnage
+
+		if groupBy == "exercise" {
+			bucket.Values[strings.TrimSpace(row.Name)] += tonnage
+			return
+		}
+		if len(row.Primarymuscles) == 0 {
+			bucket.Values["other"] += tonnage
+		}
+		for _, muscle := range row.Primarymuscles {
+			bucket.Values[muscle] += tonnage
+		}
+	}
+	a.respondWithSetAnalytic(w, r, userID, true, false, fill)
+}
+
+// Get the number of sets done for each muscle in each bucket. Sets count
+// fully toward the primary muscles of the exercise and as half a set
+// toward its secondary muscles. The total is the number of sets.
+func (a *API) GetMuscleSetAnalytics(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	fill := func(b *buckets, row database.GetTrainingSetsRow) {
+		bucket := b.get(row.Date)
+		bucket.Total += 1
+
+		if len(row.Primarymuscles) == 0 {
+			bucket.Values["other"] += 1
+		}
+		for _, muscle := range row.Primarymuscles {
+			bucket.Values[muscle] += 1
+		}
+		for _, muscle := range row.Secondarymuscles {
+			bucket.Values[muscle] += 0.5
+		}
+	}
+	a.respondWithSetAnalytic(w, r, userID, true, false, fill)
+}
+
+// Get the number of workouts done in each bucket
+func (a *API) GetFrequencyAnalytics(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	from, to, bucketing, ok := parseAnalyticsRange(w, r)
+	if !ok {
+		return
+	}
+	buckets, ok := newBuckets(bucketing, from, to, false)
+	if !ok {
+		respond(w, http.StatusBadRequest, "Too many buckets in the range")
+		return
+	}
+
+	dates, err := a.queries.GetWorkoutDates(a.ctx, database.GetWorkoutDatesParams{
+		Userid: userID, FromDate: from, ToDate: to,
+	})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get the analytics")
+		return
+	}
+	for _, date := range dates {
+		buckets.get(date).Total += 1
+	}
+	respond(w, http.StatusOK, map[string]any{"buckets": buckets.list})
+}
+
+// Get the best estimated one rep max of an exercise in each bucket, using
+// the Epley or Brzycki formula. The exercise is found like it is for the
+// personal records. Buckets where the exercise wasn't done are left out.
+func (a *API) GetStrengthAnalytics(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	name, okName := getQuery[string](w, r, "name")
+	if !okName {
+		return
+	}
+	formula, okFormula := getOptionalQuery(w, r, "formula", "epley")
+	if !okFormula {
+		return
+	}
+	estimate := epley
+	switch formula {
+	case "epley":
+	case "brzycki":
+		estimate = brzycki
+	default:
+		respond(w, http.StatusBadRequest, "Formula must be epley or brzycki")
+		return
+	}
+
+	catalogID, err := a.queries.FindCatalogExercise(a.ctx, database.FindCatalogExerciseParams{
+		Userid: userID, Name: name,
+	})
+	if err != nil && err != pgx.ErrNoRows {
+		respond(w, http.StatusInternalServerError, "Couldn't get the analytics")
+		return
+	}
+
+	fill := func(b *buckets, row database.GetTrainingSetsRow) {
+		if catalogID != 0 && row.Catalogid != catalogID {
+			return
+		}
+		if catalogID == 0 && !strings.EqualFold(strings.TrimSpace(row.Name), strings.TrimSpace(name)) {
+			return
+		}
+		bucket := b.get(row.Date)
+		bucket.Total = max(bucket.Total, estimate(toKg(row.Weight, row.Weightunit), row.Reps))
+	}
+	a.respondWithSetAnalytic(w, r, userID, false, true, fill)
+}
@@ -101,6 +101,32 @@ func verifyPassword(password string, actualPassword string) (bool, error) {
 	return hash == parts[4], nil
 }
 
+// Get the user ID from the json web token in the request Authorization
+// header without checking the database or responding on failure
+func tokenUserID(r *http.Request) (int32, bool) {
+	authHeader := strings.TrimSpace(r.Header.Get("Authorization"))
+	str, ok := strings.CutPrefix(authHeader, "Bearer ")
+	if !ok {
+		return -1, false
+	}
+
+	token, err := verifyToken(str)
+	if err != nil {
+		return -1, false
+	}
+
+	subject, err := token.Claims.GetSubject()
+	if err != nil {
+		return -1, false
+	}
+
+	id, err := strconv.ParseInt(subject, 10, 32)
+	if err != nil {
+		return -1, false
+	}
+	return int32(id), true
+}
+
 // Get the user ID from the json web token in the request Authorization
 // header and check that the user's present in the database
 func parseJWT(a *API, w http.ResponseWriter, r *http.Request) (int32, bool) {
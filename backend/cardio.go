@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
+)
+
+// Cardio exercises can be logged from the GPX and TCX files recorded by
+// watches and phones. The file is summarized into the exercise's duration,
+// distance, elevation gain and heart rate, and its track can be stored to
+// be drawn on a map later.
+
+const (
+	maxActivitySize = 20 << 20 // in bytes
+	maxTrackPoints  = 5000     // tracks with more points are thinned out
+	earthRadius     = 6371000  // in meters
+)
+
+// Fill in the pace and speed of a cardio exercise
+func withCardioStats(e ExerciseJSON) ExerciseJSON {
+	e.Pace, e.Speed = 0, 0
+	if e.ExerciseType != "cardio" || e.Distance <= 0 || e.Duration <= 0 {
+		return e
+	}
+	km := e.Distance / 1000
+	e.Pace = e.Duration * 60 / km
+	e.Speed = km / (e.Duration / 60)
+	return e
+}
+
+type gpxFile struct {
+	Tracks []struct {
+		Type     string `xml:"type"`
+		Segments []struct {
+			Points []struct {
+				Lat       float64   `xml:"lat,attr"`
+				Lon       float64   `xml:"lon,attr"`
+				Elevation float64   `xml:"ele"`
+				Time      time.Time `xml:"time"`
+				HeartRate int32     `xml:"extensions>TrackPointExtension>hr"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+type tcxFile struct {
+	Activities []struct {
+		Sport string `xml:"Sport,attr"`
+		Laps  []struct {
+			TotalTimeSeconds float64 `xml:"TotalTimeSeconds"`
+			DistanceMeters   float64 `xml:"DistanceMeters"`
+			Points           []struct {
+				Time      time.Time `xml:"Time"`
+				Lat       float64   `xml:"Position>LatitudeDegrees"`
+				Lon       float64   `xml:"Position>LongitudeDegrees"`
+				Altitude  float64   `xml:"AltitudeMeters"`
+				HeartRate int32     `xml:"HeartRateBpm>Value"`
+			} `xml:"Track>Trackpoint"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+// A recorded activity. The duration and distance are the ones the device
+// worked out when the file has them, since they account for pauses.
+type activity struct {
+	sport    string
+	points   []TrackPointJSON
+	duration float64 // in minutes
+	distance float64 // in meters
+}
+
+var errNotActivity = errors.New("the file isn't a gpx or tcx file")
+
+// Parse a GPX or TCX file, telling them apart by their root element
+func parseActivity(r io.Reader) (activity, error) {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return activity{}, errNotActivity
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "gpx":
+			var file gpxFile
+			if err := decoder.DecodeElement(&file, &start); err != nil {
+				return activity{}, err
+			}
+			return file.activity(), nil
+		case "TrainingCenterDatabase":
+			var file tcxFile
+			if err := decoder.DecodeElement(&file, &start); err != nil {
+				return activity{}, err
+			}
+			return file.activity(), nil
+		default:
+			return activity{}, errNotActivity
+		}
+	}
+}
+
+// The time of a point in milliseconds, 0 when it doesn't have one,
+// since the time of gpx points is optional
+func pointTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func (f gpxFile) activity() activity {
+	result := activity{}
+	for _, track := range f.Tracks {
+		if len(result.sport) == 0 {
+			result.sport = track.Type
+		}
+		for _, segment := range track.Segments {
+			for _, p := range segment.Points {
+				result.points = append(result.points, TrackPointJSON{
+					Lat: p.Lat, Lon: p.Lon, Elevation: p.Elevation,
+					Time: pointTime(p.Time), HeartRate: p.HeartRate,
+				})
+			}
+		}
+	}
+	return result
+}
+
+func (f tcxFile) activity() activity {
+	result := activity{}
+	for _, a := range f.Activities {
+		if len(result.sport) == 0 && a.Sport != "Other" {
+			result.sport = a.Sport
+		}
+		for _, lap := range a.Laps {
+			result.duration += lap.TotalTimeSeconds / 60
+			result.distance += lap.DistanceMeters
+			for _, p := range lap.Points {
+				result.points = append(result.points, TrackPointJSON{
+					Lat: p.Lat, Lon: p.Lon, Elevation: p.Altitude,
+					Time: pointTime(p.Time), HeartRate: p.HeartRate,
+				})
+			}
+		}
+	}
+	return result
+}
+
+// The great circle distance between two points, in meters
+func haversine(a TrackPointJSON, b TrackPointJSON) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+	dLat := toRadians(b.Lat - a.Lat)
+	dLon := toRadians(b.Lon - a.Lon)
+	h := math.Pow(math.Sin(dLat/2), 2) +
+		math.Cos(toRadians(a.Lat))*math.Cos(toRadians(b.Lat))*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// Points recorded indoors don't have a position
+func hasPosition(p TrackPointJSON) bool {
+	return p.Lat != 0 || p.Lon != 0
+}
+
+// Summarize the activity into a cardio exercise
+func (a activity) exercise(name string) ExerciseJSON {
+	e := ExerciseJSON{
+		ExerciseType: "cardio", Name: name, WeightUnit: "kg",
+		Reps: []int32{}, Sets: []SetJSON{},
+	}
+
+	var last *TrackPointJSON
+	distance, heartRates, heartBeats := 0.0, 0, 0
+	for i, p := range a.points {
+		if p.HeartRate > 0 {
+			heartRates++
+			heartBeats += int(p.HeartRate)
+			e.MaxHeartRate = max(e.MaxHeartRate, p.HeartRate)
+		}
+		if !hasPosition(p) {
+			continue
+		}
+		if last != nil {
+			distance += haversine(*last, p)
+			e.ElevationGain += max(p.Elevation-last.Elevation, 0)
+		}
+		last = &a.points[i]
+	}
+	if heartRates > 0 {
+		e.AvgHeartRate = int32(math.Round(float64(heartBeats) / float64(heartRates)))
+	}
+
+	e.Distance = a.distance
+	if e.Distance == 0 {
+		e.Distance = distance
+	}
+	e.Duration = a.duration
+	if e.Duration == 0 {
+		// from the first to the last point with a time
+		first, last := int64(0), int64(0)
+		for _, p := range a.points {
+			if p.Time == 0 {
+				continue
+			}
+			if first == 0 {
+				first = p.Time
+			}
+			last = p.Time
+		}
+		e.Duration = float64(last-first) / 1000 / 60
+	}
+	return withCardioStats(e)
+}
+
+// Get the points with a position, thinned out to at most maxTrackPoints
+func (a activity) track() []TrackPointJSON {
+	points := []TrackPointJSON{}
+	for _, p := range a.points {
+		if hasPosition(p) {
+			points = append(points, p)
+		}
+	}
+	if len(points) <= maxTrackPoints {
+		return points
+	}
+
+	thinned := []TrackPointJSON{}
+	step := float64(len(points)-1) / float64(maxTrackPoints-1)
+	for i := range maxTrackPoints {
+		thinned = append(thinned, points[int(math.Round(float64(i)*step))])
+	}
+	return thinned
+}
+
+// Log a cardio exercise from a GPX or TCX file sent as the request body,
+// in a new workout on the date. The exercise is named after the activity's
+// sport unless a name is given.
+func (a *API) UploadActivity(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	date, ok := getQuery[int64](w, r, "date")
+	if !ok {
+		return
+	}
+	name, ok := getOptionalQuery(w, r, "name", "")
+	if !ok {
+		return
+	}
+	storeTrack, ok := getOptionalQuery(w, r, "track", false)
+	if !ok {
+		return
+	}
+
+	parsed, err := parseActivity(http.MaxBytesReader(w, r.Body, maxActivitySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respond(w, http.StatusRequestEntityTooLarge, "The file is too large")
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusBadRequest, "bad request: the file isn't a gpx or tcx file")
+		return
+	}
+	if len(parsed.points) == 0 && parsed.duration == 0 {
+		respond(w, http.StatusBadRequest, "bad request: the file doesn't have an activity")
+		return
+	}
+
+	if len(name) == 0 && len(parsed.sport) > 0 {
+		name = strings.ToUpper(parsed.sport[:1]) + strings.ToLower(parsed.sport[1:])
+	}
+	if len(name) == 0 {
+		name = "Cardio"
+	}
+	exercise := parsed.exercise(name)
+
+	tx, err := a.conn.Begin(a.ctx)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't log activity")
+		return
+	}
+	defer tx.Rollback(a.ctx)
+	qtx := a.queries.WithTx(tx)
+
+	workout, err := createWorkout(a.ctx, qtx, userID, WorkoutJSON{
+		Name: name, Date: date, Exercises: []ExerciseJSON{exercise},
+	})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't log activity")
+		return
+	}
+
+	if points := parsed.track(); storeTrack && len(points) > 0 {
+		encoded, err := json.Marshal(points)
+		if err != nil {
+			respond(w, http.StatusInternalServerError, "Couldn't log activity")
+			return
+		}
+		if err := qtx.SaveTrack(a.ctx, database.SaveTrackParams{
+			Userid:     userID,
+			Workoutid:  workout.ID,
+			Exerciseid: workout.Exercises[0].ID,
+			Points:     encoded,
+		}); err != nil {
+			respond(w, http.StatusInternalServerError, "Couldn't log activity")
+			return
+		}
+	}
+
+	if err := tx.Commit(a.ctx); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't log activity")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{"workout": workout})
+}
+
+// Get the gps track stored with a cardio exercise
+func (a *API) GetTrack(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	exerciseID, ok := getPathValue[int64](w, r, "id")
+	if !ok {
+		return
+	}
+
+	encoded, err := a.queries.GetTrack(a.ctx, database.GetTrackParams{
+		Exerciseid: int32(exerciseID), Userid: userID,
+	})
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Track not found")
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get track")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{"points": json.RawMessage(encoded)})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The exercise catalog gives exercises an identity beyond their free text
+// names, so "Bench press" and "bench Press" are the same lift. The exercises
+// bundled in data/exercises.json are loaded at startup, and users can add
+// their own. Exercises saved without a catalog id are linked to the catalog
+// exercise with their name or alias, if there is one.
+
+// Load the bundled exercises into the catalog, updating the ones already there
+func seedCatalog(
+	ctx context.Context, conn *pgxpool.Pool, queries *database.Queries, path string,
+) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var exercises []CatalogExerciseJSON
+	if err := json.Unmarshal(data, &exercises); err != nil {
+		return fmt.Errorf("%s isn't valid: %w", path, err)
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	q := queries.WithTx(tx)
+
+	params := []database.UpsertCatalogExercisesParams{}
+	for _, e := range exercises {
+		e = withCatalogDefaults(e)
+		params = append(params, database.UpsertCatalogExercisesParams{
+			Name:             e.Name,
+			Aliases:          e.Aliases,
+			Primarymuscles:   e.PrimaryMuscles,
+			Secondarymuscles: e.SecondaryMuscles,
+			Equipment:        e.Equipment,
+			Exercisetype:     e.ExerciseType,
+			Met:              e.MET,
+		})
+	}
+	q.UpsertCatalogExercises(ctx, params).Exec(func(i int, e error) {
+		if e != nil {
+			err = e
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// link the exercises that were saved before they were in the catalog
+	if err := linkExercisesToCatalog(ctx, q); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Link the exercises that aren't in the catalog to the catalog exercises with
+// their name, then find the personal records of both the names they had
+// and the catalog exercises they're now, since records are kept by catalog
+// exercise. Should be called in a transaction.
+func linkExercisesToCatalog(ctx context.Context, q *database.Queries) error {
+	rows, err := q.LinkExercisesToCatalog(ctx)
+	if err != nil {
+		return err
+	}
+	linked := map[int32][]ExerciseJSON{} // by user
+	for _, row := range rows {
+		linked[row.Userid] = append(linked[row.Userid],
+			ExerciseJSON{Name: row.Name}, ExerciseJSON{Name: row.Name, CatalogID: row.Catalogid})
+	}
+	for userID, exercises := range linked {
+		for _, exercise := range recordExercises(exercises) {
+			if err := findRecords(ctx, q, userID, exercise, math.MinInt64); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The columns are arrays that can't be null
+func withCatalogDefaults(e CatalogExerciseJSON) CatalogExerciseJSON {
+	if e.Aliases == nil {
+		e.Aliases = []string{}
+	}
+	if e.PrimaryMuscles == nil {
+		e.PrimaryMuscles = []string{}
+	}
+	if e.SecondaryMuscles == nil {
+		e.SecondaryMuscles = []string{}
+	}
+	return e
+}
+
+func catalogRowToJson(row database.Catalogexercise) CatalogExerciseJSON {
+	return CatalogExerciseJSON{
+		ID:               row.ID,
+		Name:             row.Name,
+		Aliases:          row.Aliases,
+		PrimaryMuscles:   row.Primarymuscles,
+		SecondaryMuscles: row.Secondarymuscles,
+		Equipment:        row.Equipment,
+		ExerciseType:     row.Exercisetype,
+		MET:              row.Met,
+		Custom:           row.Userid != 0,
+	}
+}
+
+// Search the names and aliases of the bundled exercises and the user's own,
+// optionally only getting the exercises that work a muscle or use equipment
+func (a *API) SearchCatalog(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	query, okQuery := getOptionalQuery(w, r, "query", "")
+	muscle, okMuscle := getOptionalQuery(w, r, "muscle", "")
+	equipment, okEquipment := getOptionalQuery(w, r, "equipment", "")
+	if !okQuery || !okMuscle || !okEquipment {
+		return
+	}
+
+	rows, err := a.queries.SearchCatalog(a.ctx, database.SearchCatalogParams{
+		Userid:    userID,
+		Query:     query,
+		Muscle:    pgtype.Text{String: muscle, Valid: len(muscle) > 0},
+		Equipment: pgtype.Text{String: equipment, Valid: len(equipment) > 0},
+	})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Failed to search")
+		return
+	}
+
+	exercises := []CatalogExerciseJSON{}
+	for _, row := range rows {
+		exercises = append(exercises, catalogRowToJson(row))
+	}
+	respond(w, http.StatusOK, map[string]any{"results": exercises})
+}
+
+// Add a custom exercise to the user's catalog
+func (a *API) CreateCatalogExercise(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	req, ok := parseRequest[CatalogExerciseJSON](w, r)
+	if !ok {
+		return
+	}
+
+	req = withCatalogDefaults(req)
+	req.Name = strings.TrimSpace(req.Name)
+	if len(req.Name) == 0 {
+		respond(w, http.StatusBadRequest, "bad request: missing name")
+		return
+	}
+	if req.ExerciseType != "strength" && req.ExerciseType != "cardio" {
+		respond(w, http.StatusBadRequest, "bad request: exerciseType must be strength or cardio")
+		return
+	}
+	if req.MET < 0 {
+		respond(w, http.StatusBadRequest, "bad request: met can't be negative")
+		return
+	}
+
+	existing, err := a.queries.FindCatalogExercise(a.ctx, database.FindCatalogExerciseParams{
+		Userid: userID, Name: req.Name,
+	})
+	if err == nil {
+		respond(w, http.StatusConflict, map[string]any{
+			"error": "Exercise is already in the catalog", "id": existing,
+		})
+		return
+	}
+	if err != pgx.ErrNoRows {
+		respond(w, http.StatusInternalServerError, "Couldn't create exercise")
+		return
+	}
+
+	req.ID, err = a.queries.CreateCatalogExercise(a.ctx, database.CreateCatalogExerciseParams{
+		Userid:           userID,
+		Name:             req.Name,
+		Aliases:          req.Aliases,
+		Primarymuscles:   req.PrimaryMuscles,
+		Secondarymuscles: req.SecondaryMuscles,
+		Equipment:        req.Equipment,
+		Exercisetype:     req.ExerciseType,
+		Met:              req.MET,
+	})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't create exercise")
+		return
+	}
+	req.Custom = true
+	respond(w, http.StatusOK, map[string]any{"exercise": req})
+}
+
+// The catalog id of the exercise. Exercises without one are
+// linked to the catalog exercise with their name or alias.
+func linkCatalog(
+	ctx context.Context, q *database.Queries, userID int32, e ExerciseJSON,
+) (int32, error) {
+	if e.CatalogID != 0 {
+		_, err := q.GetCatalogExercise(ctx, database.GetCatalogExerciseParams{
+			ID: e.CatalogID, Userid: userID,
+		})
+		if err == pgx.ErrNoRows {
+			return 0, mutationError(fmt.Sprintf("catalog exercise %d doesn't exist", e.CatalogID))
+		}
+		return e.CatalogID, err
+	}
+
+	id, err := q.FindCatalogExercise(ctx, database.FindCatalogExerciseParams{
+		Userid: userID, Name: strings.TrimSpace(e.Name),
+	})
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
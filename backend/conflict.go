@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Every change to a meal, workout, record or the settings bumps its version.
+// Clients send the version their change is based on, and when that's not the
+// version in the database, someone else changed it first, so the change is
+// rejected with a conflictError holding the server's copy. Clients that don't
+// send a version (a version of 0) overwrite whatever's there.
+type conflictError struct {
+	current any
+}
+
+func (e conflictError) Error() string { return "conflict" }
+
+// The base version to pass to a query, null when the client didn't send one
+func baseVersion(version int64) pgtype.Int8 {
+	return pgtype.Int8{Int64: version, Valid: version > 0}
+}
+
+// Respond with the server's copy when the error is a conflict
+func respondConflict(w http.ResponseWriter, err error) bool {
+	var conflict conflictError
+	if !errors.As(err, &conflict) {
+		return false
+	}
+	respond(w, http.StatusConflict, map[string]any{
+		"error":   "Changed by another device",
+		"current": conflict.current,
+	})
+	return true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Which cross origin requests browsers are allowed to make.
+// Configured through the CORS_* environment variables.
+type CORSPolicy struct {
+	allowedOrigins   []string // "*" allows any origin, but never with credentials
+	allowedMethods   []string
+	allowedHeaders   []string
+	exposedHeaders   []string
+	allowCredentials bool
+	maxAge           int // how long preflight responses can be cached, in seconds
+}
+
+// Split a comma separated environment variable,
+// falling back to the defaults when it isn't set
+func envList(name string, defaults []string) []string {
+	value := strings.TrimSpace(os.Getenv(name))
+	if len(value) == 0 {
+		return defaults
+	}
+
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func NewCORSPolicy() CORSPolicy {
+	policy := CORSPolicy{
+		// the capacitor app's webview origins on ios and android, and the dev server
+		allowedOrigins: envList("CORS_ALLOWED_ORIGINS", []string{
+			"capacitor://localhost", "http://localhost", "https://localhost",
+			"http://localhost:3000",
+		}),
+		allowedMethods: envList("CORS_ALLOWED_METHODS", []string{
+			"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS",
+		}),
+		allowedHeaders: envList("CORS_ALLOWED_HEADERS", []string{
+			"Content-Type", "Authorization", "Idempotency-Key",
+		}),
+		exposedHeaders: envList("CORS_EXPOSED_HEADERS", []string{
+			"Deprecation", "Sunset", "Link", "Retry-After", "Idempotent-Replayed",
+		}),
+		allowCredentials: os.Getenv("CORS_ALLOW_CREDENTIALS") == "true",
+		maxAge:           600,
+	}
+
+	if age, err := strconv.Atoi(os.Getenv("CORS_MAX_AGE")); err == nil {
+		policy.maxAge = age
+	}
+	return policy
+}
+
+func (p CORSPolicy) anyOrigin() bool {
+	return slices.Contains(p.allowedOrigins, "*")
+}
+
+func (p CORSPolicy) originAllowed(origin string) bool {
+	return p.anyOrigin() || slices.ContainsFunc(p.allowedOrigins, func(o string) bool {
+		return strings.EqualFold(o, origin)
+	})
+}
+
+func (p CORSPolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions &&
+			r.Header.Get("Access-Control-Request-Method") != ""
+
+		// the response depends on the origin, so caches need to key on it
+		header.Add("Vary", "Origin")
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		// same origin or non browser requests
+		if len(origin) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !p.originAllowed(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			// without the cors headers the browser won't expose the response
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// reflect the origin rather than sending "*", since "*"
+		// isn't allowed when credentials are allowed
+		header.Set("Access-Control-Allow-Origin", origin)
+		if p.allowCredentials && !p.anyOrigin() {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(p.exposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(p.exposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method := r.Header.Get("Access-Control-Request-Method")
+		if !slices.Contains(p.allowedMethods, method) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		header.Set("Access-Control-Allow-Methods", strings.Join(p.allowedMethods, ", "))
+		header.Set("Access-Control-Allow-Headers", strings.Join(p.allowedHeaders, ", "))
+		header.Set("Access-Control-Max-Age", strconv.Itoa(p.maxAge))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -1,22 +1,80 @@
 package main
 
+import "encoding/json"
+
 type SettingsJSON struct {
 	MealTags     []string       `json:"mealTags"`
 	UseImperial  bool           `json:"useImperial"`
 	TrackPeriod  bool           `json:"trackPeriod"`
 	MacroTargets map[string]int `json:"macroTargets"`
 	DarkMode     bool           `json:"darkMode"`
+	Version      int64          `json:"version"`
+}
+
+// One set of a strength exercise. RPE is 0 when it wasn't recorded,
+// and the type is "normal", "warmup", "drop" or "failure".
+type SetJSON struct {
+	Weight      float64 `json:"weight"`
+	Reps        int32   `json:"reps"`
+	RPE         float64 `json:"rpe"`
+	RestSeconds int32   `json:"restSeconds"`
+	Type        string  `json:"type"`
 }
 
+// Strength exercises can be sent with either their sets, or the reps of each
+// set and one weight, like before there were sets. Both are always returned,
+// weight being the heaviest set's weight.
 type ExerciseJSON struct {
-	ID           int32   `json:"id"`
-	WorkoutID    int32   `json:"workoutID"`
-	ExerciseType string  `json:"exerciseType"`
-	Name         string  `json:"name"`
-	Weight       int32   `json:"weight"`
-	WeightUnit   string  `json:"weightUnit"`
-	Reps         []int32 `json:"reps"`
-	Duration     float64 `json:"duration"`
+	ID           int32     `json:"id"`
+	WorkoutID    int32     `json:"workoutID"`
+	ExerciseType string    `json:"exerciseType"`
+	Name         string    `json:"name"`
+	Weight       int32     `json:"weight"`
+	WeightUnit   string    `json:"weightUnit"`
+	Reps         []int32   `json:"reps"`
+	Sets         []SetJSON `json:"sets"`
+	Duration     float64   `json:"duration"`
+	CatalogID    int32     `json:"catalogID"` // 0 when it isn't in the catalog
+
+	// Only for cardio exercises. The pace and speed are computed
+	// from the distance and duration, and ignored in requests.
+	Distance      float64 `json:"distance"`      // in meters
+	ElevationGain float64 `json:"elevationGain"` // in meters
+	AvgHeartRate  int32   `json:"avgHeartRate"`  // 0 when it wasn't recorded
+	MaxHeartRate  int32   `json:"maxHeartRate"`
+	Pace          float64 `json:"pace"`  // in seconds per km
+	Speed         float64 `json:"speed"` // in km/h
+
+	// The number of the workout's group the exercise is in, from 1.
+	// 0 when it isn't in a group.
+	Group int32 `json:"group"`
+
+	// Only for exercises in templates
+	Progression *ProgressionJSON `json:"progression,omitempty"`
+	// Only for exercises in workouts that were just started from a template
+	Recommendation *RecommendationJSON `json:"recommendation,omitempty"`
+}
+
+// How the weights of an exercise in a template progress from one workout to
+// the next. The scheme is "linear", "double" or "percentage". Zero values
+// are replaced by the scheme's defaults.
+type ProgressionJSON struct {
+	Scheme        string  `json:"scheme"`
+	Increment     float64 `json:"increment"` // in the exercise's weight unit
+	MinReps       int32   `json:"minReps"`
+	MaxReps       int32   `json:"maxReps"`
+	Percent       float64 `json:"percent"` // of the estimated one rep max
+	DeloadAfter   int32   `json:"deloadAfter"`
+	DeloadPercent float64 `json:"deloadPercent"`
+}
+
+// The weight and reps suggested for the sets of an exercise
+type RecommendationJSON struct {
+	Scheme string  `json:"scheme"`
+	Weight float64 `json:"weight"`
+	Reps   int32   `json:"reps"`
+	Deload bool    `json:"deload"`
+	Reason string  `json:"reason"`
 }
 
 type WorkoutJSON struct {
@@ -26,7 +84,23 @@ type WorkoutJSON struct {
 	Notes      string         `json:"notes"`
 	Date       int64          `json:"date"`
 	IsTemplate bool           `json:"isTemplate"`
+	TemplateID int32          `json:"templateID"` // 0 when it wasn't started from a template
 	Exercises  []ExerciseJSON `json:"exercises"`
+	Version    int64          `json:"version"`
+	Calories   float64        `json:"calories"` // estimated when it's saved, ignored in requests
+
+	Groups []ExerciseGroupJSON `json:"groups"`
+}
+
+// Exercises done one after the other, in rounds. The type is "superset" or
+// "circuit". The rest is taken between the exercises of a round, and the
+// round rest after each round. The exercises of a group must be next to
+// each other in the workout.
+type ExerciseGroupJSON struct {
+	Type             string `json:"type"`
+	Rounds           int32  `json:"rounds"`
+	RestSeconds      int32  `json:"restSeconds"`
+	RoundRestSeconds int32  `json:"roundRestSeconds"`
 }
 
 type RecordJSON struct {
@@ -34,6 +108,17 @@ type RecordJSON struct {
 	IsPeriod bool    `json:"isPeriod"`
 	Date     int64   `json:"date"`
 	Value    float64 `json:"value"`
+	Version  int64   `json:"version"`
+}
+
+// The user's password, sent to confirm destructive changes to their account
+type PasswordJSON struct {
+	Password string `json:"password"`
+}
+
+// The date of the day a workout is started or skipped on
+type DateJSON struct {
+	Date int64 `json:"date"`
 }
 
 type FoodJSON struct {
@@ -60,4 +145,235 @@ type MealJSON struct {
 	MealTag  string  `json:"mealTag"`
 	Servings float64 `json:"servings"`
 	Unit     string  `json:"servingsUnit"`
+	Version  int64   `json:"version"`
+}
+
+// The fields of a meal to change, the rest are left as is.
+// Version is the version of the meal the change is based on.
+type MealPatchJSON struct {
+	MealTag  *string  `json:"mealTag"`
+	Servings *float64 `json:"servings"`
+	Unit     *string  `json:"servingsUnit"`
+	Version  int64    `json:"version"`
+}
+
+// The fields of a workout to change, the rest are left as is.
+// Version is the version of the workout the change is based on.
+type WorkoutPatchJSON struct {
+	Name       *string `json:"name"`
+	Notes      *string `json:"notes"`
+	Date       *int64  `json:"date"`
+	IsTemplate *bool   `json:"isTemplate"`
+	Version    int64   `json:"version"`
+}
+
+// A change the client made while offline. Creates, updates and deletes can be
+// made to meals and workouts, creates to foods, records are set or deleted by
+// date, and settings are updated. The data is the entity's json, or the patch
+// json when updating meals and workouts. Deletes of meals and workouts can
+// send {"version": n} as their data, to be rejected when the entity changed.
+type MutationJSON struct {
+	Op       string            `json:"op"`
+	Entity   string            `json:"entity"`
+	ClientID string            `json:"clientID"`
+	ID       int32             `json:"id,omitempty"`
+	Refs     map[string]string `json:"refs,omitempty"`
+	Data     json.RawMessage   `json:"data"`
+}
+
+type SyncPushJSON struct {
+	Mutations []MutationJSON `json:"mutations"`
+}
+
+// The result of applying a mutation. When the mutation
+// conflicts, current is the server's copy of the entity.
+type MutationResultJSON struct {
+	ClientID string          `json:"clientID"`
+	ID       int32           `json:"id,omitempty"`
+	Applied  bool            `json:"applied"`
+	Error    string          `json:"error,omitempty"`
+	Current  json.RawMessage `json:"current,omitempty"`
+}
+
+// The entities that changed after the cursor the client sent. Settings are
+// only set when they changed. When hasMore is set, there are more changes to
+// get, by sending the cursor in the response.
+type SyncPullJSON struct {
+	Workouts []WorkoutJSON `json:"workouts"`
+	Foods    []FoodJSON    `json:"foods"`
+	Meals    []MealJSON    `json:"meals"`
+	Records  []RecordJSON  `json:"records"`
+	Settings *SettingsJSON `json:"settings,omitempty"`
+	Cursor   string        `json:"cursor"`
+	HasMore  bool          `json:"hasMore"`
+}
+
+// A change to one of the user's entities, sent by GET /events. Version
+// is the entity's version after the change, or 0 if it isn't versioned.
+type ChangeEventJSON struct {
+	Entity  string `json:"entity"`
+	ID      int32  `json:"id"`
+	Version int64  `json:"version"`
+}
+
+// An exercise in the catalog. Custom exercises are the ones the user made.
+type CatalogExerciseJSON struct {
+	ID               int32    `json:"id"`
+	Name             string   `json:"name"`
+	Aliases          []string `json:"aliases"`
+	PrimaryMuscles   []string `json:"primaryMuscles"`
+	SecondaryMuscles []string `json:"secondaryMuscles"`
+	Equipment        string   `json:"equipment"`
+	ExerciseType     string   `json:"exerciseType"`
+	MET              float64  `json:"met"` // 0 to use the default of the exercise type
+	Custom           bool     `json:"custom"`
+}
+
+// A personal record set by an exercise in a workout. The kind is "weight"
+// (heaviest set), "e1rm-epley" or "e1rm-brzycki" (best estimated one rep max),
+// "reps" (most reps at the weight), or for cardio "duration" (in minutes),
+// "distance" (in meters) or "speed" (in km/h).
+// Weights are in kg. Previous is the record that was beaten, 0 if there
+// wasn't one.
+type PersonalRecordJSON struct {
+	ID         int32   `json:"id"`
+	WorkoutID  int32   `json:"workoutID"`
+	ExerciseID int32   `json:"exerciseID"`
+	CatalogID  int32   `json:"catalogID"`
+	Name       string  `json:"name"`
+	Kind       string  `json:"kind"`
+	Value      float64 `json:"value"`
+	Previous   float64 `json:"previous"`
+	Weight     float64 `json:"weight"`
+	Reps       int32   `json:"reps"`
+	Date       int64   `json:"date"`
+}
+
+// A period of time in the analytics. Start is the local midnight the
+// period starts at. Total is the value for the whole period, and Values
+// splits it by exercise or muscle when the analytic is grouped.
+type AnalyticsBucketJSON struct {
+	Start  int64              `json:"start"`
+	Total  float64            `json:"total"`
+	Values map[string]float64 `json:"values,omitempty"`
+}
+
+// A schedule of templates across weeks
+type ProgramJSON struct {
+	ID          int32            `json:"id"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Weeks       int32            `json:"weeks"`
+	Days        []ProgramDayJSON `json:"days"`
+}
+
+// A template to do on a day of the program. Week starts at 0, and day
+// is the days since the start of the week, from 0 to 6.
+type ProgramDayJSON struct {
+	ID           int32  `json:"id"`
+	Week         int32  `json:"week"`
+	Day          int32  `json:"day"`
+	TemplateID   int32  `json:"templateID"`
+	TemplateName string `json:"templateName"`
+}
+
+// The mode is "calendar", where every day of the program is on a fixed
+// date from the start date and days that pass are missed, or "sequential",
+// where days that pass are pushed back instead, shifting the schedule.
+type EnrollmentJSON struct {
+	ID        int32  `json:"id"`
+	ProgramID int32  `json:"programID"`
+	StartDate int64  `json:"startDate"`
+	Mode      string `json:"mode"`
+}
+
+// A day of the program the user is enrolled in. The status is "done",
+// "skipped", "missed" or "pending". Date is when the day is scheduled.
+type ScheduledDayJSON struct {
+	ID           int32  `json:"id"`
+	Week         int32  `json:"week"`
+	Day          int32  `json:"day"`
+	TemplateID   int32  `json:"templateID"`
+	TemplateName string `json:"templateName"`
+	Date         int64  `json:"date"`
+	Status       string `json:"status"`
+	WorkoutID    int32  `json:"workoutID"` // 0 when it wasn't done
+}
+
+type ProgramProgressJSON struct {
+	Done     int     `json:"done"`
+	Skipped  int     `json:"skipped"`
+	Missed   int     `json:"missed"`
+	Pending  int     `json:"pending"`
+	Total    int     `json:"total"`
+	Percent  float64 `json:"percent"` // of the days that are done
+	Finished bool    `json:"finished"`
+}
+
+// A point of a gps track. Time is in milliseconds since the epoch.
+type TrackPointJSON struct {
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+	Elevation float64 `json:"elevation"` // in meters
+	Time      int64   `json:"time"`      // 0 when it wasn't recorded
+	HeartRate int32   `json:"heartRate"` // 0 when it wasn't recorded
+}
+
+// The energy eaten and burned in workouts on a day, in kcal
+type EnergyDayJSON struct {
+	Date    int64   `json:"date"`
+	Eaten   float64 `json:"eaten"`
+	Burned  float64 `json:"burned"`
+	Balance float64 `json:"balance"` // eaten minus burned
+}
+
+// What importing a csv file did, or would do in a dry run. The workouts
+// don't have ids in a dry run.
+type ImportReportJSON struct {
+	Format    string                 `json:"format"`
+	DryRun    bool                   `json:"dryRun"`
+	Workouts  []WorkoutJSON          `json:"workouts"`
+	Exercises []ImportedExerciseJSON `json:"exercises"`
+	Skipped   []SkippedRowJSON       `json:"skipped"`
+}
+
+// The catalog exercise an exercise name in the file was matched to
+type ImportedExerciseJSON struct {
+	Name        string `json:"name"`
+	CatalogID   int32  `json:"catalogID"` // 0 when it didn't match one
+	CatalogName string `json:"catalogName"`
+}
+
+// Row is the line in the file, where the header is line 1
+type SkippedRowJSON struct {
+	Row    int    `json:"row"`
+	Reason string `json:"reason"`
+}
+
+// Amounts of the nutrients in foods, in the food's units
+type NutrientsJSON struct {
+	Calories     float64 `json:"calories"`
+	Carbohydrate float64 `json:"carbohydrate"`
+	Protein      float64 `json:"protein"`
+	Fat          float64 `json:"fat"`
+	Calcium      float64 `json:"calcium"`
+	Potassium    float64 `json:"potassium"`
+	Iron         float64 `json:"iron"`
+}
+
+// How much of a nutrient in the user's macro targets was eaten on a day
+type TargetJSON struct {
+	Target    float64 `json:"target"`
+	Eaten     float64 `json:"eaten"`
+	Remaining float64 `json:"remaining"` // negative when the target was passed
+	Percent   float64 `json:"percent"`   // of the target that was eaten
+}
+
+// The nutrients eaten on a day, in total and by meal tag, compared
+// against the user's macro targets by nutrient
+type NutritionDayJSON struct {
+	Date    int64                    `json:"date"`
+	Total   NutrientsJSON            `json:"total"`
+	Meals   map[string]NutrientsJSON `json:"meals"`
+	Targets map[string]TargetJSON    `json:"targets"`
 }
@@ -10,6 +10,7 @@ import (
 	"errors"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 var (
@@ -18,8 +19,9 @@ var (
 
 const createExercises = `-- name: CreateExercises :batchmany
 insert into exercises
-(userID, workoutID, exerciseType, name, weight, weightUnit, reps, duration)
-values ($1, $2, $3, $4, $5, $6, $7, $8) returning id
+(userID, workoutID, position, catalogID, exerciseType, name, weight, weightUnit, reps, duration,
+ progression, distance, elevationGain, avgHeartRate, maxHeartRate, groupNumber, lastModified)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17) returning id
 `
 
 type CreateExercisesBatchResults struct {
@@ -29,14 +31,23 @@ type CreateExercisesBatchResults struct {
 }
 
 type CreateExercisesParams struct {
-	Userid       int32
-	Workoutid    int32
-	Exercisetype string
-	Name         string
-	Weight       int32
-	Weightunit   string
-	Reps         []int32
-	Duration     float64
+	Userid        int32
+	Workoutid     int32
+	Position      int32
+	Catalogid     int32
+	Exercisetype  string
+	Name          string
+	Weight        int32
+	Weightunit    string
+	Reps          []int32
+	Duration      float64
+	Progression   []byte
+	Distance      float64
+	Elevationgain float64
+	Avgheartrate  int32
+	Maxheartrate  int32
+	Groupnumber   int32
+	Lastmodified  pgtype.Int8
 }
 
 func (q *Queries) CreateExercises(ctx context.Context, arg []CreateExercisesParams) *CreateExercisesBatchResults {
@@ -45,12 +56,21 @@ func (q *Queries) CreateExercises(ctx context.Context, arg []CreateExercisesPara
 		vals := []interface{}{
 			a.Userid,
 			a.Workoutid,
+			a.Position,
+			a.Catalogid,
 			a.Exercisetype,
 			a.Name,
 			a.Weight,
 			a.Weightunit,
 			a.Reps,
 			a.Duration,
+			a.Progression,
+			a.Distance,
+			a.Elevationgain,
+			a.Avgheartrate,
+			a.Maxheartrate,
+			a.Groupnumber,
+			a.Lastmodified,
 		}
 		batch.Queue(createExercises, vals...)
 	}
@@ -93,3 +113,258 @@ func (b *CreateExercisesBatchResults) Close() error {
 	b.closed = true
 	return b.br.Close()
 }
+
+const createPersonalRecords = `-- name: CreatePersonalRecords :batchexec
+insert into PersonalRecords
+(userID, workoutID, exerciseID, catalogID, name, kind, value, previous, weight, reps, date)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+`
+
+type CreatePersonalRecordsBatchResults struct {
+	br     pgx.BatchResults
+	tot    int
+	closed bool
+}
+
+type CreatePersonalRecordsParams struct {
+	Userid     int32
+	Workoutid  int32
+	Exerciseid int32
+	Catalogid  int32
+	Name       string
+	Kind       string
+	Value      float64
+	Previous   float64
+	Weight     float64
+	Reps       int32
+	Date       int64
+}
+
+func (q *Queries) CreatePersonalRecords(ctx context.Context, arg []CreatePersonalRecordsParams) *CreatePersonalRecordsBatchResults {
+	batch := &pgx.Batch{}
+	for _, a := range arg {
+		vals := []interface{}{
+			a.Userid,
+			a.Workoutid,
+			a.Exerciseid,
+			a.Catalogid,
+			a.Name,
+			a.Kind,
+			a.Value,
+			a.Previous,
+			a.Weight,
+			a.Reps,
+			a.Date,
+		}
+		batch.Queue(createPersonalRecords, vals...)
+	}
+	br := q.db.SendBatch(ctx, batch)
+	return &CreatePersonalRecordsBatchResults{br, len(arg), false}
+}
+
+func (b *CreatePersonalRecordsBatchResults) Exec(f func(int, error)) {
+	defer b.br.Close()
+	for t := 0; t < b.tot; t++ {
+		if b.closed {
+			if f != nil {
+				f(t, ErrBatchAlreadyClosed)
+			}
+			continue
+		}
+		_, err := b.br.Exec()
+		if f != nil {
+			f(t, err)
+		}
+	}
+}
+
+func (b *CreatePersonalRecordsBatchResults) Close() error {
+	b.closed = true
+	return b.br.Close()
+}
+
+const createProgramDays = `-- name: CreateProgramDays :batchexec
+insert into ProgramDays (userID, programID, week, day, templateID)
+values ($1, $2, $3, $4, $5)
+`
+
+type CreateProgramDaysBatchResults struct {
+	br     pgx.BatchResults
+	tot    int
+	closed bool
+}
+
+type CreateProgramDaysParams struct {
+	Userid     int32
+	Programid  int32
+	Week       int32
+	Day        int32
+	Templateid int32
+}
+
+func (q *Queries) CreateProgramDays(ctx context.Context, arg []CreateProgramDaysParams) *CreateProgramDaysBatchResults {
+	batch := &pgx.Batch{}
+	for _, a := range arg {
+		vals := []interface{}{
+			a.Userid,
+			a.Programid,
+			a.Week,
+			a.Day,
+			a.Templateid,
+		}
+		batch.Queue(createProgramDays, vals...)
+	}
+	br := q.db.SendBatch(ctx, batch)
+	return &CreateProgramDaysBatchResults{br, len(arg), false}
+}
+
+func (b *CreateProgramDaysBatchResults) Exec(f func(int, error)) {
+	defer b.br.Close()
+	for t := 0; t < b.tot; t++ {
+		if b.closed {
+			if f != nil {
+				f(t, ErrBatchAlreadyClosed)
+			}
+			continue
+		}
+		_, err := b.br.Exec()
+		if f != nil {
+			f(t, err)
+		}
+	}
+}
+
+func (b *CreateProgramDaysBatchResults) Close() error {
+	b.closed = true
+	return b.br.Close()
+}
+
+const createSets = `-- name: CreateSets :batchexec
+insert into sets
+(userID, workoutID, exerciseID, position, weight, reps, rpe, restSeconds, setType)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`
+
+type CreateSetsBatchResults struct {
+	br     pgx.BatchResults
+	tot    int
+	closed bool
+}
+
+type CreateSetsParams struct {
+	Userid      int32
+	Workoutid   int32
+	Exerciseid  int32
+	Position    int32
+	Weight      float64
+	Reps        int32
+	Rpe         float64
+	Restseconds int32
+	Settype     string
+}
+
+func (q *Queries) CreateSets(ctx context.Context, arg []CreateSetsParams) *CreateSetsBatchResults {
+	batch := &pgx.Batch{}
+	for _, a := range arg {
+		vals := []interface{}{
+			a.Userid,
+			a.Workoutid,
+			a.Exerciseid,
+			a.Position,
+			a.Weight,
+			a.Reps,
+			a.Rpe,
+			a.Restseconds,
+			a.Settype,
+		}
+		batch.Queue(createSets, vals...)
+	}
+	br := q.db.SendBatch(ctx, batch)
+	return &CreateSetsBatchResults{br, len(arg), false}
+}
+
+func (b *CreateSetsBatchResults) Exec(f func(int, error)) {
+	defer b.br.Close()
+	for t := 0; t < b.tot; t++ {
+		if b.closed {
+			if f != nil {
+				f(t, ErrBatchAlreadyClosed)
+			}
+			continue
+		}
+		_, err := b.br.Exec()
+		if f != nil {
+			f(t, err)
+		}
+	}
+}
+
+func (b *CreateSetsBatchResults) Close() error {
+	b.closed = true
+	return b.br.Close()
+}
+
+const upsertCatalogExercises = `-- name: UpsertCatalogExercises :batchexec
+insert into CatalogExercises
+(userID, name, aliases, primaryMuscles, secondaryMuscles, equipment, exerciseType, met)
+values (0, $1, $2, $3, $4, $5, $6, $7)
+on conflict (userID, lower(name)) do update
+set aliases = excluded.aliases, primaryMuscles = excluded.primaryMuscles,
+    secondaryMuscles = excluded.secondaryMuscles, equipment = excluded.equipment,
+    exerciseType = excluded.exerciseType, met = excluded.met
+`
+
+type UpsertCatalogExercisesBatchResults struct {
+	br     pgx.BatchResults
+	tot    int
+	closed bool
+}
+
+type UpsertCatalogExercisesParams struct {
+	Name             string
+	Aliases          []string
+	Primarymuscles   []string
+	Secondarymuscles []string
+	Equipment        string
+	Exercisetype     string
+	Met              float64
+}
+
+func (q *Queries) UpsertCatalogExercises(ctx context.Context, arg []UpsertCatalogExercisesParams) *UpsertCatalogExercisesBatchResults {
+	batch := &pgx.Batch{}
+	for _, a := range arg {
+		vals := []interface{}{
+			a.Name,
+			a.Aliases,
+			a.Primarymuscles,
+			a.Secondarymuscles,
+			a.Equipment,
+			a.Exercisetype,
+			a.Met,
+		}
+		batch.Queue(upsertCatalogExercises, vals...)
+	}
+	br := q.db.SendBatch(ctx, batch)
+	return &UpsertCatalogExercisesBatchResults{br, len(arg), false}
+}
+
+func (b *UpsertCatalogExercisesBatchResults) Exec(f func(int, error)) {
+	defer b.br.Close()
+	for t := 0; t < b.tot; t++ {
+		if b.closed {
+			if f != nil {
+				f(t, ErrBatchAlreadyClosed)
+			}
+			continue
+		}
+		_, err := b.br.Exec()
+		if f != nil {
+			f(t, err)
+		}
+	}
+}
+
+func (b *UpsertCatalogExercisesBatchResults) Close() error {
+	b.closed = true
+	return b.br.Close()
+}
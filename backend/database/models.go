@@ -8,18 +8,65 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type Backfill struct {
+	Name string
+}
+
+type Catalogexercise struct {
+	ID               int32
+	Userid           int32
+	Name             string
+	Aliases          []string
+	Primarymuscles   []string
+	Secondarymuscles []string
+	Equipment        string
+	Exercisetype     string
+	Met              float64
+}
+
+type Change struct {
+	Userid   int32
+	Seq      int64
+	Entity   string
+	Entityid int32
+}
+
+type Device struct {
+	Userid   int32
+	Deviceid string
+	Seq      int64
+	Lastseen int64
+}
+
+type Enrollment struct {
+	ID        int32
+	Userid    int32
+	Programid int32
+	Startdate int64
+	Mode      string
+	Active    bool
+}
+
 type Exercise struct {
-	Lastmodified pgtype.Int8
-	Deleted      bool
-	ID           int32
-	Userid       int32
-	Workoutid    int32
-	Exercisetype string
-	Name         string
-	Weight       int32
-	Weightunit   string
-	Reps         []int32
-	Duration     float64
+	Lastmodified  pgtype.Int8
+	Deleted       bool
+	ID            int32
+	Userid        int32
+	Workoutid     int32
+	Exercisetype  string
+	Name          string
+	Weight        int32
+	Weightunit    string
+	Reps          []int32
+	Duration      float64
+	Position      int32
+	Catalogid     int32
+	Progression   []byte
+	Distance      float64
+	Elevationgain float64
+	Avgheartrate  int32
+	Maxheartrate  int32
+	Groupnumber   int32
 }
 
 type Food struct {
@@ -39,6 +86,16 @@ type Food struct {
 	Iron                float64
 }
 
+type Idempotencykey struct {
+	Userid      int32
+	Key         string
+	Route       string
+	Requesthash string
+	Status      int32
+	Response    string
+	Createdat   int64
+}
+
 type Meal struct {
 	Lastmodified pgtype.Int8
 	Deleted      bool
@@ -49,6 +106,53 @@ type Meal struct {
 	Mealtag      string
 	Servings     float64
 	Unit         string
+	Version      int64
+}
+
+type Personalrecord struct {
+	ID         int32
+	Userid     int32
+	Workoutid  int32
+	Exerciseid int32
+	Catalogid  int32
+	Name       string
+	Kind       string
+	Value      float64
+	Previous   float64
+	Weight     float64
+	Reps       int32
+	Date       int64
+}
+
+type Program struct {
+	ID          int32
+	Userid      int32
+	Name        string
+	Description string
+	Weeks       int32
+}
+
+type Programday struct {
+	ID         int32
+	Userid     int32
+	Programid  int32
+	Week       int32
+	Day        int32
+	Templateid int32
+}
+
+type Programlog struct {
+	ID           int32
+	Userid       int32
+	Enrollmentid int32
+	Dayid        int32
+	Workoutid    int32
+	Date         int64
+}
+
+type Ratelimit struct {
+	Bucket string
+	Fullat float64
 }
 
 type Record struct {
@@ -58,6 +162,21 @@ type Record struct {
 	Recordtype   string
 	Date         int64
 	Value        float64
+	Version      int64
+	ID           int32
+}
+
+type Set struct {
+	ID          int32
+	Userid      int32
+	Workoutid   int32
+	Exerciseid  int32
+	Position    int32
+	Weight      float64
+	Reps        int32
+	Rpe         float64
+	Restseconds int32
+	Settype     string
 }
 
 type Setting struct {
@@ -69,6 +188,15 @@ type Setting struct {
 	Useimperial  bool
 	Darkmode     bool
 	Trackperiod  bool
+	Version      int64
+}
+
+type Track struct {
+	ID         int32
+	Userid     int32
+	Workoutid  int32
+	Exerciseid int32
+	Points     []byte
 }
 
 type User struct {
@@ -76,6 +204,9 @@ type User struct {
 	ID           int32
 	Email        string
 	Password     string
+	Changeseq    int64
+	Purgedseq    int64
+	Purgedat     int64
 }
 
 type Workout struct {
@@ -87,4 +218,9 @@ type Workout struct {
 	Date         int64
 	Istemplate   bool
 	Notes        string
+	Version      int64
+	Templateid   int32
+	Calories     float64
+	Groups       []byte
+	Starttime    int64
 }
@@ -11,11 +11,134 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const backfillSets = `-- name: BackfillSets :exec
+insert into sets (userID, workoutID, exerciseID, position, weight, reps)
+select exercises.userID, exercises.workoutID, exercises.id, r.position - 1, exercises.weight, r.reps
+from exercises, unnest(exercises.reps) with ordinality as r(reps, position)
+where not exists (select 1 from sets where sets.exerciseID = exercises.id)
+`
+
+// (turns the reps of the exercises that were made before there were sets
+// into sets, which all have the exercise's weight. the reps of exercises
+// with sets are always the reps of their sets)
+func (q *Queries) BackfillSets(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, backfillSets)
+	return err
+}
+
+const claimIdempotencyKey = `-- name: ClaimIdempotencyKey :execrows
+insert into IdempotencyKeys (userID, key, route, requestHash, createdAt)
+values ($1, $2, $3, $4, $5)
+on conflict (userID, key) do update
+set route = excluded.route, requestHash = excluded.requestHash,
+    createdAt = excluded.createdAt
+where IdempotencyKeys.status = 0 and IdempotencyKeys.createdAt < $6
+`
+
+type ClaimIdempotencyKeyParams struct {
+	Userid      int32
+	Key         string
+	Route       string
+	Requesthash string
+	Createdat   int64
+	StaleBefore int64
+}
+
+// Keys whose requests have been handled for longer than
+// staleBefore were abandoned, so they can be claimed again.
+func (q *Queries) ClaimIdempotencyKey(ctx context.Context, arg ClaimIdempotencyKeyParams) (int64, error) {
+	result, err := q.db.Exec(ctx, claimIdempotencyKey,
+		arg.Userid,
+		arg.Key,
+		arg.Route,
+		arg.Requesthash,
+		arg.Createdat,
+		arg.StaleBefore,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const clearPersonalRecords = `-- name: ClearPersonalRecords :exec
+delete from PersonalRecords
+`
+
+func (q *Queries) ClearPersonalRecords(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, clearPersonalRecords)
+	return err
+}
+
+const createCatalogExercise = `-- name: CreateCatalogExercise :one
+insert into CatalogExercises
+(userID, name, aliases, primaryMuscles, secondaryMuscles, equipment, exerciseType, met)
+values ($1, $2, $3, $4, $5, $6, $7, $8) returning id
+`
+
+type CreateCatalogExerciseParams struct {
+	Userid           int32
+	Name             string
+	Aliases          []string
+	Primarymuscles   []string
+	Secondarymuscles []string
+	Equipment        string
+	Exercisetype     string
+	Met              float64
+}
+
+func (q *Queries) CreateCatalogExercise(ctx context.Context, arg CreateCatalogExerciseParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createCatalogExercise,
+		arg.Userid,
+		arg.Name,
+		arg.Aliases,
+		arg.Primarymuscles,
+		arg.Secondarymuscles,
+		arg.Equipment,
+		arg.Exercisetype,
+		arg.Met,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createEnrollment = `-- name: CreateEnrollment :one
+insert into Enrollments (userID, programID, startDate, mode)
+values ($1, $2, $3, $4) returning id, userid, programid, startdate, mode, active
+`
+
+type CreateEnrollmentParams struct {
+	Userid    int32
+	Programid int32
+	Startdate int64
+	Mode      string
+}
+
+func (q *Queries) CreateEnrollment(ctx context.Context, arg CreateEnrollmentParams) (Enrollment, error) {
+	row := q.db.QueryRow(ctx, createEnrollment,
+		arg.Userid,
+		arg.Programid,
+		arg.Startdate,
+		arg.Mode,
+	)
+	var i Enrollment
+	err := row.Scan(
+		&i.ID,
+		&i.Userid,
+		&i.Programid,
+		&i.Startdate,
+		&i.Mode,
+		&i.Active,
+	)
+	return i, err
+}
+
 const createFood = `-- name: CreateFood :one
 insert into foods
 (userID, name, servingSizes, servingUnits, defaultServingIndex,
-calories, carbohydrate, protein, fat, calcium, potassium, iron)
-values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+calories, carbohydrate, protein, fat, calcium, potassium, iron, lastModified)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 returning id
 `
 
@@ -32,6 +155,7 @@ type CreateFoodParams struct {
 	Calcium             float64
 	Potassium           float64
 	Iron                float64
+	Lastmodified        pgtype.Int8
 }
 
 func (q *Queries) CreateFood(ctx context.Context, arg CreateFoodParams) (int32, error) {
@@ -48,6 +172,7 @@ func (q *Queries) CreateFood(ctx context.Context, arg CreateFoodParams) (int32,
 		arg.Calcium,
 		arg.Potassium,
 		arg.Iron,
+		arg.Lastmodified,
 	)
 	var id int32
 	err := row.Scan(&id)
@@ -56,17 +181,18 @@ func (q *Queries) CreateFood(ctx context.Context, arg CreateFoodParams) (int32,
 
 const createMeal = `-- name: CreateMeal :one
 insert into meals
-(userID, foodID, date, mealTag, servings, unit)
-values ($1, $2, $3, $4, $5, $6) returning id
+(userID, foodID, date, mealTag, servings, unit, lastModified)
+values ($1, $2, $3, $4, $5, $6, $7) returning id
 `
 
 type CreateMealParams struct {
-	Userid   int32
-	Foodid   int32
-	Date     int64
-	Mealtag  string
-	Servings float64
-	Unit     string
+	Userid       int32
+	Foodid       int32
+	Date         int64
+	Mealtag      string
+	Servings     float64
+	Unit         string
+	Lastmodified pgtype.Int8
 }
 
 func (q *Queries) CreateMeal(ctx context.Context, arg CreateMealParams) (int32, error) {
@@ -77,12 +203,65 @@ func (q *Queries) CreateMeal(ctx context.Context, arg CreateMealParams) (int32,
 		arg.Mealtag,
 		arg.Servings,
 		arg.Unit,
+		arg.Lastmodified,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createProgram = `-- name: CreateProgram :one
+insert into Programs (userID, name, description, weeks)
+values ($1, $2, $3, $4) returning id
+`
+
+type CreateProgramParams struct {
+	Userid      int32
+	Name        string
+	Description string
+	Weeks       int32
+}
+
+func (q *Queries) CreateProgram(ctx context.Context, arg CreateProgramParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createProgram,
+		arg.Userid,
+		arg.Name,
+		arg.Description,
+		arg.Weeks,
 	)
 	var id int32
 	err := row.Scan(&id)
 	return id, err
 }
 
+const createProgramLog = `-- name: CreateProgramLog :execrows
+insert into ProgramLogs (userID, enrollmentID, dayID, workoutID, date)
+values ($1, $2, $3, $4, $5)
+on conflict (enrollmentID, dayID) do nothing
+`
+
+type CreateProgramLogParams struct {
+	Userid       int32
+	Enrollmentid int32
+	Dayid        int32
+	Workoutid    int32
+	Date         int64
+}
+
+func (q *Queries) CreateProgramLog(ctx context.Context, arg CreateProgramLogParams) (int64, error) {
+	result, err := q.db.Exec(ctx, createProgramLog,
+		arg.Userid,
+		arg.Enrollmentid,
+		arg.Dayid,
+		arg.Workoutid,
+		arg.Date,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const createUser = `-- name: CreateUser :one
 insert into users (email, password) values ($1, $2) returning id
 `
@@ -100,16 +279,21 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (int32,
 }
 
 const createWorkout = `-- name: CreateWorkout :one
-insert into workouts (userID, name, notes, date, isTemplate)
-values ($1, $2, $3, $4, $5) returning id
+insert into workouts
+(userID, name, notes, date, isTemplate, templateID, calories, groups, lastModified)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id
 `
 
 type CreateWorkoutParams struct {
-	Userid     int32
-	Name       string
-	Notes      string
-	Date       int64
-	Istemplate bool
+	Userid       int32
+	Name         string
+	Notes        string
+	Date         int64
+	Istemplate   bool
+	Templateid   int32
+	Calories     float64
+	Groups       []byte
+	Lastmodified pgtype.Int8
 }
 
 func (q *Queries) CreateWorkout(ctx context.Context, arg CreateWorkoutParams) (int32, error) {
@@ -119,6 +303,10 @@ func (q *Queries) CreateWorkout(ctx context.Context, arg CreateWorkoutParams) (i
 		arg.Notes,
 		arg.Date,
 		arg.Istemplate,
+		arg.Templateid,
+		arg.Calories,
+		arg.Groups,
+		arg.Lastmodified,
 	)
 	var id int32
 	err := row.Scan(&id)
@@ -140,184 +328,1544 @@ func (q *Queries) DeleteExercise(ctx context.Context, arg DeleteExerciseParams)
 	return err
 }
 
-const deleteMeal = `-- name: DeleteMeal :exec
-update meals set deleted = true, lastModified = $1
-where userID = $2 and id = $3
+const deleteExerciseRecords = `-- name: DeleteExerciseRecords :exec
+delete from PersonalRecords
+where userID = $1
+  and case when $2::int <> 0 then catalogID = $2
+      else catalogID = 0 and lower(name) = lower($3) end
+  and date >= $4
+`
+
+type DeleteExerciseRecordsParams struct {
+	Userid    int32
+	Catalogid int32
+	Name      string
+	Date      int64
+}
+
+// (the records of the exercise, matched like GetExerciseHistory, from the date on)
+func (q *Queries) DeleteExerciseRecords(ctx context.Context, arg DeleteExerciseRecordsParams) error {
+	_, err := q.db.Exec(ctx, deleteExerciseRecords,
+		arg.Userid,
+		arg.Catalogid,
+		arg.Name,
+		arg.Date,
+	)
+	return err
+}
+
+const deleteExpiredIdempotencyKeys = `-- name: DeleteExpiredIdempotencyKeys :exec
+delete from IdempotencyKeys where createdAt < $1
+`
+
+func (q *Queries) DeleteExpiredIdempotencyKeys(ctx context.Context, createdat int64) error {
+	_, err := q.db.Exec(ctx, deleteExpiredIdempotencyKeys, createdat)
+	return err
+}
+
+const deleteFullRateLimits = `-- name: DeleteFullRateLimits :exec
+delete from rateLimits where fullAt < $1
+`
+
+func (q *Queries) DeleteFullRateLimits(ctx context.Context, fullat float64) error {
+	_, err := q.db.Exec(ctx, deleteFullRateLimits, fullat)
+	return err
+}
+
+const deleteIdempotencyKey = `-- name: DeleteIdempotencyKey :exec
+delete from IdempotencyKeys where userID = $1 and key = $2
+`
+
+type DeleteIdempotencyKeyParams struct {
+	Userid int32
+	Key    string
+}
+
+func (q *Queries) DeleteIdempotencyKey(ctx context.Context, arg DeleteIdempotencyKeyParams) error {
+	_, err := q.db.Exec(ctx, deleteIdempotencyKey, arg.Userid, arg.Key)
+	return err
+}
+
+const deleteMeal = `-- name: DeleteMeal :execrows
+update meals set deleted = true, lastModified = $1, version = version + 1
+where userID = $2 and id = $3 and version = coalesce($4, version)
 `
 
 type DeleteMealParams struct {
 	Lastmodified pgtype.Int8
 	Userid       int32
 	ID           int32
+	BaseVersion  pgtype.Int8
 }
 
-func (q *Queries) DeleteMeal(ctx context.Context, arg DeleteMealParams) error {
-	_, err := q.db.Exec(ctx, deleteMeal, arg.Lastmodified, arg.Userid, arg.ID)
-	return err
+// (deletes no rows when the meal isn't at the base version)
+func (q *Queries) DeleteMeal(ctx context.Context, arg DeleteMealParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteMeal,
+		arg.Lastmodified,
+		arg.Userid,
+		arg.ID,
+		arg.BaseVersion,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-const deleteRecord = `-- name: DeleteRecord :exec
-update records set deleted = true, lastModified = $1 where userID = $2 and date = $3
+const deleteOtherExerciseSets = `-- name: DeleteOtherExerciseSets :exec
+delete from sets where workoutID = $1 and userID = $2
+  and exerciseID <> all($3::int[])
 `
 
-type DeleteRecordParams struct {
-	Lastmodified pgtype.Int8
-	Userid       int32
-	Date         int64
+type DeleteOtherExerciseSetsParams struct {
+	Workoutid int32
+	Userid    int32
+	Keep      []int32
 }
 
-func (q *Queries) DeleteRecord(ctx context.Context, arg DeleteRecordParams) error {
-	_, err := q.db.Exec(ctx, deleteRecord, arg.Lastmodified, arg.Userid, arg.Date)
+// (the sets of the exercises DeleteOtherExercises removes)
+func (q *Queries) DeleteOtherExerciseSets(ctx context.Context, arg DeleteOtherExerciseSetsParams) error {
+	_, err := q.db.Exec(ctx, deleteOtherExerciseSets, arg.Workoutid, arg.Userid, arg.Keep)
 	return err
 }
 
-const deleteWorkout = `-- name: DeleteWorkout :exec
-update workouts set deleted = true, lastModified = $1 where userID = $2 and id = $3
+const deleteOtherExercises = `-- name: DeleteOtherExercises :exec
+update exercises set deleted = true, lastModified = $1
+where workoutID = $2 and userID = $3 and deleted = false
+  and id <> all($4::int[])
 `
 
-type DeleteWorkoutParams struct {
+type DeleteOtherExercisesParams struct {
 	Lastmodified pgtype.Int8
+	Workoutid    int32
 	Userid       int32
-	ID           int32
+	Keep         []int32
 }
 
-func (q *Queries) DeleteWorkout(ctx context.Context, arg DeleteWorkoutParams) error {
-	_, err := q.db.Exec(ctx, deleteWorkout, arg.Lastmodified, arg.Userid, arg.ID)
+// (soft deletes the workout's exercises that aren't in keep)
+func (q *Queries) DeleteOtherExercises(ctx context.Context, arg DeleteOtherExercisesParams) error {
+	_, err := q.db.Exec(ctx, deleteOtherExercises,
+		arg.Lastmodified,
+		arg.Workoutid,
+		arg.Userid,
+		arg.Keep,
+	)
 	return err
 }
 
-const getExercises = `-- name: GetExercises :many
-select lastmodified, deleted, id, userid, workoutid, exercisetype, name, weight, weightunit, reps, duration from exercises
-where userID = $1 and workoutID = $2
-  and deleted = coalesce($3, deleted)
+const deleteProgram = `-- name: DeleteProgram :execrows
+delete from Programs where id = $1 and userID = $2
 `
 
-type GetExercisesParams struct {
-	Userid        int32
-	Workoutid     int32
-	IgnoreDeleted pgtype.Bool
+type DeleteProgramParams struct {
+	ID     int32
+	Userid int32
 }
 
-func (q *Queries) GetExercises(ctx context.Context, arg GetExercisesParams) ([]Exercise, error) {
-	rows, err := q.db.Query(ctx, getExercises, arg.Userid, arg.Workoutid, arg.IgnoreDeleted)
+func (q *Queries) DeleteProgram(ctx context.Context, arg DeleteProgramParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteProgram, arg.ID, arg.Userid)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Exercise
-	for rows.Next() {
-		var i Exercise
-		if err := rows.Scan(
-			&i.Lastmodified,
-			&i.Deleted,
-			&i.ID,
-			&i.Userid,
-			&i.Workoutid,
-			&i.Exercisetype,
-			&i.Name,
-			&i.Weight,
-			&i.Weightunit,
-			&i.Reps,
-			&i.Duration,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+		return 0, err
 	}
-	return items, nil
+	return result.RowsAffected(), nil
 }
 
-const getFoodByID = `-- name: GetFoodByID :one
-select lastmodified, id, userid, name, defaultservingindex, servingsizes, servingunits, calories, carbohydrate, protein, fat, calcium, potassium, iron from foods where id = $1
+const deleteProgramDays = `-- name: DeleteProgramDays :exec
+delete from ProgramDays where programID = $1 and userID = $2
 `
 
-func (q *Queries) GetFoodByID(ctx context.Context, id int32) (Food, error) {
-	row := q.db.QueryRow(ctx, getFoodByID, id)
-	var i Food
-	err := row.Scan(
-		&i.Lastmodified,
-		&i.ID,
-		&i.Userid,
-		&i.Name,
-		&i.Defaultservingindex,
-		&i.Servingsizes,
-		&i.Servingunits,
-		&i.Calories,
-		&i.Carbohydrate,
-		&i.Protein,
-		&i.Fat,
-		&i.Calcium,
-		&i.Potassium,
-		&i.Iron,
-	)
-	return i, err
+type DeleteProgramDaysParams struct {
+	Programid int32
+	Userid    int32
 }
 
-const getMealsForDay = `-- name: GetMealsForDay :many
-select lastmodified, deleted, id, userid, foodid, date, mealtag, servings, unit from meals where date = $1 and userID = $2 and deleted = false
+func (q *Queries) DeleteProgramDays(ctx context.Context, arg DeleteProgramDaysParams) error {
+	_, err := q.db.Exec(ctx, deleteProgramDays, arg.Programid, arg.Userid)
+	return err
+}
+
+const deleteRecord = `-- name: DeleteRecord :execrows
+update records set deleted = true, lastModified = $1, version = version + 1
+where userID = $2 and recordType = $3 and date = $4
+  and version = coalesce($5, version)
 `
 
-type GetMealsForDayParams struct {
-	Date   int64
-	Userid int32
+type DeleteRecordParams struct {
+	Lastmodified pgtype.Int8
+	Userid       int32
+	Recordtype   string
+	Date         int64
+	BaseVersion  pgtype.Int8
 }
 
-func (q *Queries) GetMealsForDay(ctx context.Context, arg GetMealsForDayParams) ([]Meal, error) {
-	rows, err := q.db.Query(ctx, getMealsForDay, arg.Date, arg.Userid)
+// (deletes no rows when the record isn't at the base version)
+func (q *Queries) DeleteRecord(ctx context.Context, arg DeleteRecordParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteRecord,
+		arg.Lastmodified,
+		arg.Userid,
+		arg.Recordtype,
+		arg.Date,
+		arg.BaseVersion,
+	)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Meal
-	for rows.Next() {
-		var i Meal
-		if err := rows.Scan(
-			&i.Lastmodified,
-			&i.Deleted,
-			&i.ID,
-			&i.Userid,
-			&i.Foodid,
-			&i.Date,
-			&i.Mealtag,
-			&i.Servings,
-			&i.Unit,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+		return 0, err
 	}
-	return items, nil
+	return result.RowsAffected(), nil
 }
 
-const getUpdatedMeals = `-- name: GetUpdatedMeals :many
-select lastmodified, deleted, id, userid, foodid, date, mealtag, servings, unit from meals where userID = $1 and lastModified >= $2
-  and deleted = coalesce($3, deleted)
+const deleteSets = `-- name: DeleteSets :exec
+delete from sets where exerciseID = $1 and userID = $2
 `
 
-type GetUpdatedMealsParams struct {
-	Userid        int32
-	Lastmodified  pgtype.Int8
-	IgnoreDeleted pgtype.Bool
+type DeleteSetsParams struct {
+	Exerciseid int32
+	Userid     int32
 }
 
-func (q *Queries) GetUpdatedMeals(ctx context.Context, arg GetUpdatedMealsParams) ([]Meal, error) {
-	rows, err := q.db.Query(ctx, getUpdatedMeals, arg.Userid, arg.Lastmodified, arg.IgnoreDeleted)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Meal
-	for rows.Next() {
-		var i Meal
-		if err := rows.Scan(
-			&i.Lastmodified,
-			&i.Deleted,
+func (q *Queries) DeleteSets(ctx context.Context, arg DeleteSetsParams) error {
+	_, err := q.db.Exec(ctx, deleteSets, arg.Exerciseid, arg.Userid)
+	return err
+}
+
+const deleteStaleDevices = `-- name: DeleteStaleDevices :exec
+delete from devices where lastSeen < $1
+`
+
+func (q *Queries) DeleteStaleDevices(ctx context.Context, lastseen int64) error {
+	_, err := q.db.Exec(ctx, deleteStaleDevices, lastseen)
+	return err
+}
+
+const deleteWorkout = `-- name: DeleteWorkout :execrows
+update workouts set deleted = true, lastModified = $1, version = version + 1
+where userID = $2 and id = $3 and version = coalesce($4, version)
+`
+
+type DeleteWorkoutParams struct {
+	Lastmodified pgtype.Int8
+	Userid       int32
+	ID           int32
+	BaseVersion  pgtype.Int8
+}
+
+// (deletes no rows when the workout isn't at the base version)
+func (q *Queries) DeleteWorkout(ctx context.Context, arg DeleteWorkoutParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteWorkout,
+		arg.Lastmodified,
+		arg.Userid,
+		arg.ID,
+		arg.BaseVersion,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const endEnrollments = `-- name: EndEnrollments :exec
+update Enrollments set active = false
+where userID = $1 and active and ($2::int = 0 or programID = $2)
+`
+
+type EndEnrollmentsParams struct {
+	Userid    int32
+	Programid int32
+}
+
+// (ends the user's enrollment in any program, or in the program when it isn't 0)
+func (q *Queries) EndEnrollments(ctx context.Context, arg EndEnrollmentsParams) error {
+	_, err := q.db.Exec(ctx, endEnrollments, arg.Userid, arg.Programid)
+	return err
+}
+
+const findCatalogExercise = `-- name: FindCatalogExercise :one
+select id from CatalogExercises
+where userID in (0, $1)
+  and (lower(name) = lower($2)
+    or exists (select 1 from unnest(aliases) alias where lower(alias) = lower($2)))
+order by lower(name) = lower($2) desc, userID desc, id limit 1
+`
+
+type FindCatalogExerciseParams struct {
+	Userid int32
+	Name   string
+}
+
+// (finds the exercise the user can log with the name or alias, ignoring
+// case, preferring exercises with the name, then the user's own exercises)
+func (q *Queries) FindCatalogExercise(ctx context.Context, arg FindCatalogExerciseParams) (int32, error) {
+	row := q.db.QueryRow(ctx, findCatalogExercise, arg.Userid, arg.Name)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const findWorkout = `-- name: FindWorkout :one
+select id from workouts
+where userID = $1 and date = $2 and lower(name) = lower($3) and startTime in (0, $4)
+  and not deleted and not isTemplate
+limit 1
+`
+
+type FindWorkoutParams struct {
+	Userid    int32
+	Date      int64
+	Name      string
+	Starttime int64
+}
+
+// (finds the workout with the name on the date that started at the start
+// time, or whose start time isn't known)
+func (q *Queries) FindWorkout(ctx context.Context, arg FindWorkoutParams) (int32, error) {
+	row := q.db.QueryRow(ctx, findWorkout,
+		arg.Userid,
+		arg.Date,
+		arg.Name,
+		arg.Starttime,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const getActiveEnrollment = `-- name: GetActiveEnrollment :one
+select id, userid, programid, startdate, mode, active from Enrollments where userID = $1 and active
+`
+
+func (q *Queries) GetActiveEnrollment(ctx context.Context, userid int32) (Enrollment, error) {
+	row := q.db.QueryRow(ctx, getActiveEnrollment, userid)
+	var i Enrollment
+	err := row.Scan(
+		&i.ID,
+		&i.Userid,
+		&i.Programid,
+		&i.Startdate,
+		&i.Mode,
+		&i.Active,
+	)
+	return i, err
+}
+
+const getBodyWeight = `-- name: GetBodyWeight :one
+select value from records
+where userID = $1 and recordType = 'weight' and not deleted
+order by date > $2, abs(date - $2)
+limit 1
+`
+
+type GetBodyWeightParams struct {
+	Userid int32
+	Date   int64
+}
+
+// (the weight closest to the date, preferring the ones before it)
+func (q *Queries) GetBodyWeight(ctx context.Context, arg GetBodyWeightParams) (float64, error) {
+	row := q.db.QueryRow(ctx, getBodyWeight, arg.Userid, arg.Date)
+	var value float64
+	err := row.Scan(&value)
+	return value, err
+}
+
+const getCaloriesBurned = `-- name: GetCaloriesBurned :many
+select date, sum(calories)::float as calories from workouts
+where userID = $1 and not deleted and not isTemplate
+  and date >= $2 and date <= $3
+group by date order by date
+`
+
+type GetCaloriesBurnedParams struct {
+	Userid   int32
+	FromDate int64
+	ToDate   int64
+}
+
+type GetCaloriesBurnedRow struct {
+	Date     int64
+	Calories float64
+}
+
+func (q *Queries) GetCaloriesBurned(ctx context.Context, arg GetCaloriesBurnedParams) ([]GetCaloriesBurnedRow, error) {
+	rows, err := q.db.Query(ctx, getCaloriesBurned, arg.Userid, arg.FromDate, arg.ToDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetCaloriesBurnedRow
+	for rows.Next() {
+		var i GetCaloriesBurnedRow
+		if err := rows.Scan(&i.Date, &i.Calories); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getCatalogExercise = `-- name: GetCatalogExercise :one
+select id, userid, name, aliases, primarymuscles, secondarymuscles, equipment, exercisetype, met from CatalogExercises where id = $1 and userID in (0, $2)
+`
+
+type GetCatalogExerciseParams struct {
+	ID     int32
+	Userid int32
+}
+
+func (q *Queries) GetCatalogExercise(ctx context.Context, arg GetCatalogExerciseParams) (Catalogexercise, error) {
+	row := q.db.QueryRow(ctx, getCatalogExercise, arg.ID, arg.Userid)
+	var i Catalogexercise
+	err := row.Scan(
+		&i.ID,
+		&i.Userid,
+		&i.Name,
+		&i.Aliases,
+		&i.Primarymuscles,
+		&i.Secondarymuscles,
+		&i.Equipment,
+		&i.Exercisetype,
+		&i.Met,
+	)
+	return i, err
+}
+
+const getChanges = `-- name: GetChanges :many
+select userid, seq, entity, entityid from changes where userID = $1 and seq > $2 order by seq limit $3
+`
+
+type GetChangesParams struct {
+	Userid int32
+	Seq    int64
+	Limit  int32
+}
+
+func (q *Queries) GetChanges(ctx context.Context, arg GetChangesParams) ([]Change, error) {
+	rows, err := q.db.Query(ctx, getChanges, arg.Userid, arg.Seq, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Change
+	for rows.Next() {
+		var i Change
+		if err := rows.Scan(
+			&i.Userid,
+			&i.Seq,
+			&i.Entity,
+			&i.Entityid,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getExerciseHistory = `-- name: GetExerciseHistory :many
+select workouts.id as workoutID, workouts.date, exercises.id as exerciseID,
+  exercises.exerciseType, exercises.weightUnit, exercises.duration, exercises.distance,
+  coalesce(sets.weight, 0)::float as weight, coalesce(sets.reps, 0)::int as reps
+from exercises
+join workouts on workouts.id = exercises.workoutID
+left join sets on sets.exerciseID = exercises.id and sets.setType <> 'warmup'
+where exercises.userID = $1
+  and not exercises.deleted and not workouts.deleted and not workouts.isTemplate
+  and case when $2::int <> 0 then exercises.catalogID = $2
+      else exercises.catalogID = 0 and lower(trim(exercises.name)) = lower($3) end
+order by workouts.date, workouts.id, exercises.id, sets.position
+`
+
+type GetExerciseHistoryParams struct {
+	Userid    int32
+	Catalogid int32
+	Name      string
+}
+
+type GetExerciseHistoryRow struct {
+	Workoutid    int32
+	Date         int64
+	Exerciseid   int32
+	Exercisetype string
+	Weightunit   string
+	Duration     float64
+	Distance     float64
+	Weight       float64
+	Reps         int32
+}
+
+// (the sets of the catalog exercise, or the exercise with the name when it
+// isn't in the catalog, in the order the workouts were done. exercises
+// without sets, like cardio exercises, have a row with 0 reps)
+func (q *Queries) GetExerciseHistory(ctx context.Context, arg GetExerciseHistoryParams) ([]GetExerciseHistoryRow, error) {
+	rows, err := q.db.Query(ctx, getExerciseHistory, arg.Userid, arg.Catalogid, arg.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetExerciseHistoryRow
+	for rows.Next() {
+		var i GetExerciseHistoryRow
+		if err := rows.Scan(
+			&i.Workoutid,
+			&i.Date,
+			&i.Exerciseid,
+			&i.Exercisetype,
+			&i.Weightunit,
+			&i.Duration,
+			&i.Distance,
+			&i.Weight,
+			&i.Reps,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getExerciseRecords = `-- name: GetExerciseRecords :many
+select id, userid, workoutid, exerciseid, catalogid, name, kind, value, previous, weight, reps, date from PersonalRecords
+where userID = $1
+  and case when $2::int <> 0 then catalogID = $2
+      else catalogID = 0 and lower(name) = lower(trim($3)) end
+order by date desc, id desc
+`
+
+type GetExerciseRecordsParams struct {
+	Userid    int32
+	Catalogid int32
+	Name      string
+}
+
+func (q *Queries) GetExerciseRecords(ctx context.Context, arg GetExerciseRecordsParams) ([]Personalrecord, error) {
+	rows, err := q.db.Query(ctx, getExerciseRecords, arg.Userid, arg.Catalogid, arg.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Personalrecord
+	for rows.Next() {
+		var i Personalrecord
+		if err := rows.Scan(
+			&i.ID,
+			&i.Userid,
+			&i.Workoutid,
+			&i.Exerciseid,
+			&i.Catalogid,
+			&i.Name,
+			&i.Kind,
+			&i.Value,
+			&i.Previous,
+			&i.Weight,
+			&i.Reps,
+			&i.Date,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getExerciseSets = `-- name: GetExerciseSets :many
+select id, userid, workoutid, exerciseid, position, weight, reps, rpe, restseconds, settype from sets where exerciseID = $1 and userID = $2 order by position
+`
+
+type GetExerciseSetsParams struct {
+	Exerciseid int32
+	Userid     int32
+}
+
+func (q *Queries) GetExerciseSets(ctx context.Context, arg GetExerciseSetsParams) ([]Set, error) {
+	rows, err := q.db.Query(ctx, getExerciseSets, arg.Exerciseid, arg.Userid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Set
+	for rows.Next() {
+		var i Set
+		if err := rows.Scan(
+			&i.ID,
+			&i.Userid,
+			&i.Workoutid,
+			&i.Exerciseid,
+			&i.Position,
+			&i.Weight,
+			&i.Reps,
+			&i.Rpe,
+			&i.Restseconds,
+			&i.Settype,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getExercises = `-- name: GetExercises :many
+select lastmodified, deleted, id, userid, workoutid, exercisetype, name, weight, weightunit, reps, duration, position, catalogid, progression, distance, elevationgain, avgheartrate, maxheartrate, groupnumber from exercises
+where userID = $1 and workoutID = $2
+  and deleted = coalesce($3, deleted)
+order by position, id
+`
+
+type GetExercisesParams struct {
+	Userid        int32
+	Workoutid     int32
+	IgnoreDeleted pgtype.Bool
+}
+
+func (q *Queries) GetExercises(ctx context.Context, arg GetExercisesParams) ([]Exercise, error) {
+	rows, err := q.db.Query(ctx, getExercises, arg.Userid, arg.Workoutid, arg.IgnoreDeleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Exercise
+	for rows.Next() {
+		var i Exercise
+		if err := rows.Scan(
+			&i.Lastmodified,
+			&i.Deleted,
+			&i.ID,
+			&i.Userid,
+			&i.Workoutid,
+			&i.Exercisetype,
+			&i.Name,
+			&i.Weight,
+			&i.Weightunit,
+			&i.Reps,
+			&i.Duration,
+			&i.Position,
+			&i.Catalogid,
+			&i.Progression,
+			&i.Distance,
+			&i.Elevationgain,
+			&i.Avgheartrate,
+			&i.Maxheartrate,
+			&i.Groupnumber,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getExercisesOfWorkouts = `-- name: GetExercisesOfWorkouts :many
+select lastmodified, deleted, id, userid, workoutid, exercisetype, name, weight, weightunit, reps, duration, position, catalogid, progression, distance, elevationgain, avgheartrate, maxheartrate, groupnumber from exercises
+where userID = $1 and workoutID = any($2::int[]) and not deleted
+order by workoutID, position, id
+`
+
+type GetExercisesOfWorkoutsParams struct {
+	Userid int32
+	Ids    []int32
+}
+
+func (q *Queries) GetExercisesOfWorkouts(ctx context.Context, arg GetExercisesOfWorkoutsParams) ([]Exercise, error) {
+	rows, err := q.db.Query(ctx, getExercisesOfWorkouts, arg.Userid, arg.Ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Exercise
+	for rows.Next() {
+		var i Exercise
+		if err := rows.Scan(
+			&i.Lastmodified,
+			&i.Deleted,
+			&i.ID,
+			&i.Userid,
+			&i.Workoutid,
+			&i.Exercisetype,
+			&i.Name,
+			&i.Weight,
+			&i.Weightunit,
+			&i.Reps,
+			&i.Duration,
+			&i.Position,
+			&i.Catalogid,
+			&i.Progression,
+			&i.Distance,
+			&i.Elevationgain,
+			&i.Avgheartrate,
+			&i.Maxheartrate,
+			&i.Groupnumber,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFoodByID = `-- name: GetFoodByID :one
+select lastmodified, id, userid, name, defaultservingindex, servingsizes, servingunits, calories, carbohydrate, protein, fat, calcium, potassium, iron from foods where id = $1
+`
+
+func (q *Queries) GetFoodByID(ctx context.Context, id int32) (Food, error) {
+	row := q.db.QueryRow(ctx, getFoodByID, id)
+	var i Food
+	err := row.Scan(
+		&i.Lastmodified,
+		&i.ID,
+		&i.Userid,
+		&i.Name,
+		&i.Defaultservingindex,
+		&i.Servingsizes,
+		&i.Servingunits,
+		&i.Calories,
+		&i.Carbohydrate,
+		&i.Protein,
+		&i.Fat,
+		&i.Calcium,
+		&i.Potassium,
+		&i.Iron,
+	)
+	return i, err
+}
+
+const getFoodsByID = `-- name: GetFoodsByID :many
+select lastmodified, id, userid, name, defaultservingindex, servingsizes, servingunits, calories, carbohydrate, protein, fat, calcium, potassium, iron from foods where id = any($1::int[])
+`
+
+func (q *Queries) GetFoodsByID(ctx context.Context, ids []int32) ([]Food, error) {
+	rows, err := q.db.Query(ctx, getFoodsByID, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Food
+	for rows.Next() {
+		var i Food
+		if err := rows.Scan(
+			&i.Lastmodified,
+			&i.ID,
+			&i.Userid,
+			&i.Name,
+			&i.Defaultservingindex,
+			&i.Servingsizes,
+			&i.Servingunits,
+			&i.Calories,
+			&i.Carbohydrate,
+			&i.Protein,
+			&i.Fat,
+			&i.Calcium,
+			&i.Potassium,
+			&i.Iron,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getIdempotencyKey = `-- name: GetIdempotencyKey :one
+select userid, key, route, requesthash, status, response, createdat from IdempotencyKeys where userID = $1 and key = $2
+`
+
+type GetIdempotencyKeyParams struct {
+	Userid int32
+	Key    string
+}
+
+func (q *Queries) GetIdempotencyKey(ctx context.Context, arg GetIdempotencyKeyParams) (Idempotencykey, error) {
+	row := q.db.QueryRow(ctx, getIdempotencyKey, arg.Userid, arg.Key)
+	var i Idempotencykey
+	err := row.Scan(
+		&i.Userid,
+		&i.Key,
+		&i.Route,
+		&i.Requesthash,
+		&i.Status,
+		&i.Response,
+		&i.Createdat,
+	)
+	return i, err
+}
+
+const getLastPerformance = `-- name: GetLastPerformance :one
+select exercises.lastmodified, exercises.deleted, exercises.id, exercises.userid, exercises.workoutid, exercises.exercisetype, exercises.name, exercises.weight, exercises.weightunit, exercises.reps, exercises.duration, exercises.position, exercises.catalogid, exercises.progression, exercises.distance, exercises.elevationgain, exercises.avgheartrate, exercises.maxheartrate, exercises.groupnumber from exercises
+join workouts on workouts.id = exercises.workoutID
+where exercises.userID = $1 and not exercises.deleted
+  and not workouts.deleted and not workouts.isTemplate
+  and case when $2::int <> 0 then exercises.catalogID = $2
+      else lower(exercises.name) = lower($3) end
+order by workouts.date desc, exercises.id desc limit 1
+`
+
+type GetLastPerformanceParams struct {
+	Userid    int32
+	Catalogid int32
+	Name      string
+}
+
+// (the last time the user did the catalog exercise, or the exercise
+// with the name when it isn't in the catalog, outside of templates)
+func (q *Queries) GetLastPerformance(ctx context.Context, arg GetLastPerformanceParams) (Exercise, error) {
+	row := q.db.QueryRow(ctx, getLastPerformance, arg.Userid, arg.Catalogid, arg.Name)
+	var i Exercise
+	err := row.Scan(
+		&i.Lastmodified,
+		&i.Deleted,
+		&i.ID,
+		&i.Userid,
+		&i.Workoutid,
+		&i.Exercisetype,
+		&i.Name,
+		&i.Weight,
+		&i.Weightunit,
+		&i.Reps,
+		&i.Duration,
+		&i.Position,
+		&i.Catalogid,
+		&i.Progression,
+		&i.Distance,
+		&i.Elevationgain,
+		&i.Avgheartrate,
+		&i.Maxheartrate,
+		&i.Groupnumber,
+	)
+	return i, err
+}
+
+const getMeal = `-- name: GetMeal :one
+select lastmodified, deleted, id, userid, foodid, date, mealtag, servings, unit, version from meals where id = $1 and userID = $2 and deleted = false
+`
+
+type GetMealParams struct {
+	ID     int32
+	Userid int32
+}
+
+func (q *Queries) GetMeal(ctx context.Context, arg GetMealParams) (Meal, error) {
+	row := q.db.QueryRow(ctx, getMeal, arg.ID, arg.Userid)
+	var i Meal
+	err := row.Scan(
+		&i.Lastmodified,
+		&i.Deleted,
+		&i.ID,
+		&i.Userid,
+		&i.Foodid,
+		&i.Date,
+		&i.Mealtag,
+		&i.Servings,
+		&i.Unit,
+		&i.Version,
+	)
+	return i, err
+}
+
+const getMealsByID = `-- name: GetMealsByID :many
+select lastmodified, deleted, id, userid, foodid, date, mealtag, servings, unit, version from meals where userID = $1 and id = any($2::int[])
+`
+
+type GetMealsByIDParams struct {
+	Userid int32
+	Ids    []int32
+}
+
+func (q *Queries) GetMealsByID(ctx context.Context, arg GetMealsByIDParams) ([]Meal, error) {
+	rows, err := q.db.Query(ctx, getMealsByID, arg.Userid, arg.Ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Meal
+	for rows.Next() {
+		var i Meal
+		if err := rows.Scan(
+			&i.Lastmodified,
+			&i.Deleted,
+			&i.ID,
+			&i.Userid,
+			&i.Foodid,
+			&i.Date,
+			&i.Mealtag,
+			&i.Servings,
+			&i.Unit,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMealsForDay = `-- name: GetMealsForDay :many
+select lastmodified, deleted, id, userid, foodid, date, mealtag, servings, unit, version from meals where date = $1 and userID = $2 and deleted = false
+`
+
+type GetMealsForDayParams struct {
+	Date   int64
+	Userid int32
+}
+
+func (q *Queries) GetMealsForDay(ctx context.Context, arg GetMealsForDayParams) ([]Meal, error) {
+	rows, err := q.db.Query(ctx, getMealsForDay, arg.Date, arg.Userid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Meal
+	for rows.Next() {
+		var i Meal
+		if err := rows.Scan(
+			&i.Lastmodified,
+			&i.Deleted,
+			&i.ID,
+			&i.Userid,
+			&i.Foodid,
+			&i.Date,
+			&i.Mealtag,
+			&i.Servings,
+			&i.Unit,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMealsInRange = `-- name: GetMealsInRange :many
+select lastmodified, deleted, id, userid, foodid, date, mealtag, servings, unit, version from meals
+where userID = $1 and date >= $2 and date <= $3
+  and deleted = false
+order by date, id
+`
+
+type GetMealsInRangeParams struct {
+	Userid   int32
+	FromDate int64
+	ToDate   int64
+}
+
+func (q *Queries) GetMealsInRange(ctx context.Context, arg GetMealsInRangeParams) ([]Meal, error) {
+	rows, err := q.db.Query(ctx, getMealsInRange, arg.Userid, arg.FromDate, arg.ToDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Meal
+	for rows.Next() {
+		var i Meal
+		if err := rows.Scan(
+			&i.Lastmodified,
+			&i.Deleted,
+			&i.ID,
+			&i.Userid,
+			&i.Foodid,
+			&i.Date,
+			&i.Mealtag,
+			&i.Servings,
+			&i.Unit,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getProgram = `-- name: GetProgram :one
+select id, userid, name, description, weeks from Programs where id = $1 and userID = $2
+`
+
+type GetProgramParams struct {
+	ID     int32
+	Userid int32
+}
+
+func (q *Queries) GetProgram(ctx context.Context, arg GetProgramParams) (Program, error) {
+	row := q.db.QueryRow(ctx, getProgram, arg.ID, arg.Userid)
+	var i Program
+	err := row.Scan(
+		&i.ID,
+		&i.Userid,
+		&i.Name,
+		&i.Description,
+		&i.Weeks,
+	)
+	return i, err
+}
+
+const getProgramDays = `-- name: GetProgramDays :many
+select programDays.id, programDays.week, programDays.day, programDays.templateID,
+  coalesce(workouts.name, '') as templateName
+from programDays
+left join workouts on workouts.id = programDays.templateID and not workouts.deleted
+where programDays.programID = $1 and programDays.userID = $2
+order by programDays.week, programDays.day, programDays.id
+`
+
+type GetProgramDaysParams struct {
+	Programid int32
+	Userid    int32
+}
+
+type GetProgramDaysRow struct {
+	ID           int32
+	Week         int32
+	Day          int32
+	Templateid   int32
+	Templatename string
+}
+
+func (q *Queries) GetProgramDays(ctx context.Context, arg GetProgramDaysParams) ([]GetProgramDaysRow, error) {
+	rows, err := q.db.Query(ctx, getProgramDays, arg.Programid, arg.Userid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetProgramDaysRow
+	for rows.Next() {
+		var i GetProgramDaysRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Week,
+			&i.Day,
+			&i.Templateid,
+			&i.Templatename,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getProgramLogs = `-- name: GetProgramLogs :many
+select id, userid, enrollmentid, dayid, workoutid, date from ProgramLogs where enrollmentID = $1 and userID = $2
+`
+
+type GetProgramLogsParams struct {
+	Enrollmentid int32
+	Userid       int32
+}
+
+func (q *Queries) GetProgramLogs(ctx context.Context, arg GetProgramLogsParams) ([]Programlog, error) {
+	rows, err := q.db.Query(ctx, getProgramLogs, arg.Enrollmentid, arg.Userid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Programlog
+	for rows.Next() {
+		var i Programlog
+		if err := rows.Scan(
+			&i.ID,
+			&i.Userid,
+			&i.Enrollmentid,
+			&i.Dayid,
+			&i.Workoutid,
+			&i.Date,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPrograms = `-- name: GetPrograms :many
+select id, userid, name, description, weeks from Programs where userID = $1 order by id
+`
+
+func (q *Queries) GetPrograms(ctx context.Context, userid int32) ([]Program, error) {
+	rows, err := q.db.Query(ctx, getPrograms, userid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Program
+	for rows.Next() {
+		var i Program
+		if err := rows.Scan(
+			&i.ID,
+			&i.Userid,
+			&i.Name,
+			&i.Description,
+			&i.Weeks,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPurged = `-- name: GetPurged :one
+select purgedSeq, purgedAt from users where id = $1
+`
+
+type GetPurgedRow struct {
+	Purgedseq int64
+	Purgedat  int64
+}
+
+func (q *Queries) GetPurged(ctx context.Context, id int32) (GetPurgedRow, error) {
+	row := q.db.QueryRow(ctx, getPurged, id)
+	var i GetPurgedRow
+	err := row.Scan(&i.Purgedseq, &i.Purgedat)
+	return i, err
+}
+
+const getRateLimitFullAt = `-- name: GetRateLimitFullAt :one
+select fullAt from rateLimits where bucket = $1
+`
+
+func (q *Queries) GetRateLimitFullAt(ctx context.Context, bucket string) (float64, error) {
+	row := q.db.QueryRow(ctx, getRateLimitFullAt, bucket)
+	var fullat float64
+	err := row.Scan(&fullat)
+	return fullat, err
+}
+
+const getRecentSets = `-- name: GetRecentSets :many
+with recent as (
+  select distinct workouts.id, workouts.date from workouts
+  join exercises on exercises.workoutID = workouts.id
+  where workouts.userID = $1 and not workouts.deleted and not workouts.isTemplate
+    and not exercises.deleted
+    and case when $2::int <> 0 then exercises.catalogID = $2
+        else lower(exercises.name) = lower($3) end
+  order by workouts.date desc, workouts.id desc
+  limit $4
+)
+select recent.id as workoutID, exercises.weightUnit, sets.weight, sets.reps from recent
+join exercises on exercises.workoutID = recent.id
+join sets on sets.exerciseID = exercises.id
+where not exercises.deleted and sets.setType <> 'warmup'
+  and case when $2::int <> 0 then exercises.catalogID = $2
+      else lower(exercises.name) = lower($3) end
+order by recent.date desc, recent.id desc, exercises.position, sets.position
+`
+
+type GetRecentSetsParams struct {
+	Userid    int32
+	Catalogid int32
+	Name      string
+	Limit     int32
+}
+
+type GetRecentSetsRow struct {
+	Workoutid  int32
+	Weightunit string
+	Weight     float64
+	Reps       int32
+}
+
+// (the sets of the exercise in the last workouts it was done in, newest first)
+func (q *Queries) GetRecentSets(ctx context.Context, arg GetRecentSetsParams) ([]GetRecentSetsRow, error) {
+	rows, err := q.db.Query(ctx, getRecentSets,
+		arg.Userid,
+		arg.Catalogid,
+		arg.Name,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecentSetsRow
+	for rows.Next() {
+		var i GetRecentSetsRow
+		if err := rows.Scan(
+			&i.Workoutid,
+			&i.Weightunit,
+			&i.Weight,
+			&i.Reps,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecord = `-- name: GetRecord :one
+select lastmodified, deleted, userid, recordtype, date, value, version, id from records where userID = $1 and recordType = $2 and date = $3
+`
+
+type GetRecordParams struct {
+	Userid     int32
+	Recordtype string
+	Date       int64
+}
+
+func (q *Queries) GetRecord(ctx context.Context, arg GetRecordParams) (Record, error) {
+	row := q.db.QueryRow(ctx, getRecord, arg.Userid, arg.Recordtype, arg.Date)
+	var i Record
+	err := row.Scan(
+		&i.Lastmodified,
+		&i.Deleted,
+		&i.Userid,
+		&i.Recordtype,
+		&i.Date,
+		&i.Value,
+		&i.Version,
+		&i.ID,
+	)
+	return i, err
+}
+
+const getRecordExercises = `-- name: GetRecordExercises :many
+select distinct on (exercises.userID, exercises.catalogID,
+    case when exercises.catalogID <> 0 then '' else lower(trim(exercises.name)) end)
+  exercises.userID, exercises.catalogID, trim(exercises.name)::text as name
+from exercises
+join workouts on workouts.id = exercises.workoutID
+where not exercises.deleted and not workouts.deleted and not workouts.isTemplate
+`
+
+type GetRecordExercisesRow struct {
+	Userid    int32
+	Catalogid int32
+	Name      string
+}
+
+// (the exercises in every user's workouts, one for each catalog
+// exercise or name of an exercise that isn't in the catalog)
+func (q *Queries) GetRecordExercises(ctx context.Context) ([]GetRecordExercisesRow, error) {
+	rows, err := q.db.Query(ctx, getRecordExercises)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecordExercisesRow
+	for rows.Next() {
+		var i GetRecordExercisesRow
+		if err := rows.Scan(&i.Userid, &i.Catalogid, &i.Name); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecordFeed = `-- name: GetRecordFeed :many
+select id, userid, workoutid, exerciseid, catalogid, name, kind, value, previous, weight, reps, date from PersonalRecords
+where userID = $1 and id < $2
+order by id desc limit $3
+`
+
+type GetRecordFeedParams struct {
+	Userid int32
+	ID     int32
+	Limit  int32
+}
+
+func (q *Queries) GetRecordFeed(ctx context.Context, arg GetRecordFeedParams) ([]Personalrecord, error) {
+	rows, err := q.db.Query(ctx, getRecordFeed, arg.Userid, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Personalrecord
+	for rows.Next() {
+		var i Personalrecord
+		if err := rows.Scan(
+			&i.ID,
+			&i.Userid,
+			&i.Workoutid,
+			&i.Exerciseid,
+			&i.Catalogid,
+			&i.Name,
+			&i.Kind,
+			&i.Value,
+			&i.Previous,
+			&i.Weight,
+			&i.Reps,
+			&i.Date,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecordsByID = `-- name: GetRecordsByID :many
+select lastmodified, deleted, userid, recordtype, date, value, version, id from records where userID = $1 and id = any($2::int[])
+`
+
+type GetRecordsByIDParams struct {
+	Userid int32
+	Ids    []int32
+}
+
+func (q *Queries) GetRecordsByID(ctx context.Context, arg GetRecordsByIDParams) ([]Record, error) {
+	rows, err := q.db.Query(ctx, getRecordsByID, arg.Userid, arg.Ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Record
+	for rows.Next() {
+		var i Record
+		if err := rows.Scan(
+			&i.Lastmodified,
+			&i.Deleted,
+			&i.Userid,
+			&i.Recordtype,
+			&i.Date,
+			&i.Value,
+			&i.Version,
+			&i.ID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSets = `-- name: GetSets :many
+select id, userid, workoutid, exerciseid, position, weight, reps, rpe, restseconds, settype from sets where userID = $1 and workoutID = $2
+order by exerciseID, position
+`
+
+type GetSetsParams struct {
+	Userid    int32
+	Workoutid int32
+}
+
+func (q *Queries) GetSets(ctx context.Context, arg GetSetsParams) ([]Set, error) {
+	rows, err := q.db.Query(ctx, getSets, arg.Userid, arg.Workoutid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Set
+	for rows.Next() {
+		var i Set
+		if err := rows.Scan(
+			&i.ID,
+			&i.Userid,
+			&i.Workoutid,
+			&i.Exerciseid,
+			&i.Position,
+			&i.Weight,
+			&i.Reps,
+			&i.Rpe,
+			&i.Restseconds,
+			&i.Settype,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSetsOfWorkouts = `-- name: GetSetsOfWorkouts :many
+select id, userid, workoutid, exerciseid, position, weight, reps, rpe, restseconds, settype from sets where userID = $1 and workoutID = any($2::int[])
+order by exerciseID, position
+`
+
+type GetSetsOfWorkoutsParams struct {
+	Userid int32
+	Ids    []int32
+}
+
+func (q *Queries) GetSetsOfWorkouts(ctx context.Context, arg GetSetsOfWorkoutsParams) ([]Set, error) {
+	rows, err := q.db.Query(ctx, getSetsOfWorkouts, arg.Userid, arg.Ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Set
+	for rows.Next() {
+		var i Set
+		if err := rows.Scan(
+			&i.ID,
+			&i.Userid,
+			&i.Workoutid,
+			&i.Exerciseid,
+			&i.Position,
+			&i.Weight,
+			&i.Reps,
+			&i.Rpe,
+			&i.Restseconds,
+			&i.Settype,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTrack = `-- name: GetTrack :one
+select points from Tracks where exerciseID = $1 and userID = $2
+`
+
+type GetTrackParams struct {
+	Exerciseid int32
+	Userid     int32
+}
+
+func (q *Queries) GetTrack(ctx context.Context, arg GetTrackParams) ([]byte, error) {
+	row := q.db.QueryRow(ctx, getTrack, arg.Exerciseid, arg.Userid)
+	var points []byte
+	err := row.Scan(&points)
+	return points, err
+}
+
+const getTrainingSets = `-- name: GetTrainingSets :many
+select workouts.id as workoutID, workouts.date, exercises.name, exercises.catalogID,
+  exercises.weightUnit, sets.weight, sets.reps,
+  coalesce(catalogExercises.primaryMuscles, '{}')::text[] as primaryMuscles,
+  coalesce(catalogExercises.secondaryMuscles, '{}')::text[] as secondaryMuscles
+from sets
+join exercises on exercises.id = sets.exerciseID
+join workouts on workouts.id = exercises.workoutID
+left join catalogExercises on catalogExercises.id = exercises.catalogID
+where sets.userID = $1 and sets.setType <> 'warmup' and exercises.exerciseType <> 'cardio'
+  and not exercises.deleted and not workouts.deleted and not workouts.isTemplate
+  and workouts.date >= $2 and workouts.date <= $3
+order by workouts.date, workouts.id, exercises.position, sets.position
+`
+
+type GetTrainingSetsParams struct {
+	Userid   int32
+	FromDate int64
+	ToDate   int64
+}
+
+type GetTrainingSetsRow struct {
+	Workoutid        int32
+	Date             int64
+	Name             string
+	Catalogid        int32
+	Weightunit       string
+	Weight           float64
+	Reps             int32
+	Primarymuscles   []string
+	Secondarymuscles []string
+}
+
+// (the sets of the strength exercises done in the date range,
+// with the muscles of their catalog exercises)
+func (q *Queries) GetTrainingSets(ctx context.Context, arg GetTrainingSetsParams) ([]GetTrainingSetsRow, error) {
+	rows, err := q.db.Query(ctx, getTrainingSets, arg.Userid, arg.FromDate, arg.ToDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTrainingSetsRow
+	for rows.Next() {
+		var i GetTrainingSetsRow
+		if err := rows.Scan(
+			&i.Workoutid,
+			&i.Date,
+			&i.Name,
+			&i.Catalogid,
+			&i.Weightunit,
+			&i.Weight,
+			&i.Reps,
+			&i.Primarymuscles,
+			&i.Secondarymuscles,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUpdatedMeals = `-- name: GetUpdatedMeals :many
+select lastmodified, deleted, id, userid, foodid, date, mealtag, servings, unit, version from meals where userID = $1 and lastModified >= $2
+  and deleted = coalesce($3, deleted)
+`
+
+type GetUpdatedMealsParams struct {
+	Userid        int32
+	Lastmodified  pgtype.Int8
+	IgnoreDeleted pgtype.Bool
+}
+
+func (q *Queries) GetUpdatedMeals(ctx context.Context, arg GetUpdatedMealsParams) ([]Meal, error) {
+	rows, err := q.db.Query(ctx, getUpdatedMeals, arg.Userid, arg.Lastmodified, arg.IgnoreDeleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Meal
+	for rows.Next() {
+		var i Meal
+		if err := rows.Scan(
+			&i.Lastmodified,
+			&i.Deleted,
 			&i.ID,
 			&i.Userid,
 			&i.Foodid,
@@ -325,6 +1873,7 @@ func (q *Queries) GetUpdatedMeals(ctx context.Context, arg GetUpdatedMealsParams
 			&i.Mealtag,
 			&i.Servings,
 			&i.Unit,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -337,7 +1886,7 @@ func (q *Queries) GetUpdatedMeals(ctx context.Context, arg GetUpdatedMealsParams
 }
 
 const getUpdatedRecords = `-- name: GetUpdatedRecords :many
-select lastmodified, deleted, userid, recordtype, date, value from records where userID = $1 and lastModified >= $2
+select lastmodified, deleted, userid, recordtype, date, value, version, id from records where userID = $1 and lastModified >= $2
   and deleted = coalesce($3, deleted)
 `
 
@@ -363,6 +1912,8 @@ func (q *Queries) GetUpdatedRecords(ctx context.Context, arg GetUpdatedRecordsPa
 			&i.Recordtype,
 			&i.Date,
 			&i.Value,
+			&i.Version,
+			&i.ID,
 		); err != nil {
 			return nil, err
 		}
@@ -375,7 +1926,7 @@ func (q *Queries) GetUpdatedRecords(ctx context.Context, arg GetUpdatedRecordsPa
 }
 
 const getUpdatedWorkouts = `-- name: GetUpdatedWorkouts :many
-select lastmodified, deleted, id, userid, name, date, istemplate, notes from workouts
+select lastmodified, deleted, id, userid, name, date, istemplate, notes, version, templateid, calories, groups, starttime from workouts
 where userID = $1 and lastModified >= $2
   and deleted = coalesce($3, deleted)
 `
@@ -404,6 +1955,11 @@ func (q *Queries) GetUpdatedWorkouts(ctx context.Context, arg GetUpdatedWorkouts
 			&i.Date,
 			&i.Istemplate,
 			&i.Notes,
+			&i.Version,
+			&i.Templateid,
+			&i.Calories,
+			&i.Groups,
+			&i.Starttime,
 		); err != nil {
 			return nil, err
 		}
@@ -430,31 +1986,345 @@ type GetUserRow struct {
 	Password string
 }
 
-func (q *Queries) GetUser(ctx context.Context, arg GetUserParams) (GetUserRow, error) {
-	row := q.db.QueryRow(ctx, getUser, arg.Email, arg.ID)
-	var i GetUserRow
-	err := row.Scan(&i.ID, &i.Email, &i.Password)
-	return i, err
+func (q *Queries) GetUser(ctx context.Context, arg GetUserParams) (GetUserRow, error) {
+	row := q.db.QueryRow(ctx, getUser, arg.Email, arg.ID)
+	var i GetUserRow
+	err := row.Scan(&i.ID, &i.Email, &i.Password)
+	return i, err
+}
+
+const getUserSettings = `-- name: GetUserSettings :one
+select lastmodified, id, userid, mealtags, macrotargets, useimperial, darkmode, trackperiod, version from settings where userID = $1
+`
+
+func (q *Queries) GetUserSettings(ctx context.Context, userid int32) (Setting, error) {
+	row := q.db.QueryRow(ctx, getUserSettings, userid)
+	var i Setting
+	err := row.Scan(
+		&i.Lastmodified,
+		&i.ID,
+		&i.Userid,
+		&i.Mealtags,
+		&i.Macrotargets,
+		&i.Useimperial,
+		&i.Darkmode,
+		&i.Trackperiod,
+		&i.Version,
+	)
+	return i, err
+}
+
+const getWeightsAround = `-- name: GetWeightsAround :one
+select
+  (select max(date) from records
+   where userID = $1 and recordType = 'weight' and not deleted and date < $2)::bigint as weightBefore,
+  (select min(date) from records
+   where userID = $1 and recordType = 'weight' and not deleted and date > $2)::bigint as weightAfter
+`
+
+type GetWeightsAroundParams struct {
+	Userid int32
+	Date   int64
+}
+
+type GetWeightsAroundRow struct {
+	Weightbefore pgtype.Int8
+	Weightafter  pgtype.Int8
+}
+
+// (the dates of the closest weights before and after
+// the date, null when there aren't any)
+func (q *Queries) GetWeightsAround(ctx context.Context, arg GetWeightsAroundParams) (GetWeightsAroundRow, error) {
+	row := q.db.QueryRow(ctx, getWeightsAround, arg.Userid, arg.Date)
+	var i GetWeightsAroundRow
+	err := row.Scan(&i.Weightbefore, &i.Weightafter)
+	return i, err
+}
+
+const getWorkout = `-- name: GetWorkout :one
+select lastmodified, deleted, id, userid, name, date, istemplate, notes, version, templateid, calories, groups, starttime from workouts where id = $1 and userID = $2 and deleted = false
+`
+
+type GetWorkoutParams struct {
+	ID     int32
+	Userid int32
+}
+
+func (q *Queries) GetWorkout(ctx context.Context, arg GetWorkoutParams) (Workout, error) {
+	row := q.db.QueryRow(ctx, getWorkout, arg.ID, arg.Userid)
+	var i Workout
+	err := row.Scan(
+		&i.Lastmodified,
+		&i.Deleted,
+		&i.ID,
+		&i.Userid,
+		&i.Name,
+		&i.Date,
+		&i.Istemplate,
+		&i.Notes,
+		&i.Version,
+		&i.Templateid,
+		&i.Calories,
+		&i.Groups,
+		&i.Starttime,
+	)
+	return i, err
+}
+
+const getWorkoutDates = `-- name: GetWorkoutDates :many
+select date from workouts
+where userID = $1 and not deleted and not isTemplate
+  and date >= $2 and date <= $3
+order by date
+`
+
+type GetWorkoutDatesParams struct {
+	Userid   int32
+	FromDate int64
+	ToDate   int64
+}
+
+func (q *Queries) GetWorkoutDates(ctx context.Context, arg GetWorkoutDatesParams) ([]int64, error) {
+	rows, err := q.db.Query(ctx, getWorkoutDates, arg.Userid, arg.FromDate, arg.ToDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var date int64
+		if err := rows.Scan(&date); err != nil {
+			return nil, err
+		}
+		items = append(items, date)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWorkoutPage = `-- name: GetWorkoutPage :many
+select lastmodified, deleted, id, userid, name, date, istemplate, notes, version, templateid, calories, groups, starttime from workouts
+where userID = $1 and not deleted and not isTemplate
+  and date >= $3 and date <= $4
+  and (date, id) > ($5::bigint, $6::int)
+order by date, id limit $2
+`
+
+type GetWorkoutPageParams struct {
+	Userid    int32
+	Limit     int32
+	FromDate  int64
+	ToDate    int64
+	AfterDate int64
+	AfterID   int32
+}
+
+// (the workouts in the date range after the date and id, in order)
+func (q *Queries) GetWorkoutPage(ctx context.Context, arg GetWorkoutPageParams) ([]Workout, error) {
+	rows, err := q.db.Query(ctx, getWorkoutPage,
+		arg.Userid,
+		arg.Limit,
+		arg.FromDate,
+		arg.ToDate,
+		arg.AfterDate,
+		arg.AfterID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Workout
+	for rows.Next() {
+		var i Workout
+		if err := rows.Scan(
+			&i.Lastmodified,
+			&i.Deleted,
+			&i.ID,
+			&i.Userid,
+			&i.Name,
+			&i.Date,
+			&i.Istemplate,
+			&i.Notes,
+			&i.Version,
+			&i.Templateid,
+			&i.Calories,
+			&i.Groups,
+			&i.Starttime,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWorkoutsBetween = `-- name: GetWorkoutsBetween :many
+select lastmodified, deleted, id, userid, name, date, istemplate, notes, version, templateid, calories, groups, starttime from workouts
+where userID = $1 and not deleted and not isTemplate
+  and date >= $2 and date < $3
+`
+
+type GetWorkoutsBetweenParams struct {
+	Userid   int32
+	FromDate int64
+	ToDate   int64
+}
+
+// (the workouts from the first date up to, but not including, the second)
+func (q *Queries) GetWorkoutsBetween(ctx context.Context, arg GetWorkoutsBetweenParams) ([]Workout, error) {
+	rows, err := q.db.Query(ctx, getWorkoutsBetween, arg.Userid, arg.FromDate, arg.ToDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Workout
+	for rows.Next() {
+		var i Workout
+		if err := rows.Scan(
+			&i.Lastmodified,
+			&i.Deleted,
+			&i.ID,
+			&i.Userid,
+			&i.Name,
+			&i.Date,
+			&i.Istemplate,
+			&i.Notes,
+			&i.Version,
+			&i.Templateid,
+			&i.Calories,
+			&i.Groups,
+			&i.Starttime,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWorkoutsByID = `-- name: GetWorkoutsByID :many
+select lastmodified, deleted, id, userid, name, date, istemplate, notes, version, templateid, calories, groups, starttime from workouts where userID = $1 and id = any($2::int[])
+`
+
+type GetWorkoutsByIDParams struct {
+	Userid int32
+	Ids    []int32
+}
+
+func (q *Queries) GetWorkoutsByID(ctx context.Context, arg GetWorkoutsByIDParams) ([]Workout, error) {
+	rows, err := q.db.Query(ctx, getWorkoutsByID, arg.Userid, arg.Ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Workout
+	for rows.Next() {
+		var i Workout
+		if err := rows.Scan(
+			&i.Lastmodified,
+			&i.Deleted,
+			&i.ID,
+			&i.Userid,
+			&i.Name,
+			&i.Date,
+			&i.Istemplate,
+			&i.Notes,
+			&i.Version,
+			&i.Templateid,
+			&i.Calories,
+			&i.Groups,
+			&i.Starttime,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWorkoutsWithoutCalories = `-- name: GetWorkoutsWithoutCalories :many
+select lastmodified, deleted, id, userid, name, date, istemplate, notes, version, templateid, calories, groups, starttime from workouts where not deleted and not isTemplate and calories = 0
+`
+
+func (q *Queries) GetWorkoutsWithoutCalories(ctx context.Context) ([]Workout, error) {
+	rows, err := q.db.Query(ctx, getWorkoutsWithoutCalories)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Workout
+	for rows.Next() {
+		var i Workout
+		if err := rows.Scan(
+			&i.Lastmodified,
+			&i.Deleted,
+			&i.ID,
+			&i.Userid,
+			&i.Name,
+			&i.Date,
+			&i.Istemplate,
+			&i.Notes,
+			&i.Version,
+			&i.Templateid,
+			&i.Calories,
+			&i.Groups,
+			&i.Starttime,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const hardDeleteCatalogExercises = `-- name: HardDeleteCatalogExercises :exec
+delete from CatalogExercises where userID = $1
+`
+
+func (q *Queries) HardDeleteCatalogExercises(ctx context.Context, userid int32) error {
+	_, err := q.db.Exec(ctx, hardDeleteCatalogExercises, userid)
+	return err
+}
+
+const hardDeleteChanges = `-- name: HardDeleteChanges :exec
+delete from changes where userID = $1
+`
+
+func (q *Queries) HardDeleteChanges(ctx context.Context, userid int32) error {
+	_, err := q.db.Exec(ctx, hardDeleteChanges, userid)
+	return err
+}
+
+const hardDeleteDevices = `-- name: HardDeleteDevices :exec
+delete from devices where userID = $1
+`
+
+func (q *Queries) HardDeleteDevices(ctx context.Context, userid int32) error {
+	_, err := q.db.Exec(ctx, hardDeleteDevices, userid)
+	return err
 }
 
-const getUserSettings = `-- name: GetUserSettings :one
-select lastmodified, id, userid, mealtags, macrotargets, useimperial, darkmode, trackperiod from settings where userID = $1
+const hardDeleteEnrollments = `-- name: HardDeleteEnrollments :exec
+delete from Enrollments where userID = $1
 `
 
-func (q *Queries) GetUserSettings(ctx context.Context, userid int32) (Setting, error) {
-	row := q.db.QueryRow(ctx, getUserSettings, userid)
-	var i Setting
-	err := row.Scan(
-		&i.Lastmodified,
-		&i.ID,
-		&i.Userid,
-		&i.Mealtags,
-		&i.Macrotargets,
-		&i.Useimperial,
-		&i.Darkmode,
-		&i.Trackperiod,
-	)
-	return i, err
+func (q *Queries) HardDeleteEnrollments(ctx context.Context, userid int32) error {
+	_, err := q.db.Exec(ctx, hardDeleteEnrollments, userid)
+	return err
 }
 
 const hardDeleteExercises = `-- name: HardDeleteExercises :exec
@@ -475,6 +2345,15 @@ func (q *Queries) HardDeleteFoods(ctx context.Context, userid int32) error {
 	return err
 }
 
+const hardDeleteIdempotencyKeys = `-- name: HardDeleteIdempotencyKeys :exec
+delete from IdempotencyKeys where userID = $1
+`
+
+func (q *Queries) HardDeleteIdempotencyKeys(ctx context.Context, userid int32) error {
+	_, err := q.db.Exec(ctx, hardDeleteIdempotencyKeys, userid)
+	return err
+}
+
 const hardDeleteMeals = `-- name: HardDeleteMeals :exec
 delete from meals where userID = $1
 `
@@ -484,6 +2363,42 @@ func (q *Queries) HardDeleteMeals(ctx context.Context, userid int32) error {
 	return err
 }
 
+const hardDeletePersonalRecords = `-- name: HardDeletePersonalRecords :exec
+delete from PersonalRecords where userID = $1
+`
+
+func (q *Queries) HardDeletePersonalRecords(ctx context.Context, userid int32) error {
+	_, err := q.db.Exec(ctx, hardDeletePersonalRecords, userid)
+	return err
+}
+
+const hardDeleteProgramDays = `-- name: HardDeleteProgramDays :exec
+delete from ProgramDays where userID = $1
+`
+
+func (q *Queries) HardDeleteProgramDays(ctx context.Context, userid int32) error {
+	_, err := q.db.Exec(ctx, hardDeleteProgramDays, userid)
+	return err
+}
+
+const hardDeleteProgramLogs = `-- name: HardDeleteProgramLogs :exec
+delete from ProgramLogs where userID = $1
+`
+
+func (q *Queries) HardDeleteProgramLogs(ctx context.Context, userid int32) error {
+	_, err := q.db.Exec(ctx, hardDeleteProgramLogs, userid)
+	return err
+}
+
+const hardDeletePrograms = `-- name: HardDeletePrograms :exec
+delete from Programs where userID = $1
+`
+
+func (q *Queries) HardDeletePrograms(ctx context.Context, userid int32) error {
+	_, err := q.db.Exec(ctx, hardDeletePrograms, userid)
+	return err
+}
+
 const hardDeleteRecords = `-- name: HardDeleteRecords :exec
 delete from records where userID = $1
 `
@@ -493,6 +2408,15 @@ func (q *Queries) HardDeleteRecords(ctx context.Context, userid int32) error {
 	return err
 }
 
+const hardDeleteSets = `-- name: HardDeleteSets :exec
+delete from sets where userID = $1
+`
+
+func (q *Queries) HardDeleteSets(ctx context.Context, userid int32) error {
+	_, err := q.db.Exec(ctx, hardDeleteSets, userid)
+	return err
+}
+
 const hardDeleteSettings = `-- name: HardDeleteSettings :exec
 delete from settings where userID = $1
 `
@@ -502,6 +2426,15 @@ func (q *Queries) HardDeleteSettings(ctx context.Context, userid int32) error {
 	return err
 }
 
+const hardDeleteTracks = `-- name: HardDeleteTracks :exec
+delete from Tracks where userID = $1
+`
+
+func (q *Queries) HardDeleteTracks(ctx context.Context, userid int32) error {
+	_, err := q.db.Exec(ctx, hardDeleteTracks, userid)
+	return err
+}
+
 const hardDeleteUser = `-- name: HardDeleteUser :exec
 delete from users where id = $1
 `
@@ -520,6 +2453,287 @@ func (q *Queries) HardDeleteWorkouts(ctx context.Context, userid int32) error {
 	return err
 }
 
+const linkExercisesToCatalog = `-- name: LinkExercisesToCatalog :many
+update exercises set catalogID = linked.catalogID
+from (
+    select distinct on (exercises.id) exercises.id, catalog.id as catalogID
+    from exercises
+    join CatalogExercises catalog on catalog.userID in (0, exercises.userID)
+      and (lower(catalog.name) = lower(exercises.name)
+        or exists (select 1 from unnest(catalog.aliases) alias where lower(alias) = lower(exercises.name)))
+    where exercises.catalogID = 0
+    order by exercises.id, lower(catalog.name) = lower(exercises.name) desc, catalog.userID desc, catalog.id
+) linked
+where exercises.id = linked.id
+returning exercises.userID, exercises.catalogID, exercises.name
+`
+
+type LinkExercisesToCatalogRow struct {
+	Userid    int32
+	Catalogid int32
+	Name      string
+}
+
+// (links the exercises that aren't in the catalog to the catalog
+// exercise with their name or alias, chosen like FindCatalogExercise,
+// returning the exercises that were linked)
+func (q *Queries) LinkExercisesToCatalog(ctx context.Context) ([]LinkExercisesToCatalogRow, error) {
+	rows, err := q.db.Query(ctx, linkExercisesToCatalog)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LinkExercisesToCatalogRow
+	for rows.Next() {
+		var i LinkExercisesToCatalogRow
+		if err := rows.Scan(&i.Userid, &i.Catalogid, &i.Name); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const purgeDeletedExercises = `-- name: PurgeDeletedExercises :one
+with purged as (
+    delete from exercises using changes
+    where exercises.deleted and exercises.lastModified < $1
+      and changes.userID = exercises.userID and changes.entity = 'workout'
+      and changes.entityID = exercises.workoutID
+      and changes.seq <= coalesce(
+        (select min(seq) from devices where devices.userID = exercises.userID), changes.seq)
+    returning exercises.userID, exercises.id, changes.seq
+), orphans as (
+    delete from sets using purged where sets.exerciseID = purged.id
+), orphanedTracks as (
+    delete from tracks using purged where tracks.exerciseID = purged.id
+), latest as (
+    update users set purgedSeq = greatest(purgedSeq, last.seq), purgedAt = $2
+    from (select userID, max(seq) as seq from purged group by userID) last
+    where users.id = last.userID
+)
+select count(*) from purged
+`
+
+type PurgeDeletedExercisesParams struct {
+	Before pgtype.Int8
+	Now    int64
+}
+
+// (hard deletes the soft deleted exercises whose workout's last change
+// has been pulled by every device, returning how many were deleted)
+func (q *Queries) PurgeDeletedExercises(ctx context.Context, arg PurgeDeletedExercisesParams) (int64, error) {
+	row := q.db.QueryRow(ctx, purgeDeletedExercises, arg.Before, arg.Now)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const purgeDeletedMeals = `-- name: PurgeDeletedMeals :one
+with purged as (
+    delete from meals using changes
+    where meals.deleted and meals.lastModified < $1
+      and changes.userID = meals.userID and changes.entity = 'meal'
+      and changes.entityID = meals.id
+      and changes.seq <= coalesce(
+        (select min(seq) from devices where devices.userID = meals.userID), changes.seq)
+    returning meals.userID, meals.id, changes.seq
+), forgotten as (
+    delete from changes using purged
+    where changes.userID = purged.userID and changes.entity = 'meal'
+      and changes.entityID = purged.id
+), latest as (
+    update users set purgedSeq = greatest(purgedSeq, last.seq), purgedAt = $2
+    from (select userID, max(seq) as seq from purged group by userID) last
+    where users.id = last.userID
+)
+select count(*) from purged
+`
+
+type PurgeDeletedMealsParams struct {
+	Before pgtype.Int8
+	Now    int64
+}
+
+func (q *Queries) PurgeDeletedMeals(ctx context.Context, arg PurgeDeletedMealsParams) (int64, error) {
+	row := q.db.QueryRow(ctx, purgeDeletedMeals, arg.Before, arg.Now)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const purgeDeletedRecords = `-- name: PurgeDeletedRecords :one
+with purged as (
+    delete from records using changes
+    where records.deleted and records.lastModified < $1
+      and changes.userID = records.userID and changes.entity = 'record'
+      and changes.entityID = records.id
+      and changes.seq <= coalesce(
+        (select min(seq) from devices where devices.userID = records.userID), changes.seq)
+    returning records.userID, records.id, changes.seq
+), forgotten as (
+    delete from changes using purged
+    where changes.userID = purged.userID and changes.entity = 'record'
+      and changes.entityID = purged.id
+), latest as (
+    update users set purgedSeq = greatest(purgedSeq, last.seq), purgedAt = $2
+    from (select userID, max(seq) as seq from purged group by userID) last
+    where users.id = last.userID
+)
+select count(*) from purged
+`
+
+type PurgeDeletedRecordsParams struct {
+	Before pgtype.Int8
+	Now    int64
+}
+
+func (q *Queries) PurgeDeletedRecords(ctx context.Context, arg PurgeDeletedRecordsParams) (int64, error) {
+	row := q.db.QueryRow(ctx, purgeDeletedRecords, arg.Before, arg.Now)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const purgeDeletedWorkouts = `-- name: PurgeDeletedWorkouts :one
+with purged as (
+    delete from workouts using changes
+    where workouts.deleted and workouts.lastModified < $1
+      and changes.userID = workouts.userID and changes.entity = 'workout'
+      and changes.entityID = workouts.id
+      and changes.seq <= coalesce(
+        (select min(seq) from devices where devices.userID = workouts.userID), changes.seq)
+    returning workouts.userID, workouts.id, changes.seq
+), orphans as (
+    delete from exercises using purged where exercises.workoutID = purged.id
+), orphanedSets as (
+    delete from sets using purged where sets.workoutID = purged.id
+), orphanedTracks as (
+    delete from tracks using purged where tracks.workoutID = purged.id
+), forgotten as (
+    delete from changes using purged
+    where changes.userID = purged.userID and changes.entity = 'workout'
+      and changes.entityID = purged.id
+), latest as (
+    update users set purgedSeq = greatest(purgedSeq, last.seq), purgedAt = $2
+    from (select userID, max(seq) as seq from purged group by userID) last
+    where users.id = last.userID
+)
+select count(*) from purged
+`
+
+type PurgeDeletedWorkoutsParams struct {
+	Before pgtype.Int8
+	Now    int64
+}
+
+func (q *Queries) PurgeDeletedWorkouts(ctx context.Context, arg PurgeDeletedWorkoutsParams) (int64, error) {
+	row := q.db.QueryRow(ctx, purgeDeletedWorkouts, arg.Before, arg.Now)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const saveIdempotentResponse = `-- name: SaveIdempotentResponse :exec
+update IdempotencyKeys set status = $1, response = $2
+where userID = $3 and key = $4
+`
+
+type SaveIdempotentResponseParams struct {
+	Status   int32
+	Response string
+	Userid   int32
+	Key      string
+}
+
+func (q *Queries) SaveIdempotentResponse(ctx context.Context, arg SaveIdempotentResponseParams) error {
+	_, err := q.db.Exec(ctx, saveIdempotentResponse,
+		arg.Status,
+		arg.Response,
+		arg.Userid,
+		arg.Key,
+	)
+	return err
+}
+
+const saveTrack = `-- name: SaveTrack :exec
+insert into Tracks (userID, workoutID, exerciseID, points) values ($1, $2, $3, $4)
+on conflict (exerciseID) do update set points = excluded.points
+`
+
+type SaveTrackParams struct {
+	Userid     int32
+	Workoutid  int32
+	Exerciseid int32
+	Points     []byte
+}
+
+func (q *Queries) SaveTrack(ctx context.Context, arg SaveTrackParams) error {
+	_, err := q.db.Exec(ctx, saveTrack,
+		arg.Userid,
+		arg.Workoutid,
+		arg.Exerciseid,
+		arg.Points,
+	)
+	return err
+}
+
+const searchCatalog = `-- name: SearchCatalog :many
+select id, userid, name, aliases, primarymuscles, secondarymuscles, equipment, exercisetype, met from CatalogExercises
+where userID in (0, $1)
+  and (position(lower($2) in lower(name)) > 0
+    or exists (select 1 from unnest(aliases) alias where position(lower($2) in lower(alias)) > 0))
+  and ($3::text is null or $3 = any(primaryMuscles) or $3 = any(secondaryMuscles))
+  and ($4::text is null or equipment = $4)
+order by name limit 100
+`
+
+type SearchCatalogParams struct {
+	Userid    int32
+	Query     string
+	Muscle    pgtype.Text
+	Equipment pgtype.Text
+}
+
+// (the muscle and equipment filters are optional)
+func (q *Queries) SearchCatalog(ctx context.Context, arg SearchCatalogParams) ([]Catalogexercise, error) {
+	rows, err := q.db.Query(ctx, searchCatalog,
+		arg.Userid,
+		arg.Query,
+		arg.Muscle,
+		arg.Equipment,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Catalogexercise
+	for rows.Next() {
+		var i Catalogexercise
+		if err := rows.Scan(
+			&i.ID,
+			&i.Userid,
+			&i.Name,
+			&i.Aliases,
+			&i.Primarymuscles,
+			&i.Secondarymuscles,
+			&i.Equipment,
+			&i.Exercisetype,
+			&i.Met,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const searchFoods = `-- name: SearchFoods :many
 select lastmodified, id, userid, name, defaultservingindex, servingsizes, servingunits, calories, carbohydrate, protein, fat, calcium, potassium, iron from foods
 where to_tsvector(name) @@ to_tsquery($1) limit 100
@@ -605,16 +2819,75 @@ func (q *Queries) SearchUserFoods(ctx context.Context, arg SearchUserFoodsParams
 	return items, nil
 }
 
-const setUserSettings = `-- name: SetUserSettings :exec
+const setDeviceSeq = `-- name: SetDeviceSeq :exec
+insert into devices (userID, deviceID, seq, lastSeen) values ($1, $2, $3, $4)
+on conflict (userID, deviceID) do update
+set seq = excluded.seq, lastSeen = excluded.lastSeen
+`
+
+type SetDeviceSeqParams struct {
+	Userid   int32
+	Deviceid string
+	Seq      int64
+	Lastseen int64
+}
+
+func (q *Queries) SetDeviceSeq(ctx context.Context, arg SetDeviceSeqParams) error {
+	_, err := q.db.Exec(ctx, setDeviceSeq,
+		arg.Userid,
+		arg.Deviceid,
+		arg.Seq,
+		arg.Lastseen,
+	)
+	return err
+}
+
+const setPeriod = `-- name: SetPeriod :one
+insert into records (userID, recordType, date, value, lastModified)
+values ($1, 'period', $2, $3, $4)
+on conflict (userID, recordType, date) do update
+set value = excluded.value, deleted = false, lastModified = $4,
+    version = records.version + 1
+where records.version = coalesce($5, records.version)
+returning version
+`
+
+type SetPeriodParams struct {
+	Userid       int32
+	Date         int64
+	Value        float64
+	Lastmodified pgtype.Int8
+	BaseVersion  pgtype.Int8
+}
+
+// (returns no rows when the record isn't at the base version)
+func (q *Queries) SetPeriod(ctx context.Context, arg SetPeriodParams) (int64, error) {
+	row := q.db.QueryRow(ctx, setPeriod,
+		arg.Userid,
+		arg.Date,
+		arg.Value,
+		arg.Lastmodified,
+		arg.BaseVersion,
+	)
+	var version int64
+	err := row.Scan(&version)
+	return version, err
+}
+
+const setUserSettings = `-- name: SetUserSettings :one
 insert into settings
-(userID, mealTags, macroTargets, useImperial, trackPeriod, darkMode)
-values ($1, $2, $3, $4, $5, $6)
+(userID, mealTags, macroTargets, useImperial, trackPeriod, darkMode, lastModified)
+values ($1, $2, $3, $4, $5, $6, $7)
 on conflict (userID) do update
 set mealTags = excluded.mealTags,
     macroTargets = excluded.macroTargets,
     useImperial = excluded.useImperial,
     darkMode = excluded.darkMode,
-    trackPeriod = excluded.trackPeriod
+    trackPeriod = excluded.trackPeriod,
+    lastModified = excluded.lastModified,
+    version = settings.version + 1
+where settings.version = coalesce($8, settings.version)
+returning version
 `
 
 type SetUserSettingsParams struct {
@@ -624,24 +2897,34 @@ type SetUserSettingsParams struct {
 	Useimperial  bool
 	Trackperiod  bool
 	Darkmode     bool
+	Lastmodified pgtype.Int8
+	BaseVersion  pgtype.Int8
 }
 
-func (q *Queries) SetUserSettings(ctx context.Context, arg SetUserSettingsParams) error {
-	_, err := q.db.Exec(ctx, setUserSettings,
+// (returns no rows when the settings aren't at the base version)
+func (q *Queries) SetUserSettings(ctx context.Context, arg SetUserSettingsParams) (int64, error) {
+	row := q.db.QueryRow(ctx, setUserSettings,
 		arg.Userid,
 		arg.Mealtags,
 		arg.Macrotargets,
 		arg.Useimperial,
 		arg.Trackperiod,
 		arg.Darkmode,
+		arg.Lastmodified,
+		arg.BaseVersion,
 	)
-	return err
+	var version int64
+	err := row.Scan(&version)
+	return version, err
 }
 
-const setWeight = `-- name: SetWeight :exec
-insert into records (userID, recordType, date, value)
-values ($1, 'weight', $2, $3) on conflict(userID, recordType, date) do update
-set value = excluded.value, lastModified = $4
+const setWeight = `-- name: SetWeight :one
+insert into records (userID, recordType, date, value, lastModified)
+values ($1, 'weight', $2, $3, $4) on conflict(userID, recordType, date) do update
+set value = excluded.value, deleted = false, lastModified = $4,
+    version = records.version + 1
+where records.version = coalesce($5, records.version)
+returning version
 `
 
 type SetWeightParams struct {
@@ -649,22 +2932,108 @@ type SetWeightParams struct {
 	Date         int64
 	Value        float64
 	Lastmodified pgtype.Int8
+	BaseVersion  pgtype.Int8
 }
 
-func (q *Queries) SetWeight(ctx context.Context, arg SetWeightParams) error {
-	_, err := q.db.Exec(ctx, setWeight,
+// (returns no rows when the record isn't at the base version)
+func (q *Queries) SetWeight(ctx context.Context, arg SetWeightParams) (int64, error) {
+	row := q.db.QueryRow(ctx, setWeight,
 		arg.Userid,
 		arg.Date,
 		arg.Value,
 		arg.Lastmodified,
+		arg.BaseVersion,
+	)
+	var version int64
+	err := row.Scan(&version)
+	return version, err
+}
+
+const setWorkoutCalories = `-- name: SetWorkoutCalories :exec
+update workouts set calories = $1, lastModified = $2, version = version + 1
+where id = $3 and userID = $4
+`
+
+type SetWorkoutCaloriesParams struct {
+	Calories     float64
+	Lastmodified pgtype.Int8
+	ID           int32
+	Userid       int32
+}
+
+func (q *Queries) SetWorkoutCalories(ctx context.Context, arg SetWorkoutCaloriesParams) error {
+	_, err := q.db.Exec(ctx, setWorkoutCalories,
+		arg.Calories,
+		arg.Lastmodified,
+		arg.ID,
+		arg.Userid,
 	)
 	return err
 }
 
+const setWorkoutStartTime = `-- name: SetWorkoutStartTime :exec
+update workouts set startTime = $1 where id = $2 and userID = $3
+`
+
+type SetWorkoutStartTimeParams struct {
+	Starttime int64
+	ID        int32
+	Userid    int32
+}
+
+func (q *Queries) SetWorkoutStartTime(ctx context.Context, arg SetWorkoutStartTimeParams) error {
+	_, err := q.db.Exec(ctx, setWorkoutStartTime, arg.Starttime, arg.ID, arg.Userid)
+	return err
+}
+
+const startBackfill = `-- name: StartBackfill :execrows
+insert into Backfills (name) values ($1) on conflict do nothing
+`
+
+// (marks the backfill as run, affecting no rows when it already was)
+func (q *Queries) StartBackfill(ctx context.Context, name string) (int64, error) {
+	result, err := q.db.Exec(ctx, startBackfill, name)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const takeRateLimitToken = `-- name: TakeRateLimitToken :one
+insert into rateLimits (bucket, fullAt)
+values ($1, $2::float + $3::float)
+on conflict (bucket) do update
+set fullAt = greatest(rateLimits.fullAt, $2::float) + $3::float
+where greatest(rateLimits.fullAt, $2::float) + $3::float
+    - $2::float <= $4::float
+returning fullAt
+`
+
+type TakeRateLimitTokenParams struct {
+	Bucket   string
+	Now      float64
+	Interval float64
+	Capacity float64
+}
+
+// (returns no rows when the bucket is empty)
+func (q *Queries) TakeRateLimitToken(ctx context.Context, arg TakeRateLimitTokenParams) (float64, error) {
+	row := q.db.QueryRow(ctx, takeRateLimitToken,
+		arg.Bucket,
+		arg.Now,
+		arg.Interval,
+		arg.Capacity,
+	)
+	var fullat float64
+	err := row.Scan(&fullat)
+	return fullat, err
+}
+
 const togglePeriodDate = `-- name: TogglePeriodDate :exec
-insert into records (userID, recordType, date, value) values ($1, 'period', $2, $3)
+insert into records (userID, recordType, date, value, lastModified)
+values ($1, 'period', $2, $3, $4)
 on conflict (userID, recordType, date) do update
-set value = 1 - excluded.value, lastModified = $4
+set value = 1 - excluded.value, lastModified = $4, version = records.version + 1
 `
 
 type TogglePeriodDateParams struct {
@@ -685,10 +3054,68 @@ func (q *Queries) TogglePeriodDate(ctx context.Context, arg TogglePeriodDatePara
 	return err
 }
 
-const updateMeal = `-- name: UpdateMeal :exec
+const updateExercise = `-- name: UpdateExercise :execrows
+update exercises
+set lastModified = $1, position = $2, catalogID = $3, exerciseType = $4, name = $5,
+    weight = $6, weightUnit = $7, reps = $8, duration = $9, progression = $10,
+    distance = $11, elevationGain = $12, avgHeartRate = $13, maxHeartRate = $14,
+    groupNumber = $15
+where id = $16 and workoutID = $17 and userID = $18 and deleted = false
+`
+
+type UpdateExerciseParams struct {
+	Lastmodified  pgtype.Int8
+	Position      int32
+	Catalogid     int32
+	Exercisetype  string
+	Name          string
+	Weight        int32
+	Weightunit    string
+	Reps          []int32
+	Duration      float64
+	Progression   []byte
+	Distance      float64
+	Elevationgain float64
+	Avgheartrate  int32
+	Maxheartrate  int32
+	Groupnumber   int32
+	ID            int32
+	Workoutid     int32
+	Userid        int32
+}
+
+func (q *Queries) UpdateExercise(ctx context.Context, arg UpdateExerciseParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateExercise,
+		arg.Lastmodified,
+		arg.Position,
+		arg.Catalogid,
+		arg.Exercisetype,
+		arg.Name,
+		arg.Weight,
+		arg.Weightunit,
+		arg.Reps,
+		arg.Duration,
+		arg.Progression,
+		arg.Distance,
+		arg.Elevationgain,
+		arg.Avgheartrate,
+		arg.Maxheartrate,
+		arg.Groupnumber,
+		arg.ID,
+		arg.Workoutid,
+		arg.Userid,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateMeal = `-- name: UpdateMeal :one
 update meals
-set lastModified = $1, mealTag = $2, servings = $3, unit = $4
-where ID = $5
+set lastModified = $1, mealTag = $2, servings = $3, unit = $4, version = version + 1
+where ID = $5 and userID = $6 and deleted = false and version = $7
+returning version
 `
 
 type UpdateMealParams struct {
@@ -697,17 +3124,64 @@ type UpdateMealParams struct {
 	Servings     float64
 	Unit         string
 	ID           int32
+	Userid       int32
+	BaseVersion  int64
 }
 
-func (q *Queries) UpdateMeal(ctx context.Context, arg UpdateMealParams) error {
-	_, err := q.db.Exec(ctx, updateMeal,
+// (returns no rows when the meal isn't at the base version)
+func (q *Queries) UpdateMeal(ctx context.Context, arg UpdateMealParams) (int64, error) {
+	row := q.db.QueryRow(ctx, updateMeal,
 		arg.Lastmodified,
 		arg.Mealtag,
 		arg.Servings,
 		arg.Unit,
 		arg.ID,
+		arg.Userid,
+		arg.BaseVersion,
 	)
-	return err
+	var version int64
+	err := row.Scan(&version)
+	return version, err
+}
+
+const updateWorkout = `-- name: UpdateWorkout :one
+update workouts
+set lastModified = $1, name = $2, notes = $3, date = $4, isTemplate = $5, calories = $6,
+    groups = $7, version = version + 1
+where id = $8 and userID = $9 and deleted = false and version = $10
+returning version
+`
+
+type UpdateWorkoutParams struct {
+	Lastmodified pgtype.Int8
+	Name         string
+	Notes        string
+	Date         int64
+	Istemplate   bool
+	Calories     float64
+	Groups       []byte
+	ID           int32
+	Userid       int32
+	BaseVersion  int64
+}
+
+// (returns no rows when the workout isn't at the base version)
+func (q *Queries) UpdateWorkout(ctx context.Context, arg UpdateWorkoutParams) (int64, error) {
+	row := q.db.QueryRow(ctx, updateWorkout,
+		arg.Lastmodified,
+		arg.Name,
+		arg.Notes,
+		arg.Date,
+		arg.Istemplate,
+		arg.Calories,
+		arg.Groups,
+		arg.ID,
+		arg.Userid,
+		arg.BaseVersion,
+	)
+	var version int64
+	err := row.Scan(&version)
+	return version, err
 }
 
 const userExists = `-- name: UserExists :one
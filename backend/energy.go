@@ -0,0 +1,244 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// The energy burned in a workout is estimated from the metabolic equivalent
+// (MET) of its exercises, how long they took and the user's weight, as
+// MET * kg * hours. It's estimated whenever the workout is saved, with the
+// weight closest to the workout's date, and again whenever a weight that
+// could be the closest is set or deleted, or the units the weights are
+// recorded in change.
+
+// The METs of exercises whose catalog exercise doesn't have one
+var defaultMETs = map[string]float64{"strength": 5, "cardio": 7}
+
+// Strength exercises don't have a duration, so each set is
+// assumed to take this long, plus its rest
+const (
+	setSeconds         = 40
+	defaultRestSeconds = 90
+)
+
+// Get the user's weight closest to the date in kg,
+// 0 when they've never recorded their weight
+func bodyWeight(ctx context.Context, q *database.Queries, userID int32, date int64) (float64, error) {
+	weight, err := q.GetBodyWeight(ctx, database.GetBodyWeightParams{Userid: userID, Date: date})
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// weights are recorded in the units the user has chosen
+	settings, err := q.GetUserSettings(ctx, userID)
+	if err != nil && err != pgx.ErrNoRows {
+		return 0, err
+	}
+	if settings.Useimperial {
+		return weight * kgPerLb, nil
+	}
+	return weight, nil
+}
+
+// Get how many minutes the exercise took
+func exerciseMinutes(e ExerciseJSON) float64 {
+	if e.Duration > 0 || e.ExerciseType == "cardio" {
+		return e.Duration
+	}
+	seconds := 0
+	for _, set := range e.Sets {
+		rest := int(set.RestSeconds)
+		if rest == 0 {
+			rest = defaultRestSeconds
+		}
+		seconds += setSeconds + rest
+	}
+	return float64(seconds) / 60
+}
+
+// Estimate the kcal burned in the workout. The exercises
+// should already be linked to the catalog.
+func estimateCalories(
+	ctx context.Context, q *database.Queries, userID int32, workout WorkoutJSON,
+) (float64, error) {
+	if workout.IsTemplate || len(workout.Exercises) == 0 {
+		return 0, nil
+	}
+	kg, err := bodyWeight(ctx, q, userID, workout.Date)
+	if err != nil || kg == 0 {
+		return 0, err
+	}
+
+	calories := 0.0
+	mets := map[int32]float64{} // by catalog id
+	for _, e := range workout.Exercises {
+		met, ok := mets[e.CatalogID]
+		if !ok && e.CatalogID != 0 {
+			row, err := q.GetCatalogExercise(ctx, database.GetCatalogExerciseParams{
+				ID: e.CatalogID, Userid: userID,
+			})
+			if err != nil && err != pgx.ErrNoRows {
+				return 0, err
+			}
+			met = row.Met
+			mets[e.CatalogID] = met
+		}
+		if met == 0 {
+			met = defaultMETs[e.ExerciseType]
+		}
+		calories += met * kg * exerciseMinutes(e) / 60
+	}
+	return calories, nil
+}
+
+// Estimate the calories of the workouts again after the weight on the date
+// was set or deleted. The closest weight is the last one on or before the
+// workout, or the first one after it when there isn't one, so only the
+// workouts from the date until the next weight can change, and the ones
+// before the date when there isn't a weight before it. Should be called in
+// a transaction.
+func updateCalories(ctx context.Context, q *database.Queries, userID int32, date int64) error {
+	around, err := q.GetWeightsAround(ctx, database.GetWeightsAroundParams{
+		Userid: userID, Date: date,
+	})
+	if err != nil {
+		return err
+	}
+	from, to := date, int64(math.MaxInt64)
+	if !around.Weightbefore.Valid {
+		from = math.MinInt64
+	}
+	if around.Weightafter.Valid {
+		to = around.Weightafter.Int64
+	}
+
+	rows, err := q.GetWorkoutsBetween(ctx, database.GetWorkoutsBetweenParams{
+		Userid: userID, FromDate: from, ToDate: to,
+	})
+	if err != nil {
+		return err
+	}
+	return reestimateCalories(ctx, q, rows)
+}
+
+// Estimate the calories of all of the user's workouts again, after the
+// units their weights are recorded in changed. Should be called in a
+// transaction.
+func updateAllCalories(ctx context.Context, q *database.Queries, userID int32) error {
+	rows, err := q.GetWorkoutsBetween(ctx, database.GetWorkoutsBetweenParams{
+		Userid: userID, FromDate: math.MinInt64, ToDate: math.MaxInt64,
+	})
+	if err != nil {
+		return err
+	}
+	return reestimateCalories(ctx, q, rows)
+}
+
+// Estimate the calories of the workouts again, saving the ones that changed
+func reestimateCalories(ctx context.Context, q *database.Queries, rows []database.Workout) error {
+	for _, row := range rows {
+		workout, err := getWorkout(ctx, q, row, pgtype.Bool{Bool: false, Valid: true})
+		if err != nil {
+			return err
+		}
+		calories, err := estimateCalories(ctx, q, row.Userid, workout)
+		if err != nil {
+			return err
+		}
+		if calories == row.Calories {
+			continue
+		}
+		if err := q.SetWorkoutCalories(ctx, database.SetWorkoutCaloriesParams{
+			Calories:     calories,
+			Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+			ID:           row.ID,
+			Userid:       row.Userid,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Estimate the calories of the workouts saved before they were estimated.
+// Should be called in a transaction.
+func backfillCalories(ctx context.Context, q *database.Queries) error {
+	rows, err := q.GetWorkoutsWithoutCalories(ctx)
+	if err != nil {
+		return err
+	}
+	return reestimateCalories(ctx, q, rows)
+}
+
+// Get the kcal eaten and burned on each day between two dates, inclusive.
+// Days without meals or workouts are left out.
+func (a *API) GetEnergyBalance(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	from, ok := getQuery[int64](w, r, "from")
+	if !ok {
+		return
+	}
+	to, ok := getQuery[int64](w, r, "to")
+	if !ok {
+		return
+	}
+
+	days := map[int64]*EnergyDayJSON{}
+	day := func(date int64) *EnergyDayJSON {
+		if days[date] == nil {
+			days[date] = &EnergyDayJSON{Date: date}
+		}
+		return days[date]
+	}
+
+	meals, err := a.queries.GetMealsInRange(a.ctx, database.GetMealsInRangeParams{
+		Userid: userID, FromDate: from, ToDate: to,
+	})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get energy balance")
+		return
+	}
+	foods, err := mealFoods(a.ctx, a.queries, meals)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get energy balance")
+		return
+	}
+	for _, meal := range meals {
+		food := foods[meal.Foodid]
+		day(meal.Date).Eaten += servingAmount(meal, food) * food.Calories
+	}
+
+	burned, err := a.queries.GetCaloriesBurned(a.ctx, database.GetCaloriesBurnedParams{
+		Userid: userID, FromDate: from, ToDate: to,
+	})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get energy balance")
+		return
+	}
+	for _, row := range burned {
+		day(row.Date).Burned += row.Calories
+	}
+
+	result := []EnergyDayJSON{}
+	for _, d := range days {
+		d.Balance = d.Eaten - d.Burned
+		result = append(result, *d)
+	}
+	slices.SortFunc(result, func(a, b EnergyDayJSON) int { return cmp.Compare(a.Date, b.Date) })
+	respond(w, http.StatusOK, map[string]any{"days": result})
+}
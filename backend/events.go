@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Devices find out about changes made on other devices through GET /events,
+// a stream of server-sent events. The change log trigger notifies the
+// "changes" channel when a change is committed, and every backend instance
+// listens on the channel and forwards the notifications to the streams of
+// the user they're for, so it doesn't matter which instance made the change.
+const changeChannel = "changes"
+
+// The payload of a notification on the changes channel
+type changeNotification struct {
+	UserID int32 `json:"userID"`
+	ChangeEventJSON
+}
+
+type ChangeFeed struct {
+	mutex       sync.Mutex
+	subscribers map[int32]map[chan ChangeEventJSON]bool
+}
+
+func NewChangeFeed() *ChangeFeed {
+	return &ChangeFeed{subscribers: map[int32]map[chan ChangeEventJSON]bool{}}
+}
+
+func (f *ChangeFeed) subscribe(userID int32) chan ChangeEventJSON {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	events := make(chan ChangeEventJSON, 64)
+	if f.subscribers[userID] == nil {
+		f.subscribers[userID] = map[chan ChangeEventJSON]bool{}
+	}
+	f.subscribers[userID][events] = true
+	return events
+}
+
+func (f *ChangeFeed) unsubscribe(userID int32, events chan ChangeEventJSON) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.remove(userID, events)
+}
+
+// Stop sending to the channel and close it. The mutex must be held.
+func (f *ChangeFeed) remove(userID int32, events chan ChangeEventJSON) {
+	if !f.subscribers[userID][events] {
+		return // already removed
+	}
+	delete(f.subscribers[userID], events)
+	if len(f.subscribers[userID]) == 0 {
+		delete(f.subscribers, userID)
+	}
+	close(events)
+}
+
+func (f *ChangeFeed) publish(n changeNotification) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for events := range f.subscribers[n.UserID] {
+		select {
+		case events <- n.ChangeEventJSON:
+		default:
+			// the stream isn't keeping up, so end it instead of
+			// dropping events. the client will reconnect and pull.
+			f.remove(n.UserID, events)
+		}
+	}
+}
+
+// End every stream, since they might have missed changes
+func (f *ChangeFeed) removeAll() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for userID, streams := range f.subscribers {
+		for events := range streams {
+			f.remove(userID, events)
+		}
+	}
+}
+
+// Forward the notifications on the changes channel until the context is
+// done, listening again on a new connection whenever the connection's lost
+func (f *ChangeFeed) listen(ctx context.Context, logger *log.Logger, pool *pgxpool.Pool) {
+	for {
+		err := f.receive(ctx, logger, pool)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Printf("listening for changes failed: %s\n", err.Error())
+		f.removeAll()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (f *ChangeFeed) receive(ctx context.Context, logger *log.Logger, pool *pgxpool.Pool) error {
+	pooled, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// take the connection out of the pool, so it isn't reused while listening
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "listen "+changeChannel); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var n changeNotification
+		if err := json.Unmarshal([]byte(notification.Payload), &n); err != nil {
+			logger.Printf("invalid change notification: %s\n", err.Error())
+			continue
+		}
+		f.publish(n)
+	}
+}
+
+// Stream the changes made to the user's data as server-sent events, so
+// devices can pull changes as soon as they're made instead of polling.
+// Changes made while the stream isn't open aren't sent, so clients should
+// pull after connecting, and reconnect then pull whenever the stream ends.
+func (a *API) Events(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+
+	events := a.events.subscribe(userID)
+	defer a.events.unsubscribe(userID, events)
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // stop proxies buffering the stream
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	// comments keep proxies from closing the connection when it's idle
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+		}
+
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
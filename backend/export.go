@@ -0,0 +1,475 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Workouts can be exported as a csv file laid out like the ones Strong and
+// Hevy export, so the history can be moved to other apps, and the cardio
+// exercises of a workout as a tcx file. The csv is written a page of
+// workouts at a time, so long histories aren't held in memory.
+
+const (
+	exportPageSize = 100
+	kmPerMile      = 1.609344
+)
+
+// How the exported dates and numbers are written
+type exportOptions struct {
+	imperial bool  // weights in lbs and distances in miles, when the format allows it
+	offset   int64 // the utc offset in milliseconds, for the local times
+}
+
+type csvExporter interface {
+	header() []string
+	// The rows of the workout, one for each set or cardio exercise
+	rows(w WorkoutJSON, options exportOptions) [][]string
+}
+
+var csvExporters = map[string]csvExporter{
+	"strong": strongCSV{},
+	"hevy":   hevyCSV{},
+}
+
+// Round to 2 decimals, without trailing zeros
+func formatNumber(x float64) string {
+	return strconv.FormatFloat(math.Round(x*100)/100, 'f', -1, 64)
+}
+
+// Empty when the value wasn't recorded
+func formatOptional(x float64) string {
+	if x == 0 {
+		return ""
+	}
+	return formatNumber(x)
+}
+
+// Workouts don't have a start time, so they're
+// exported as starting at their local midnight
+func localTime(date int64, options exportOptions) time.Time {
+	return time.UnixMilli(date + options.offset).UTC()
+}
+
+// Workouts don't have a duration either, so it's
+// estimated from how long their exercises took
+func workoutMinutes(w WorkoutJSON) float64 {
+	minutes := 0.0
+	for _, e := range w.Exercises {
+		minutes += exerciseMinutes(e)
+	}
+	return minutes
+}
+
+// Columns of the csv Strong exports. Weights and distances are in the
+// user's units, and warmup, drop and failure sets are numbered W, D and F.
+type strongCSV struct{}
+
+func (strongCSV) header() []string {
+	return []string{
+		"Date", "Workout Name", "Duration", "Exercise Name", "Set Order",
+		"Weight", "Reps", "Distance", "Seconds", "Notes", "Workout Notes", "RPE",
+	}
+}
+
+func (strongCSV) rows(w WorkoutJSON, options exportOptions) [][]string {
+	minutes := int(math.Round(workoutMinutes(w)))
+	duration := fmt.Sprintf("%dm", minutes%60)
+	if minutes >= 60 {
+		duration = fmt.Sprintf("%dh %s", minutes/60, duration)
+	}
+	date := localTime(w.Date, options).Format(time.DateTime)
+
+	weightUnit, distanceUnit := "kg", 1000.0
+	if options.imperial {
+		weightUnit, distanceUnit = "lbs", 1000*kmPerMile
+	}
+
+	rows := [][]string{}
+	for _, e := range w.Exercises {
+		if e.ExerciseType == "cardio" {
+			rows = append(rows, []string{
+				date, w.Name, duration, e.Name, "1", "0", "0",
+				formatNumber(e.Distance / distanceUnit), formatNumber(e.Duration * 60),
+				"", w.Notes, "",
+			})
+			continue
+		}
+
+		count := 0
+		for _, set := range e.Sets {
+			order := map[string]string{"warmup": "W", "drop": "D", "failure": "F"}[set.Type]
+			if len(order) == 0 {
+				count++
+				order = strconv.Itoa(count)
+			}
+			rows = append(rows, []string{
+				date, w.Name, duration, e.Name, order,
+				formatNumber(convertWeight(set.Weight, e.WeightUnit, weightUnit)),
+				strconv.Itoa(int(set.Reps)), "0", "0", "", w.Notes, formatOptional(set.RPE),
+			})
+		}
+	}
+	return rows
+}
+
+// Columns of the csv Hevy exports, which are always in metric units
+type hevyCSV struct{}
+
+const hevyTimeLayout = "2 Jan 2006, 15:04"
+
+var hevySetTypes = map[string]string{
+	"normal": "normal", "warmup": "warmup", "drop": "dropset", "failure": "failure",
+}
+
+func (hevyCSV) header() []string {
+	return []string{
+		"title", "start_time", "end_time", "description", "exercise_title",
+		"superset_id", "exercise_notes", "set_index", "set_type", "weight_kg",
+		"reps", "distance_km", "duration_seconds", "rpe",
+	}
+}
+
+func (hevyCSV) rows(w WorkoutJSON, options exportOptions) [][]string {
+	start := localTime(w.Date, options)
+	end := start.Add(time.Duration(workoutMinutes(w) * float64(time.Minute)))
+	workout := []string{
+		w.Name, start.Format(hevyTimeLayout), end.Format(hevyTimeLayout), w.Notes,
+	}
+
+	rows := [][]string{}
+	for _, e := range w.Exercises {
+		superset := "" // numbered from 0
+		if e.Group != 0 {
+			superset = strconv.Itoa(int(e.Group - 1))
+		}
+		if e.ExerciseType == "cardio" {
+			rows = append(rows, slices.Concat(workout, []string{
+				e.Name, superset, "", "0", "normal", "", "",
+				formatOptional(e.Distance / 1000), formatOptional(e.Duration * 60), "",
+			}))
+			continue
+		}
+		for i, set := range e.Sets {
+			rows = append(rows, slices.Concat(workout, []string{
+				e.Name, superset, "", strconv.Itoa(i), hevySetTypes[set.Type],
+				formatNumber(toKg(set.Weight, e.WeightUnit)), strconv.Itoa(int(set.Reps)),
+				"", "", formatOptional(set.RPE),
+			}))
+		}
+	}
+	return rows
+}
+
+// Stream the user's workouts in the date range as csv, in the format
+// of an app. Templates aren't exported.
+func (a *API) ExportWorkouts(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	format, ok := getOptionalQuery(w, r, "format", "strong")
+	if !ok {
+		return
+	}
+	from, ok := getOptionalQuery[int64](w, r, "from", 0)
+	if !ok {
+		return
+	}
+	to, ok := getOptionalQuery[int64](w, r, "to", math.MaxInt64)
+	if !ok {
+		return
+	}
+	offset, ok := getOptionalQuery[int64](w, r, "utcOffset", 0)
+	if !ok {
+		return
+	}
+
+	exporter, ok := csvExporters[format]
+	if !ok {
+		respond(w, http.StatusBadRequest, "Format must be strong or hevy")
+		return
+	}
+	if offset < -14*60 || offset > 14*60 {
+		respond(w, http.StatusBadRequest, "Invalid utc offset")
+		return
+	}
+
+	settings, err := a.queries.GetUserSettings(a.ctx, userID)
+	if err != nil && err != pgx.ErrNoRows {
+		respond(w, http.StatusInternalServerError, "Couldn't export workouts")
+		return
+	}
+	options := exportOptions{imperial: settings.Useimperial, offset: offset * 60 * 1000}
+
+	page := database.GetWorkoutPageParams{
+		Userid: userID, Limit: exportPageSize, FromDate: from, ToDate: to,
+		AfterDate: math.MinInt64,
+	}
+	workouts, err := a.exportPage(page)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't export workouts")
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="workouts.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	writer.Write(exporter.header())
+	for {
+		for _, workout := range workouts {
+			for _, row := range exporter.rows(workout, options) {
+				writer.Write(row)
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return // the client's gone
+		}
+		controller.Flush()
+
+		if len(workouts) < exportPageSize {
+			return
+		}
+		last := workouts[len(workouts)-1]
+		page.AfterDate, page.AfterID = last.Date, last.ID
+		workouts, err = a.exportPage(page)
+		if err != nil {
+			// the status has already been sent, so abort the response
+			// for the client to see it as failed instead of cut short
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// Get a page of workouts with their exercises and sets
+func (a *API) exportPage(page database.GetWorkoutPageParams) ([]WorkoutJSON, error) {
+	rows, err := a.queries.GetWorkoutPage(a.ctx, page)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	ids := []int32{}
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+
+	exerciseRows, err := a.queries.GetExercisesOfWorkouts(a.ctx, database.GetExercisesOfWorkoutsParams{
+		Userid: page.Userid, Ids: ids,
+	})
+	if err != nil {
+		return nil, err
+	}
+	setRows, err := a.queries.GetSetsOfWorkouts(a.ctx, database.GetSetsOfWorkoutsParams{
+		Userid: page.Userid, Ids: ids,
+	})
+	if err != nil {
+		return nil, err
+	}
+	exercises := map[int32][]database.Exercise{} // by workout id
+	for _, row := range exerciseRows {
+		exercises[row.Workoutid] = append(exercises[row.Workoutid], row)
+	}
+	sets := map[int32][]database.Set{} // by workout id
+	for _, row := range setRows {
+		sets[row.Workoutid] = append(sets[row.Workoutid], row)
+	}
+
+	workouts := []WorkoutJSON{}
+	for _, row := range rows {
+		workout, err := buildWorkout(row, exercises[row.ID], sets[row.ID])
+		if err != nil {
+			return nil, err
+		}
+		workouts = append(workouts, workout)
+	}
+	return workouts, nil
+}
+
+type tcxExport struct {
+	XMLName    xml.Name      `xml:"TrainingCenterDatabase"`
+	Namespace  string        `xml:"xmlns,attr"`
+	Activities []tcxActivity `xml:"Activities>Activity"`
+}
+
+type tcxActivity struct {
+	Sport string `xml:"Sport,attr"`
+	ID    string `xml:"Id"`
+	Lap   tcxLap `xml:"Lap"`
+}
+
+type tcxLap struct {
+	StartTime        string    `xml:"StartTime,attr"`
+	TotalTimeSeconds float64   `xml:"TotalTimeSeconds"`
+	DistanceMeters   float64   `xml:"DistanceMeters"`
+	Calories         int32     `xml:"Calories"`
+	AvgHeartRate     *tcxValue `xml:"AverageHeartRateBpm,omitempty"`
+	MaxHeartRate     *tcxValue `xml:"MaximumHeartRateBpm,omitempty"`
+	Intensity        string    `xml:"Intensity"`
+	TriggerMethod    string    `xml:"TriggerMethod"`
+	Track            *tcxTrack `xml:"Track,omitempty"`
+}
+
+type tcxTrack struct {
+	Points []tcxPoint `xml:"Trackpoint"`
+}
+
+type tcxPoint struct {
+	Time      string    `xml:"Time"`
+	Lat       float64   `xml:"Position>LatitudeDegrees"`
+	Lon       float64   `xml:"Position>LongitudeDegrees"`
+	Altitude  float64   `xml:"AltitudeMeters"`
+	HeartRate *tcxValue `xml:"HeartRateBpm,omitempty"`
+}
+
+type tcxValue struct {
+	Value int32 `xml:"Value"`
+}
+
+// Heart rates of 0 weren't recorded, so they're left out
+func heartRate(bpm int32) *tcxValue {
+	if bpm == 0 {
+		return nil
+	}
+	return &tcxValue{bpm}
+}
+
+const tcxNamespace = "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2"
+
+// Tcx only knows about running and biking
+func tcxSport(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.Contains(name, "run"):
+		return "Running"
+	case strings.Contains(name, "cycl") || strings.Contains(name, "bik"):
+		return "Biking"
+	default:
+		return "Other"
+	}
+}
+
+// Get the cardio exercises of a workout as a tcx file, one activity for
+// each exercise, with the gps track stored with it. Exercises without a
+// track are placed one after the other from the workout's local midnight.
+// The times are written with the client's utc offset.
+func (a *API) ExportWorkoutTCX(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	workoutID, ok := getPathValue[int64](w, r, "id")
+	if !ok {
+		return
+	}
+	offset, ok := getOptionalQuery[int64](w, r, "utcOffset", 0)
+	if !ok {
+		return
+	}
+	if offset < -14*60 || offset > 14*60 {
+		respond(w, http.StatusBadRequest, "Invalid utc offset")
+		return
+	}
+	zone := time.FixedZone("", int(offset*60))
+
+	row, err := a.queries.GetWorkout(a.ctx, database.GetWorkoutParams{
+		ID: int32(workoutID), Userid: userID,
+	})
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Workout not found")
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't export workout")
+		return
+	}
+	workout, err := getWorkout(a.ctx, a.queries, row, pgtype.Bool{Bool: false, Valid: true})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't export workout")
+		return
+	}
+
+	export := tcxExport{Namespace: tcxNamespace}
+	minutes := workoutMinutes(workout)
+	start := time.UnixMilli(workout.Date).In(zone)
+	for _, e := range workout.Exercises {
+		if e.ExerciseType != "cardio" {
+			continue
+		}
+		lap := tcxLap{
+			TotalTimeSeconds: e.Duration * 60, DistanceMeters: e.Distance,
+			AvgHeartRate: heartRate(e.AvgHeartRate), MaxHeartRate: heartRate(e.MaxHeartRate),
+			Intensity: "Active", TriggerMethod: "Manual",
+		}
+		if minutes > 0 {
+			// the workout's calories, split by how long the exercise took
+			lap.Calories = int32(math.Round(workout.Calories * e.Duration / minutes))
+		}
+
+		encoded, err := a.queries.GetTrack(a.ctx, database.GetTrackParams{
+			Exerciseid: e.ID, Userid: userID,
+		})
+		if err != nil && err != pgx.ErrNoRows {
+			respond(w, http.StatusInternalServerError, "Couldn't export workout")
+			return
+		}
+		var points []TrackPointJSON
+		if err == nil {
+			if err := json.Unmarshal(encoded, &points); err != nil {
+				respond(w, http.StatusInternalServerError, "Couldn't export workout")
+				return
+			}
+		}
+
+		lapStart := start
+		if i := slices.IndexFunc(points, func(p TrackPointJSON) bool { return p.Time != 0 }); i != -1 {
+			lapStart = time.UnixMilli(points[i].Time).In(zone)
+		}
+		if len(points) > 0 {
+			lap.Track = &tcxTrack{}
+		}
+		pointStart := lapStart
+		for _, p := range points {
+			if p.Time != 0 {
+				pointStart = time.UnixMilli(p.Time).In(zone)
+			}
+			// points without a time get the time of the point before them
+			lap.Track.Points = append(lap.Track.Points, tcxPoint{
+				Time: pointStart.Format(time.RFC3339), Lat: p.Lat, Lon: p.Lon,
+				Altitude: p.Elevation, HeartRate: heartRate(p.HeartRate),
+			})
+		}
+		lap.StartTime = lapStart.Format(time.RFC3339)
+		export.Activities = append(export.Activities, tcxActivity{
+			Sport: tcxSport(e.Name), ID: lap.StartTime, Lap: lap,
+		})
+		start = lapStart.Add(time.Duration(e.Duration * float64(time.Minute)))
+	}
+	if len(export.Activities) == 0 {
+		respond(w, http.StatusNotFound, "The workout doesn't have any cardio exercises")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.garmin.tcx+xml")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="workout-%d.tcx"`, workout.ID))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	encoder.Encode(export)
+}
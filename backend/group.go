@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Exercises can be grouped into supersets and circuits, where a set of each
+// exercise is done in turn, for a number of rounds. When a workout is
+// started from a template, the grouped exercises get a set for each round,
+// with the group's rest prescribed on them.
+
+var groupTypes = []string{"superset", "circuit"}
+
+// Check the workout's groups and encode them so they can be stored with
+// it. Every group needs at least two exercises, next to each other.
+func encodeGroups(w WorkoutJSON) ([]byte, error) {
+	if len(w.Groups) == 0 {
+		for _, e := range w.Exercises {
+			if e.Group != 0 {
+				return nil, mutationError(fmt.Sprintf("group %d doesn't exist", e.Group))
+			}
+		}
+		return nil, nil
+	}
+
+	for i, g := range w.Groups {
+		if !slices.Contains(groupTypes, g.Type) {
+			return nil, mutationError(fmt.Sprintf("unknown group type %q", g.Type))
+		}
+		if g.Rounds < 1 || g.RestSeconds < 0 || g.RoundRestSeconds < 0 {
+			return nil, mutationError(fmt.Sprintf("invalid group %d", i+1))
+		}
+	}
+
+	counts := make([]int, len(w.Groups)+1) // the exercises in each group
+	for i, e := range w.Exercises {
+		if e.Group < 0 || int(e.Group) > len(w.Groups) {
+			return nil, mutationError(fmt.Sprintf("group %d doesn't exist", e.Group))
+		}
+		if e.Group != 0 && counts[e.Group] > 0 && w.Exercises[i-1].Group != e.Group {
+			return nil, mutationError(fmt.Sprintf("the exercises of group %d aren't together", e.Group))
+		}
+		counts[e.Group]++
+	}
+	for group, count := range counts[1:] {
+		if count < 2 {
+			return nil, mutationError(fmt.Sprintf("group %d has less than 2 exercises", group+1))
+		}
+	}
+	return json.Marshal(w.Groups)
+}
+
+func decodeGroups(data []byte) ([]ExerciseGroupJSON, error) {
+	groups := []ExerciseGroupJSON{}
+	if data == nil {
+		return groups, nil
+	}
+	err := json.Unmarshal(data, &groups)
+	return groups, err
+}
+
+// Give the strength exercises in groups a set for each round of their
+// group, repeating their last set or dropping the extra ones, and prescribe
+// the group's rest on the sets. The last exercise of a round rests for the
+// round rest instead.
+func prescribeRounds(w WorkoutJSON) WorkoutJSON {
+	for i, e := range w.Exercises {
+		if e.Group == 0 || e.ExerciseType != "strength" {
+			continue
+		}
+		group := w.Groups[e.Group-1]
+		rest := group.RestSeconds
+		if i == len(w.Exercises)-1 || w.Exercises[i+1].Group != e.Group {
+			rest = group.RoundRestSeconds
+		}
+
+		sets := []SetJSON{}
+		for round := range int(group.Rounds) {
+			set := SetJSON{Type: "normal"}
+			if len(e.Sets) > 0 {
+				set = e.Sets[min(round, len(e.Sets)-1)]
+			}
+			set.RestSeconds = rest
+			sets = append(sets, set)
+		}
+		w.Exercises[i].Sets = sets
+	}
+	return w
+}
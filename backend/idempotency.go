@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aabiji/logbuddy/database"
+)
+
+// Mobile networks drop responses and clients retry, so requests that create
+// entities can be sent with an Idempotency-Key header. The first request with
+// a key is handled as usual and its response is stored, then requests with the
+// same key get the stored response instead of creating a duplicate. Keys are
+// scoped to the user, and forgotten once they're older than the retention
+// window. Only successful responses are stored, so failed requests can be
+// retried with the same key.
+const idempotencyHeader = "Idempotency-Key"
+
+// How long a request can be handled for before its key is
+// considered abandoned and another request can claim it
+const abandonedAfter = time.Minute
+
+// Passes the response through while keeping a copy of it
+type recordingResponseWriter struct {
+	w          http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *recordingResponseWriter) Header() http.Header { return r.w.Header() }
+func (r *recordingResponseWriter) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.w.Write(data)
+}
+func (r *recordingResponseWriter) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.w.WriteHeader(statusCode)
+}
+
+// Handle requests with an Idempotency-Key header at most once
+func (a *API) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get(idempotencyHeader))
+		userID, ok := tokenUserID(r)
+		if len(key) == 0 || !ok { // the handler rejects unauthenticated requests
+			next(w, r)
+			return
+		}
+		if len(key) > 255 {
+			respond(w, http.StatusBadRequest, "bad request: Idempotency-Key is too long")
+			return
+		}
+
+		// the same key can't be reused for a different request
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			respond(w, http.StatusBadRequest, "Failed to read request")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		hash := hex.EncodeToString(sum[:])
+		route := unversionedPattern(r.Pattern)
+
+		now := time.Now()
+		claimed, err := a.queries.ClaimIdempotencyKey(a.ctx, database.ClaimIdempotencyKeyParams{
+			Userid:      userID,
+			Key:         key,
+			Route:       route,
+			Requesthash: hash,
+			Createdat:   now.Unix(),
+			StaleBefore: now.Add(-abandonedAfter).Unix(),
+		})
+		if err != nil {
+			respond(w, http.StatusInternalServerError, "Couldn't check Idempotency-Key")
+			return
+		}
+		if claimed == 0 {
+			a.replay(w, userID, key, route, hash)
+			return
+		}
+
+		recorder := &recordingResponseWriter{w: w, statusCode: http.StatusOK}
+		next(recorder, r)
+
+		// the response has already been sent, so if these fail the key is
+		// left claimed until it's abandoned, then it can be claimed again
+		if recorder.statusCode >= 200 && recorder.statusCode < 300 {
+			a.queries.SaveIdempotentResponse(a.ctx, database.SaveIdempotentResponseParams{
+				Status:   int32(recorder.statusCode),
+				Response: recorder.body.String(),
+				Userid:   userID,
+				Key:      key,
+			})
+		} else {
+			a.queries.DeleteIdempotencyKey(a.ctx, database.DeleteIdempotencyKeyParams{
+				Userid: userID, Key: key,
+			})
+		}
+	}
+}
+
+// Send the stored response of the request that claimed the key
+func (a *API) replay(w http.ResponseWriter, userID int32, key, route, hash string) {
+	stored, err := a.queries.GetIdempotencyKey(a.ctx, database.GetIdempotencyKeyParams{
+		Userid: userID, Key: key,
+	})
+	if err != nil || stored.Status == 0 {
+		// the key's either being handled or was just released by a failed request
+		respond(w, http.StatusConflict, "A request with this Idempotency-Key is being handled")
+		return
+	}
+	if stored.Route != route || stored.Requesthash != hash {
+		respond(w, http.StatusUnprocessableEntity,
+			"Idempotency-Key was already used for a different request")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(stored.Status))
+	io.WriteString(w, stored.Response)
+}
+
+// Forget the keys older than the retention window every so often
+func cleanupIdempotencyKeys(logger *log.Logger, a *API, retention time.Duration) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+			before := time.Now().Add(-retention).Unix()
+			if err := a.queries.DeleteExpiredIdempotencyKeys(a.ctx, before); err != nil {
+				logger.Printf("idempotency key cleanup failed: %s\n", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
+)
+
+// Workouts can be imported from the csv files Strong, Hevy and FitNotes
+// export, which are told apart by their header. The rows are grouped into
+// workouts and exercises, and the exercise names are matched to the
+// catalog. Imports are dry runs unless asked otherwise, so the user can
+// check what would be imported first. Rows that can't be imported are
+// reported instead of failing the whole import, and workouts that are
+// already logged are skipped, so importing the same file twice is safe.
+
+const maxImportSize = 20 << 20 // in bytes
+
+// How the rows of formats that don't say their units are read
+type importOptions struct {
+	imperial bool  // weights in lbs and distances in miles
+	offset   int64 // the utc offset in milliseconds, for the local times
+}
+
+func (o importOptions) weightUnit() string {
+	if o.imperial {
+		return "lbs"
+	}
+	return "kg"
+}
+
+// In meters
+func (o importOptions) distanceUnit() float64 {
+	if o.imperial {
+		return 1000 * kmPerMile
+	}
+	return 1000
+}
+
+// A row of a csv file, with the indexes of its columns by name
+type csvRow struct {
+	columns map[string]int
+	fields  []string
+}
+
+func (r csvRow) get(column string) string {
+	i, ok := r.columns[column]
+	if !ok || i >= len(r.fields) {
+		return ""
+	}
+	return strings.TrimSpace(r.fields[i])
+}
+
+// Parse the numbers in the columns, where empty columns are 0
+func (r csvRow) numbers(columns ...string) ([]float64, error) {
+	values := []float64{}
+	for _, column := range columns {
+		value := r.get(column)
+		if len(value) == 0 {
+			values = append(values, 0)
+			continue
+		}
+		x, err := strconv.ParseFloat(value, 64)
+		if err != nil || x < 0 {
+			return nil, fmt.Errorf("%s isn't a positive number", column)
+		}
+		values = append(values, x)
+	}
+	return values, nil
+}
+
+func hasColumns(columns map[string]int, names ...string) bool {
+	for _, name := range names {
+		if _, ok := columns[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// A set, or a cardio exercise, read from a row
+type importedRow struct {
+	workout  string    // empty when the app doesn't name workouts
+	category string    // the muscle group the app puts the exercise in
+	start    time.Time // the local time, as if it were in utc
+	notes    string    // of the workout
+	exercise string
+	set      SetJSON
+	unit     string  // of the set's weight
+	distance float64 // in meters
+	minutes  float64
+}
+
+// Rows without reps or weight, but with a distance or
+// duration, are cardio exercises
+func (r importedRow) cardio() bool {
+	return r.set.Reps == 0 && r.set.Weight == 0 && (r.distance > 0 || r.minutes > 0)
+}
+
+type csvImporter interface {
+	// Check if the header has the columns the app exports
+	matches(columns map[string]int) bool
+	parse(row csvRow, options importOptions) (importedRow, error)
+}
+
+var csvImporters = map[string]csvImporter{
+	"strong":   strongCSV{},
+	"hevy":     hevyCSV{},
+	"fitnotes": fitNotesCSV{},
+}
+
+var strongSetTypes = map[string]string{"W": "warmup", "D": "drop", "F": "failure"}
+
+func (strongCSV) matches(columns map[string]int) bool {
+	return hasColumns(columns, "Date", "Workout Name", "Exercise Name", "Set Order", "Weight", "Reps")
+}
+
+func (strongCSV) parse(row csvRow, options importOptions) (importedRow, error) {
+	start, err := time.Parse(time.DateTime, row.get("Date"))
+	if err != nil {
+		return importedRow{}, errors.New("the date isn't like 2006-01-02 15:04:05")
+	}
+	order := row.get("Set Order")
+	setType, ok := strongSetTypes[order]
+	if _, err := strconv.Atoi(order); err == nil {
+		setType, ok = "normal", true
+	}
+	if !ok {
+		return importedRow{}, fmt.Errorf("unknown set order %q", order)
+	}
+	values, err := row.numbers("Weight", "Reps", "Distance", "Seconds", "RPE")
+	if err != nil {
+		return importedRow{}, err
+	}
+
+	return importedRow{
+		workout: row.get("Workout Name"), start: start, notes: row.get("Workout Notes"),
+		exercise: row.get("Exercise Name"), unit: options.weightUnit(),
+		set:      SetJSON{Weight: values[0], Reps: int32(values[1]), RPE: values[4], Type: setType},
+		distance: values[2] * options.distanceUnit(), minutes: values[3] / 60,
+	}, nil
+}
+
+// Hevy exports weights in lbs and distances in miles
+// for users that use imperial units
+func (hevyCSV) matches(columns map[string]int) bool {
+	return hasColumns(columns, "title", "start_time", "exercise_title", "set_type", "reps")
+}
+
+func (hevyCSV) parse(row csvRow, options importOptions) (importedRow, error) {
+	start, err := time.Parse(hevyTimeLayout, row.get("start_time"))
+	if err != nil {
+		return importedRow{}, errors.New("the start time isn't like 2 Jan 2006, 15:04")
+	}
+	setType := "normal"
+	if theirs := row.get("set_type"); len(theirs) > 0 {
+		setType = ""
+		for ours := range hevySetTypes {
+			if hevySetTypes[ours] == theirs {
+				setType = ours
+			}
+		}
+	}
+	if len(setType) == 0 {
+		return importedRow{}, fmt.Errorf("unknown set type %q", row.get("set_type"))
+	}
+
+	unit, weight := "kg", "weight_kg"
+	if hasColumns(row.columns, "weight_lbs") {
+		unit, weight = "lbs", "weight_lbs"
+	}
+	distanceUnit, distance := 1000.0, "distance_km"
+	if hasColumns(row.columns, "distance_miles") {
+		distanceUnit, distance = 1000*kmPerMile, "distance_miles"
+	}
+	values, err := row.numbers(weight, "reps", distance, "duration_seconds", "rpe")
+	if err != nil {
+		return importedRow{}, err
+	}
+
+	return importedRow{
+		workout: row.get("title"), start: start, notes: row.get("description"),
+		exercise: row.get("exercise_title"), unit: unit,
+		set:      SetJSON{Weight: values[0], Reps: int32(values[1]), RPE: values[4], Type: setType},
+		distance: values[2] * distanceUnit, minutes: values[3] / 60,
+	}, nil
+}
+
+// Columns of the csv FitNotes exports. It doesn't name workouts, so the
+// exercises done on a day are in one workout, named after their categories.
+type fitNotesCSV struct{}
+
+var fitNotesDistances = map[string]float64{
+	"": 1000, "m": 1, "km": 1000, "mi": 1000 * kmPerMile, "miles": 1000 * kmPerMile,
+}
+
+func (fitNotesCSV) matches(columns map[string]int) bool {
+	return hasColumns(columns, "Date", "Exercise", "Category", "Reps")
+}
+
+func (fitNotesCSV) parse(row csvRow, options importOptions) (importedRow, error) {
+	start, err := time.Parse(time.DateOnly, row.get("Date"))
+	if err != nil {
+		return importedRow{}, errors.New("the date isn't like 2006-01-02")
+	}
+	unit, weight := "kg", "Weight (kgs)"
+	if hasColumns(row.columns, "Weight (lbs)") {
+		unit, weight = "lbs", "Weight (lbs)"
+	}
+	values, err := row.numbers(weight, "Reps", "Distance")
+	if err != nil {
+		return importedRow{}, err
+	}
+	distanceUnit, ok := fitNotesDistances[strings.ToLower(row.get("Distance Unit"))]
+	if !ok {
+		return importedRow{}, fmt.Errorf("unknown distance unit %q", row.get("Distance Unit"))
+	}
+
+	// the time is written like 1:05:30
+	seconds := 0
+	if clock := row.get("Time"); len(clock) > 0 {
+		for _, part := range strings.Split(clock, ":") {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 {
+				return importedRow{}, errors.New("the time isn't like 1:05:30")
+			}
+			seconds = seconds*60 + n
+		}
+	}
+
+	return importedRow{
+		category: row.get("Category"), start: start,
+		exercise: row.get("Exercise"), unit: unit,
+		set:      SetJSON{Weight: values[0], Reps: int32(values[1]), Type: "normal"},
+		distance: values[2] * distanceUnit, minutes: float64(seconds) / 60,
+	}, nil
+}
+
+// A workout read from the file
+type importedWorkout struct {
+	WorkoutJSON
+	row        int      // the line of its first row
+	start      int64    // when it started, in milliseconds
+	categories []string // of its exercises, in order
+}
+
+// Read the rows into workouts, in the order they first appear in the
+// file, skipping the rows that can't be imported. Fails when the file
+// isn't valid csv.
+func readWorkouts(
+	reader *csv.Reader, importer csvImporter, columns map[string]int, options importOptions,
+) ([]*importedWorkout, []SkippedRowJSON, error) {
+	workouts := []*importedWorkout{}
+	indexes := map[string]int{} // by name and start
+	skipped := []SkippedRowJSON{}
+
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		skip := func(reason string) {
+			skipped = append(skipped, SkippedRowJSON{Row: line, Reason: reason})
+		}
+
+		row, err := importer.parse(csvRow{columns, fields}, options)
+		if err != nil {
+			skip(err.Error())
+			continue
+		}
+		if len(row.exercise) == 0 {
+			skip("the row doesn't have an exercise")
+			continue
+		}
+		if !row.cardio() && row.set.Reps == 0 {
+			skip("the set doesn't have any reps")
+			continue
+		}
+
+		key := row.workout + "\x00" + row.start.Format(time.DateTime)
+		if row.workout == "" {
+			key = row.start.Format(time.DateOnly) // one workout a day
+		}
+		index, ok := indexes[key]
+		if !ok {
+			midnight := time.Date(row.start.Year(), row.start.Month(), row.start.Day(), 0, 0, 0, 0, time.UTC)
+			index = len(workouts)
+			indexes[key] = index
+			workouts = append(workouts, &importedWorkout{
+				WorkoutJSON: WorkoutJSON{
+					Name: row.workout, Notes: row.notes,
+					Date: midnight.UnixMilli() - options.offset, Exercises: []ExerciseJSON{},
+				},
+				row:   line,
+				start: row.start.UnixMilli() - options.offset,
+			})
+		}
+		workout := workouts[index]
+		if len(row.category) > 0 && !slices.Contains(workout.categories, row.category) {
+			workout.categories = append(workout.categories, row.category)
+		}
+
+		i := slices.IndexFunc(workout.Exercises, func(e ExerciseJSON) bool {
+			return e.Name == row.exercise
+		})
+		if i == -1 {
+			exerciseType := "strength"
+			if row.cardio() {
+				exerciseType = "cardio"
+			}
+			i = len(workout.Exercises)
+			workout.Exercises = append(workout.Exercises, ExerciseJSON{
+				Name: row.exercise, ExerciseType: exerciseType, WeightUnit: row.unit,
+				Reps: []int32{}, Sets: []SetJSON{},
+			})
+		}
+		e := &workout.Exercises[i]
+		if row.cardio() != (e.ExerciseType == "cardio") {
+			skip("the exercise has both sets and cardio rows")
+			continue
+		}
+		if row.cardio() {
+			e.Distance += row.distance
+			e.Duration += row.minutes
+			continue
+		}
+		row.set.Weight = convertWeight(row.set.Weight, row.unit, e.WeightUnit)
+		e.Sets = append(e.Sets, row.set)
+	}
+
+	for _, workout := range workouts {
+		if len(workout.Name) == 0 {
+			workout.Name = strings.Join(workout.categories, ", ")
+		}
+		if len(workout.Name) == 0 {
+			workout.Name = "Workout"
+		}
+	}
+	return workouts, skipped, nil
+}
+
+// Find the catalog exercise with the name. The apps put the equipment in
+// parentheses, like "Bench Press (Barbell)", so names like that are also
+// tried with the equipment in front, then without it when the catalog
+// exercise uses that equipment or doesn't need any.
+func reconcileExercise(
+	ctx context.Context, q *database.Queries, userID int32, name string,
+) (ImportedExerciseJSON, error) {
+	result := ImportedExerciseJSON{Name: name}
+	find := func(candidate string, equipment string) (bool, error) {
+		id, err := q.FindCatalogExercise(ctx, database.FindCatalogExerciseParams{
+			Userid: userID, Name: candidate,
+		})
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		row, err := q.GetCatalogExercise(ctx, database.GetCatalogExerciseParams{ID: id, Userid: userID})
+		if err != nil {
+			return false, err
+		}
+		if len(equipment) > 0 && !strings.EqualFold(row.Equipment, equipment) &&
+			row.Equipment != "none" && row.Equipment != "other" {
+			return false, nil
+		}
+		result.CatalogID, result.CatalogName = row.ID, row.Name
+		return true, nil
+	}
+
+	candidates := [][2]string{{name, ""}} // the names, with the equipment they need
+	start, end := strings.LastIndex(name, " ("), len(name)-1
+	if start > 0 && name[end] == ')' {
+		base, equipment := name[:start], name[start+2:end]
+		candidates = append(candidates, [2]string{equipment + " " + base, ""}, [2]string{base, equipment})
+	}
+	for _, candidate := range candidates {
+		found, err := find(candidate[0], candidate[1])
+		if found || err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Import the workouts in a csv file exported by Strong, Hevy or FitNotes,
+// sent as the request body. Nothing's saved unless dryRun is false.
+func (a *API) ImportWorkouts(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	dryRun, ok := getOptionalQuery(w, r, "dryRun", true)
+	if !ok {
+		return
+	}
+	offset, ok := getOptionalQuery[int64](w, r, "utcOffset", 0)
+	if !ok {
+		return
+	}
+	if offset < -14*60 || offset > 14*60 {
+		respond(w, http.StatusBadRequest, "Invalid utc offset")
+		return
+	}
+
+	settings, err := a.queries.GetUserSettings(a.ctx, userID)
+	if err != nil && err != pgx.ErrNoRows {
+		respond(w, http.StatusInternalServerError, "Couldn't import workouts")
+		return
+	}
+	options := importOptions{imperial: settings.Useimperial, offset: offset * 60 * 1000}
+
+	reader := csv.NewReader(http.MaxBytesReader(w, r.Body, maxImportSize))
+	reader.FieldsPerRecord = -1 // some apps leave out trailing empty columns
+	header, err := reader.Read()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respond(w, http.StatusRequestEntityTooLarge, "The file is too large")
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusBadRequest, "bad request: the file isn't a csv file")
+		return
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))] = i
+	}
+
+	format := ""
+	for _, name := range slices.Sorted(maps.Keys(csvImporters)) {
+		if csvImporters[name].matches(columns) {
+			format = name
+			break
+		}
+	}
+	if len(format) == 0 {
+		respond(w, http.StatusBadRequest, "bad request: the file isn't a Strong, Hevy or FitNotes export")
+		return
+	}
+
+	workouts, skipped, err := readWorkouts(reader, csvImporters[format], columns, options)
+	if errors.As(err, &tooLarge) {
+		respond(w, http.StatusRequestEntityTooLarge, "The file is too large")
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusBadRequest, fmt.Sprintf("bad request: %s", err.Error()))
+		return
+	}
+	// so the workouts are reported in the order they were done
+	slices.SortStableFunc(workouts, func(a, b *importedWorkout) int {
+		return cmp.Compare(a.Date, b.Date)
+	})
+
+	tx, err := a.conn.Begin(a.ctx)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't import workouts")
+		return
+	}
+	defer tx.Rollback(a.ctx)
+	qtx := a.queries.WithTx(tx)
+
+	report := ImportReportJSON{
+		Format: format, DryRun: dryRun, Workouts: []WorkoutJSON{},
+		Exercises: []ImportedExerciseJSON{}, Skipped: skipped,
+	}
+	reconciled := map[string]ImportedExerciseJSON{} // by the name in the file
+	for _, workout := range workouts {
+		// workouts are found by the start time they were grouped by, so
+		// workouts with the same name on the same day aren't mistaken
+		// for each other, even in the same file
+		_, err := qtx.FindWorkout(a.ctx, database.FindWorkoutParams{
+			Userid: userID, Date: workout.Date, Name: workout.Name, Starttime: workout.start,
+		})
+		if err == nil {
+			report.Skipped = append(report.Skipped, SkippedRowJSON{
+				Row: workout.row, Reason: fmt.Sprintf("%q is already logged on its date", workout.Name),
+			})
+			continue
+		}
+		if err != pgx.ErrNoRows {
+			respond(w, http.StatusInternalServerError, "Couldn't import workouts")
+			return
+		}
+
+		for i, e := range workout.Exercises {
+			match, ok := reconciled[e.Name]
+			if !ok {
+				match, err = reconcileExercise(a.ctx, qtx, userID, e.Name)
+				if err != nil {
+					respond(w, http.StatusInternalServerError, "Couldn't import workouts")
+					return
+				}
+				reconciled[e.Name] = match
+				report.Exercises = append(report.Exercises, match)
+			}
+			if match.CatalogID != 0 {
+				workout.Exercises[i].Name = match.CatalogName
+				workout.Exercises[i].CatalogID = match.CatalogID
+			}
+		}
+
+		if !dryRun {
+			created, err := insertWorkout(a.ctx, qtx, userID, workout.WorkoutJSON)
+			if err != nil {
+				respond(w, http.StatusInternalServerError, "Couldn't import workouts")
+				return
+			}
+			if err := qtx.SetWorkoutStartTime(a.ctx, database.SetWorkoutStartTimeParams{
+				Starttime: workout.start, ID: created.ID, Userid: userID,
+			}); err != nil {
+				respond(w, http.StatusInternalServerError, "Couldn't import workouts")
+				return
+			}
+			report.Workouts = append(report.Workouts, created)
+			continue
+		}
+
+		preview, err := previewWorkout(a.ctx, qtx, userID, workout.WorkoutJSON)
+		if err != nil {
+			respond(w, http.StatusInternalServerError, "Couldn't import workouts")
+			return
+		}
+		report.Workouts = append(report.Workouts, preview)
+	}
+
+	if !dryRun {
+		// the records of each exercise are found once, from
+		// the date of the first imported workout with it
+		if err := updateRecords(a.ctx, qtx, userID, report.Workouts...); err != nil {
+			respond(w, http.StatusInternalServerError, "Couldn't import workouts")
+			return
+		}
+		if err := tx.Commit(a.ctx); err != nil {
+			respond(w, http.StatusInternalServerError, "Couldn't import workouts")
+			return
+		}
+	}
+	slices.SortStableFunc(report.Skipped, func(a, b SkippedRowJSON) int {
+		return cmp.Compare(a.Row, b.Row)
+	})
+	respond(w, http.StatusOK, map[string]any{"report": report})
+}
+
+// The workout as it would be created, without ids
+func previewWorkout(
+	ctx context.Context, q *database.Queries, userID int32, workout WorkoutJSON,
+) (WorkoutJSON, error) {
+	var err error
+	workout.Groups = []ExerciseGroupJSON{}
+	for i := range workout.Exercises {
+		workout.Exercises[i], err = fillSets(workout.Exercises[i])
+		if err != nil {
+			return workout, err
+		}
+		workout.Exercises[i] = withCardioStats(workout.Exercises[i])
+	}
+	workout.Calories, err = estimateCalories(ctx, q, userID, workout)
+	return workout, err
+}
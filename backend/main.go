@@ -10,6 +10,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 
@@ -28,10 +29,17 @@ func (l *LoggingResponseWriter) WriteHeader(statusCode int) {
 	l.w.WriteHeader(statusCode)
 }
 
+// Lets http.ResponseController flush the underlying writer
+func (l *LoggingResponseWriter) Unwrap() http.ResponseWriter { return l.w }
+
 type API struct {
 	ctx     context.Context
 	conn    *pgxpool.Pool
 	queries *database.Queries
+	events  *ChangeFeed
+
+	spec []byte // the openapi document served at /openapi.json
+	docs []byte // the page rendering the openapi document
 }
 
 func NewAPI() (API, error) {
@@ -59,8 +67,53 @@ func NewAPI() (API, error) {
 		return API{}, err
 	}
 
+	spec, err := os.ReadFile("docs/openapi.json")
+	if err != nil {
+		return API{}, err
+	}
+
+	docs, err := os.ReadFile("docs/index.html")
+	if err != nil {
+		return API{}, err
+	}
+
 	queries := database.New(conn)
-	return API{ctx, conn, queries}, nil
+	if err := seedCatalog(ctx, conn, queries, "data/exercises.json"); err != nil {
+		return API{}, err
+	}
+	if err := runBackfill(ctx, conn, queries, "sets", backfillSets); err != nil {
+		return API{}, err
+	}
+	if err := runBackfill(ctx, conn, queries, "personal-records", backfillRecords); err != nil {
+		return API{}, err
+	}
+	if err := runBackfill(ctx, conn, queries, "workout-calories", backfillCalories); err != nil {
+		return API{}, err
+	}
+
+	return API{ctx, conn, queries, NewChangeFeed(), spec, docs}, nil
+}
+
+// Run the one-off change to the existing data, unless it's already been run
+func runBackfill(
+	ctx context.Context, conn *pgxpool.Pool, q *database.Queries,
+	name string, backfill func(ctx context.Context, q *database.Queries) error,
+) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	qtx := q.WithTx(tx)
+
+	started, err := qtx.StartBackfill(ctx, name)
+	if err != nil || started == 0 {
+		return err
+	}
+	if err := backfill(ctx, qtx); err != nil {
+		return fmt.Errorf("couldn't backfill %s: %w", name, err)
+	}
+	return tx.Commit(ctx)
 }
 
 func (a *API) Cleanup() {
@@ -91,10 +144,28 @@ func parseRequest[T any](w http.ResponseWriter, r *http.Request) (T, bool) {
 }
 
 func getQuery[T any](w http.ResponseWriter, r *http.Request, name string) (T, bool) {
-	var value T
-
 	params := r.URL.Query()
 	param := strings.TrimSpace(params.Get(name))
+	return parseParam[T](w, name, param)
+}
+
+// Like getQuery, but the fallback is used when the parameter isn't set
+func getOptionalQuery[T any](w http.ResponseWriter, r *http.Request, name string, fallback T) (T, bool) {
+	param := strings.TrimSpace(r.URL.Query().Get(name))
+	if len(param) == 0 {
+		return fallback, true
+	}
+	return parseParam[T](w, name, param)
+}
+
+func getPathValue[T any](w http.ResponseWriter, r *http.Request, name string) (T, bool) {
+	param := strings.TrimSpace(r.PathValue(name))
+	return parseParam[T](w, name, param)
+}
+
+func parseParam[T any](w http.ResponseWriter, name string, param string) (T, bool) {
+	var value T
+
 	if len(param) == 0 {
 		respond(w, http.StatusBadRequest, fmt.Sprintf("bad request: missing %s", name))
 		return value, false
@@ -117,24 +188,18 @@ func getQuery[T any](w http.ResponseWriter, r *http.Request, name string) (T, bo
 			return value, false
 		}
 		value = any(val).(T)
+	case bool:
+		val, err := strconv.ParseBool(param)
+		if err != nil {
+			respond(w, http.StatusBadRequest, fmt.Sprintf("bad request: %s is not bool", name))
+			return value, false
+		}
+		value = any(val).(T)
 	}
 
 	return value, true
 }
 
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
 func loggingMiddleware(logger *log.Logger, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		writer := &LoggingResponseWriter{w: w, statusCode: 0}
@@ -143,6 +208,105 @@ func loggingMiddleware(logger *log.Logger, next http.Handler) http.Handler {
 	})
 }
 
+// The routes of the first api version. Keep docs/openapi.json in sync
+// with this list, openapi_test.go will fail otherwise.
+func (a *API) v1Routes() []route {
+	return []route{
+		{"POST /user/new", a.CreateAccount, true},
+		{"POST /user/login", a.Login, true},
+		{"POST /user/settings", a.UpdateUserSettings, true},
+		{"GET /user/data", a.UpdatedUserData, true},
+		{"DELETE /user/delete", a.DeleteUser, true},
+
+		{"POST /food/new", a.idempotent(a.CreateFood), true},
+		{"GET /food/search", a.SearchFood, true},
+		{"GET /food/get", a.GetFood, true},
+
+		{"POST /meal/set", a.idempotent(a.SetMeal), true},
+		{"GET /meal/day", a.GetMeals, true},
+		{"DELETE /meal/delete", a.DeleteMeal, true},
+
+		{"POST /workout/create", a.idempotent(a.CreateWorkout), true},
+		{"DELETE /workout/delete", a.DeleteWorkout, true},
+
+		{"POST /weight/set", a.SetWeightEntry, true},
+		{"DELETE /weight/delete", a.DeleteWeightEntry, true},
+
+		{"POST /period/toggle", a.TogglePeriodDate, true},
+
+		// resource oriented routes that take json bodies instead of query strings
+		{"PUT /user/settings", a.UpdateUserSettings, false},
+		{"DELETE /user", a.DeleteAccount, false},
+
+		{"POST /foods", a.idempotent(a.CreateFood), false},
+		{"GET /foods", a.SearchFood, false},
+		{"GET /foods/{id}", a.FetchFood, false},
+
+		{"POST /meals", a.idempotent(a.CreateMeal), false},
+		{"GET /meals", a.ListMeals, false},
+		{"PATCH /meals/{id}", a.PatchMeal, false},
+		{"DELETE /meals/{id}", a.RemoveMeal, false},
+		{"GET /nutrition/day", a.GetNutritionDay, false},
+		{"GET /nutrition/range", a.GetNutritionRange, false},
+
+		{"POST /workouts", a.idempotent(a.CreateWorkout), false},
+		{"POST /workouts/{id}/start", a.StartWorkout, false},
+		{"PUT /workouts/{id}", a.UpdateWorkout, false},
+		{"PATCH /workouts/{id}", a.PatchWorkout, false},
+		{"DELETE /workouts/{id}", a.RemoveWorkout, false},
+
+		{"PUT /records/weight/{date}", a.PutWeight, false},
+		{"DELETE /records/weight/{date}", a.RemoveWeight, false},
+		{"PUT /records/period/{date}", a.PutPeriod, false},
+
+		{"GET /catalog", a.SearchCatalog, false},
+		{"POST /catalog", a.CreateCatalogExercise, false},
+		{"GET /exercises/{name}/records", a.GetExerciseRecords, false},
+		{"GET /exercises/{id}/track", a.GetTrack, false},
+		{"POST /cardio/upload", a.UploadActivity, false},
+		{"GET /records/personal", a.GetRecordFeed, false},
+
+		{"GET /analytics/volume", a.GetVolumeAnalytics, false},
+		{"GET /analytics/sets", a.GetMuscleSetAnalytics, false},
+		{"GET /analytics/frequency", a.GetFrequencyAnalytics, false},
+		{"GET /analytics/strength", a.GetStrengthAnalytics, false},
+		{"GET /energy", a.GetEnergyBalance, false},
+
+		{"GET /export/workouts", a.ExportWorkouts, false},
+		{"GET /export/workouts/{id}/tcx", a.ExportWorkoutTCX, false},
+		{"POST /import/workouts", a.ImportWorkouts, false},
+
+		{"POST /programs", a.CreateProgram, false},
+		{"GET /programs", a.ListPrograms, false},
+		{"GET /programs/{id}", a.FetchProgram, false},
+		{"DELETE /programs/{id}", a.RemoveProgram, false},
+		{"POST /programs/{id}/enroll", a.EnrollInProgram, false},
+		{"DELETE /programs/enrollment", a.LeaveProgram, false},
+		{"GET /programs/today", a.GetTodaysWorkout, false},
+		{"POST /programs/today/start", a.StartTodaysWorkout, false},
+		{"POST /programs/today/skip", a.SkipTodaysWorkout, false},
+		{"GET /programs/progress", a.GetProgramProgress, false},
+
+		{"POST /sync/push", a.SyncPush, false},
+		{"GET /sync/pull", a.SyncPull, false},
+		{"GET /events", a.Events, false},
+	}
+}
+
+func (a *API) versions() []apiVersion {
+	return []apiVersion{
+		{"/v1", a.v1Routes()},
+	}
+}
+
+// Routes that aren't part of any api version
+func (a *API) unversionedRoutes() []route {
+	return []route{
+		{"GET /openapi.json", a.OpenAPISpec, false},
+		{"GET /docs", a.APIDocs, false},
+	}
+}
+
 func main() {
 	api, err := NewAPI()
 	if err != nil {
@@ -151,30 +315,35 @@ func main() {
 	defer api.Cleanup()
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /user/new", api.CreateAccount)
-	mux.HandleFunc("POST /user/login", api.Login)
-	mux.HandleFunc("POST /user/settings", api.UpdateUserSettings)
-	mux.HandleFunc("GET /user/data", api.UpdatedUserData)
-	mux.HandleFunc("DELETE /user/delete", api.DeleteUser)
-
-	mux.HandleFunc("POST /food/new", api.CreateFood)
-	mux.HandleFunc("GET /food/search", api.SearchFood)
-	mux.HandleFunc("GET /food/get", api.GetFood)
-
-	mux.HandleFunc("POST /meal/set", api.SetMeal)
-	mux.HandleFunc("GET /meal/day", api.GetMeals)
-	mux.HandleFunc("DELETE /meal/delete", api.DeleteMeal)
+	mountVersions(mux, api.versions())
+	for _, route := range api.unversionedRoutes() {
+		mux.HandleFunc(route.pattern, route.handler)
+	}
 
-	mux.HandleFunc("POST /workout/create", api.CreateWorkout)
-	mux.HandleFunc("DELETE /workout/delete", api.DeleteWorkout)
+	logger := log.New(os.Stdout, "", log.Ltime)
+	proxies, err := trustedProxies()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	limiter := NewRateLimiter(&api)
+	go cleanupRateLimits(logger, api.ctx, limiter)
 
-	mux.HandleFunc("POST /weight/set", api.SetWeightEntry)
-	mux.HandleFunc("DELETE /weight/delete", api.DeleteWeightEntry)
+	policy, err := NewTombstonePolicy()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	go collectTombstones(logger, &api, policy)
+	go api.events.listen(api.ctx, logger, api.conn)
 
-	mux.HandleFunc("POST /period/toggle", api.TogglePeriodDate)
+	retention, err := envDuration("IDEMPOTENCY_RETENTION", 24*time.Hour)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	go cleanupIdempotencyKeys(logger, &api, retention)
 
-	logger := log.New(os.Stdout, "", log.Ltime)
-	handler := loggingMiddleware(logger, corsMiddleware(mux))
+	cors := NewCORSPolicy()
+	limited := rateLimitMiddleware(logger, limiter, proxies, mux)
+	handler := loggingMiddleware(logger, cors.middleware(limited))
 
 	port := os.Getenv("APP_PORT")
 	logger.Printf("Server starting at localhost:%s\n", port)
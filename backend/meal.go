@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
@@ -19,7 +22,19 @@ func (a *API) CreateFood(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := a.queries.CreateFood(a.ctx, database.CreateFoodParams{
+	id, err := createFood(a.ctx, a.queries, userID, req)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't create food")
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]any{"id": id})
+}
+
+func createFood(
+	ctx context.Context, q *database.Queries, userID int32, req FoodJSON,
+) (int32, error) {
+	return q.CreateFood(ctx, database.CreateFoodParams{
 		Userid:              userID,
 		Name:                req.Name,
 		Servingsizes:        req.ServingSizes,
@@ -32,13 +47,8 @@ func (a *API) CreateFood(w http.ResponseWriter, r *http.Request) {
 		Calcium:             req.Calcium,
 		Potassium:           req.Potassium,
 		Iron:                req.Iron,
+		Lastmodified:        pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
 	})
-	if err != nil {
-		respond(w, http.StatusInternalServerError, "Couldn't create food")
-		return
-	}
-
-	respond(w, http.StatusOK, map[string]any{"id": id})
 }
 
 func foodRowToJson(row database.Food) FoodJSON {
@@ -58,6 +68,20 @@ func foodRowToJson(row database.Food) FoodJSON {
 	}
 }
 
+// Get how much of the food was eaten in the meal, in the food's base unit.
+// The food's nutrients are per base unit, and each serving unit is a number
+// of base units. Meals with an unknown unit use the default serving.
+func servingAmount(meal database.Meal, food database.Food) float64 {
+	i := slices.Index(food.Servingunits, meal.Unit)
+	if i < 0 {
+		i = int(food.Defaultservingindex)
+	}
+	if i < 0 || i >= len(food.Servingsizes) {
+		return 0
+	}
+	return meal.Servings * food.Servingsizes[i]
+}
+
 func (a *API) SearchFood(w http.ResponseWriter, r *http.Request) {
 	userID, ok := parseJWT(a, w, r)
 	query, okQuery := getQuery[string](w, r, "query")
@@ -100,8 +124,23 @@ func (a *API) GetFood(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
+	a.respondWithFood(w, int32(foodID))
+}
 
-	row, err := a.queries.GetFoodByID(a.ctx, int32(foodID))
+func (a *API) FetchFood(w http.ResponseWriter, r *http.Request) {
+	foodID, ok := getPathValue[int64](w, r, "id")
+	if !ok {
+		return
+	}
+	a.respondWithFood(w, int32(foodID))
+}
+
+func (a *API) respondWithFood(w http.ResponseWriter, foodID int32) {
+	row, err := a.queries.GetFoodByID(a.ctx, foodID)
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Food not found")
+		return
+	}
 	if err != nil {
 		respond(w, http.StatusInternalServerError, "Failed to find food")
 		return
@@ -122,28 +161,27 @@ func (a *API) SetMeal(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if req.Updating {
-		if err := a.queries.UpdateMeal(a.ctx, database.UpdateMealParams{
-			Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
-			Mealtag:      req.MealTag,
-			Servings:     req.Servings,
-			Unit:         req.Unit,
-			ID:           req.ID,
-		}); err != nil {
+		patch := MealPatchJSON{
+			MealTag: &req.MealTag, Servings: &req.Servings,
+			Unit: &req.Unit, Version: req.Version,
+		}
+		meal, err := patchMeal(a.ctx, a.queries, userID, req.ID, patch)
+		if err == pgx.ErrNoRows {
+			respond(w, http.StatusNotFound, "Meal not found")
+			return
+		}
+		if respondConflict(w, err) {
+			return
+		}
+		if err != nil {
 			respond(w, http.StatusInternalServerError, "Couldn't update meal")
 			return
 		}
-		respond(w, http.StatusOK, nil)
+		respond(w, http.StatusOK, map[string]any{"meal": meal})
 		return
 	}
 
-	id, err := a.queries.CreateMeal(a.ctx, database.CreateMealParams{
-		Userid:   userID,
-		Foodid:   req.FoodID,
-		Date:     req.Date,
-		Mealtag:  req.MealTag,
-		Servings: req.Servings,
-		Unit:     req.Unit,
-	})
+	id, err := createMeal(a.ctx, a.queries, userID, req)
 	if err != nil {
 		respond(w, http.StatusInternalServerError, "Couldn't create meal")
 		return
@@ -151,28 +189,181 @@ func (a *API) SetMeal(w http.ResponseWriter, r *http.Request) {
 	respond(w, http.StatusOK, map[string]int32{"mealID": id})
 }
 
-func (a *API) DeleteMeal(w http.ResponseWriter, r *http.Request) {
-	mealID, ok := getQuery[int64](w, r, "mealID")
+func (a *API) CreateMeal(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	req, ok := parseRequest[MealJSON](w, r)
 	if !ok {
 		return
 	}
 
+	id, err := createMeal(a.ctx, a.queries, userID, req)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't create meal")
+		return
+	}
+	respond(w, http.StatusOK, map[string]int32{"mealID": id})
+}
+
+func createMeal(
+	ctx context.Context, q *database.Queries, userID int32, req MealJSON,
+) (int32, error) {
+	return q.CreateMeal(ctx, database.CreateMealParams{
+		Userid:       userID,
+		Foodid:       req.FoodID,
+		Date:         req.Date,
+		Mealtag:      req.MealTag,
+		Servings:     req.Servings,
+		Unit:         req.Unit,
+		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+	})
+}
+
+// Only update the fields that are set in the request
+func (a *API) PatchMeal(w http.ResponseWriter, r *http.Request) {
 	userID, ok := parseJWT(a, w, r)
 	if !ok {
 		return
 	}
+	mealID, ok := getPathValue[int64](w, r, "id")
+	if !ok {
+		return
+	}
+	req, ok := parseRequest[MealPatchJSON](w, r)
+	if !ok {
+		return
+	}
+
+	meal, err := patchMeal(a.ctx, a.queries, userID, int32(mealID), req)
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Meal not found")
+		return
+	}
+	if respondConflict(w, err) {
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't update meal")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{"meal": meal})
+}
 
-	if err := a.queries.DeleteMeal(a.ctx, database.DeleteMealParams{
+func patchMeal(
+	ctx context.Context, q *database.Queries,
+	userID int32, mealID int32, req MealPatchJSON,
+) (MealJSON, error) {
+	row, err := q.GetMeal(ctx, database.GetMealParams{ID: mealID, Userid: userID})
+	if err != nil {
+		return MealJSON{}, err
+	}
+	if req.Version != 0 && req.Version != row.Version {
+		return MealJSON{}, conflictError{mealRowToJson(row)}
+	}
+
+	if req.MealTag != nil {
+		row.Mealtag = *req.MealTag
+	}
+	if req.Servings != nil {
+		row.Servings = *req.Servings
+	}
+	if req.Unit != nil {
+		row.Unit = *req.Unit
+	}
+
+	row.Version, err = q.UpdateMeal(ctx, database.UpdateMealParams{
 		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+		Mealtag:      row.Mealtag,
+		Servings:     row.Servings,
+		Unit:         row.Unit,
+		ID:           row.ID,
 		Userid:       userID,
-		ID:           int32(mealID),
-	}); err != nil {
+		BaseVersion:  row.Version,
+	})
+	if err == pgx.ErrNoRows {
+		// the meal was changed after it was read
+		current, err := q.GetMeal(ctx, database.GetMealParams{ID: mealID, Userid: userID})
+		if err != nil {
+			return MealJSON{}, err
+		}
+		return MealJSON{}, conflictError{mealRowToJson(current)}
+	}
+	return mealRowToJson(row), err
+}
+
+func (a *API) DeleteMeal(w http.ResponseWriter, r *http.Request) {
+	mealID, ok := getQuery[int64](w, r, "mealID")
+	if !ok {
+		return
+	}
+	version, ok := getOptionalQuery[int64](w, r, "version", 0)
+	if !ok {
+		return
+	}
+
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	a.deleteMeal(w, userID, int32(mealID), version)
+}
+
+func (a *API) RemoveMeal(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	mealID, ok := getPathValue[int64](w, r, "id")
+	if !ok {
+		return
+	}
+	version, ok := getOptionalQuery[int64](w, r, "version", 0)
+	if !ok {
+		return
+	}
+	a.deleteMeal(w, userID, int32(mealID), version)
+}
+
+func (a *API) deleteMeal(w http.ResponseWriter, userID int32, mealID int32, version int64) {
+	err := deleteMealTx(a.ctx, a.queries, userID, mealID, version)
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Meal not found")
+		return
+	}
+	if respondConflict(w, err) {
+		return
+	}
+	if err != nil {
 		respond(w, http.StatusInternalServerError, "Couldn't delete meal")
 		return
 	}
 	respond(w, http.StatusOK, nil)
 }
 
+// Soft delete the meal, unless it changed since the version the delete is
+// based on. Returns pgx.ErrNoRows when the user doesn't have the meal.
+// Can be called in a transaction.
+func deleteMealTx(
+	ctx context.Context, q *database.Queries, userID int32, mealID int32, version int64,
+) error {
+	deleted, err := q.DeleteMeal(ctx, database.DeleteMealParams{
+		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+		Userid:       userID,
+		ID:           mealID,
+		BaseVersion:  baseVersion(version),
+	})
+	if err != nil || deleted > 0 {
+		return err
+	}
+	current, err := q.GetMeal(ctx, database.GetMealParams{ID: mealID, Userid: userID})
+	if err != nil {
+		return err
+	}
+	return conflictError{mealRowToJson(current)}
+}
+
 func (a *API) GetMeals(w http.ResponseWriter, r *http.Request) {
 	date, ok := getQuery[int64](w, r, "dateTimestamp")
 	if !ok {
@@ -193,15 +384,51 @@ func (a *API) GetMeals(w http.ResponseWriter, r *http.Request) {
 
 	meals := []MealJSON{}
 	for _, row := range rows {
-		meals = append(meals, MealJSON{
-			ID:       row.ID,
-			Date:     row.Date,
-			FoodID:   row.Foodid,
-			MealTag:  row.Mealtag,
-			Servings: row.Servings,
-			Unit:     row.Unit,
-		})
+		meals = append(meals, mealRowToJson(row))
+	}
+
+	respond(w, http.StatusOK, map[string]any{"meals": meals})
+}
+
+// Get the meals logged between two dates, inclusive
+func (a *API) ListMeals(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	from, ok := getQuery[int64](w, r, "from")
+	if !ok {
+		return
+	}
+	to, ok := getQuery[int64](w, r, "to")
+	if !ok {
+		return
+	}
+
+	rows, err := a.queries.GetMealsInRange(a.ctx, database.GetMealsInRangeParams{
+		Userid: userID, FromDate: from, ToDate: to})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get meals")
+		return
+	}
+
+	meals := []MealJSON{}
+	for _, row := range rows {
+		meals = append(meals, mealRowToJson(row))
 	}
 
 	respond(w, http.StatusOK, map[string]any{"meals": meals})
 }
+
+func mealRowToJson(row database.Meal) MealJSON {
+	return MealJSON{
+		Deleted:  row.Deleted,
+		ID:       row.ID,
+		Date:     row.Date,
+		FoodID:   row.Foodid,
+		MealTag:  row.Mealtag,
+		Servings: row.Servings,
+		Unit:     row.Unit,
+		Version:  row.Version,
+	}
+}
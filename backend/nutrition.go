@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
+)
+
+// The nutrition summaries total the nutrients of the meals eaten on each
+// day, so the client doesn't have to get every food to add them up. The
+// nutrients of a meal are its food's nutrients, which are per base unit,
+// times the base units in the servings that were eaten.
+
+// Get the foods of the meals by id
+func mealFoods(
+	ctx context.Context, q *database.Queries, meals []database.Meal,
+) (map[int32]database.Food, error) {
+	ids := []int32{}
+	for _, meal := range meals {
+		if !slices.Contains(ids, meal.Foodid) {
+			ids = append(ids, meal.Foodid)
+		}
+	}
+	foods := map[int32]database.Food{}
+	if len(ids) == 0 {
+		return foods, nil
+	}
+	rows, err := q.GetFoodsByID(ctx, ids)
+	for _, row := range rows {
+		foods[row.ID] = row
+	}
+	return foods, err
+}
+
+// The nutrients in a base unit of the food
+func foodNutrients(food database.Food) NutrientsJSON {
+	return NutrientsJSON{
+		Calories: food.Calories, Carbohydrate: food.Carbohydrate, Protein: food.Protein,
+		Fat: food.Fat, Calcium: food.Calcium, Potassium: food.Potassium, Iron: food.Iron,
+	}
+}
+
+func (n NutrientsJSON) plus(o NutrientsJSON) NutrientsJSON {
+	return NutrientsJSON{
+		Calories: n.Calories + o.Calories, Carbohydrate: n.Carbohydrate + o.Carbohydrate,
+		Protein: n.Protein + o.Protein, Fat: n.Fat + o.Fat, Calcium: n.Calcium + o.Calcium,
+		Potassium: n.Potassium + o.Potassium, Iron: n.Iron + o.Iron,
+	}
+}
+
+func (n NutrientsJSON) scale(x float64) NutrientsJSON {
+	return NutrientsJSON{
+		Calories: n.Calories * x, Carbohydrate: n.Carbohydrate * x, Protein: n.Protein * x,
+		Fat: n.Fat * x, Calcium: n.Calcium * x, Potassium: n.Potassium * x, Iron: n.Iron * x,
+	}
+}
+
+// Get the amount of a nutrient by its json name
+func (n NutrientsJSON) get(name string) (float64, bool) {
+	amount, ok := map[string]float64{
+		"calories": n.Calories, "carbohydrate": n.Carbohydrate, "protein": n.Protein,
+		"fat": n.Fat, "calcium": n.Calcium, "potassium": n.Potassium, "iron": n.Iron,
+	}[name]
+	return amount, ok
+}
+
+// Compare the day's total against the targets of the nutrients.
+// Targets of 0 haven't been set.
+func (d *NutritionDayJSON) compare(targets map[string]int) {
+	d.Targets = map[string]TargetJSON{}
+	for name, target := range targets {
+		eaten, ok := d.Total.get(name)
+		if !ok || target <= 0 {
+			continue
+		}
+		d.Targets[name] = TargetJSON{
+			Target: float64(target), Eaten: eaten, Remaining: float64(target) - eaten,
+			Percent: eaten / float64(target) * 100,
+		}
+	}
+}
+
+// Get the user's macro targets by nutrient
+func (a *API) macroTargets(userID int32) (map[string]int, error) {
+	targets := map[string]int{}
+	settings, err := a.queries.GetUserSettings(a.ctx, userID)
+	if err == pgx.ErrNoRows {
+		return targets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(settings.Macrotargets, &targets)
+	return targets, err
+}
+
+// Total the nutrients of the user's meals between two dates, inclusive,
+// by day, in order. Days without meals are left out.
+func (a *API) nutritionDays(
+	userID int32, from int64, to int64, targets map[string]int,
+) ([]NutritionDayJSON, error) {
+	meals, err := a.queries.GetMealsInRange(a.ctx, database.GetMealsInRangeParams{
+		Userid: userID, FromDate: from, ToDate: to,
+	})
+	if err != nil {
+		return nil, err
+	}
+	foods, err := mealFoods(a.ctx, a.queries, meals)
+	if err != nil {
+		return nil, err
+	}
+
+	days := []NutritionDayJSON{}
+	for _, meal := range meals { // in date order
+		if len(days) == 0 || days[len(days)-1].Date != meal.Date {
+			days = append(days, NutritionDayJSON{Date: meal.Date, Meals: map[string]NutrientsJSON{}})
+		}
+		day := &days[len(days)-1]
+		food := foods[meal.Foodid]
+		eaten := foodNutrients(food).scale(servingAmount(meal, food))
+		day.Total = day.Total.plus(eaten)
+		day.Meals[meal.Mealtag] = day.Meals[meal.Mealtag].plus(eaten)
+	}
+	for i := range days {
+		days[i].compare(targets)
+	}
+	return days, nil
+}
+
+// Get the nutrients eaten on the day
+func (a *API) GetNutritionDay(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	date, ok := getQuery[int64](w, r, "date")
+	if !ok {
+		return
+	}
+
+	targets, err := a.macroTargets(userID)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get nutrition")
+		return
+	}
+	days, err := a.nutritionDays(userID, date, date, targets)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get nutrition")
+		return
+	}
+
+	day := NutritionDayJSON{Date: date, Meals: map[string]NutrientsJSON{}}
+	if len(days) > 0 {
+		day = days[0]
+	} else {
+		day.compare(targets) // nothing's been eaten yet
+	}
+	respond(w, http.StatusOK, map[string]any{"day": day})
+}
+
+// Get the nutrients eaten on each day between two dates, inclusive,
+// with their total and daily average over the days with meals
+func (a *API) GetNutritionRange(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	from, ok := getQuery[int64](w, r, "from")
+	if !ok {
+		return
+	}
+	to, ok := getQuery[int64](w, r, "to")
+	if !ok {
+		return
+	}
+	if from > to {
+		respond(w, http.StatusBadRequest, "The range must start before it ends")
+		return
+	}
+
+	targets, err := a.macroTargets(userID)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get nutrition")
+		return
+	}
+	days, err := a.nutritionDays(userID, from, to, targets)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get nutrition")
+		return
+	}
+
+	var total, average NutrientsJSON
+	for _, day := range days {
+		total = total.plus(day.Total)
+	}
+	if len(days) > 0 {
+		average = total.scale(1 / float64(len(days)))
+	}
+	respond(w, http.StatusOK, map[string]any{"days": days, "total": total, "average": average})
+}
@@ -0,0 +1,17 @@
+package main
+
+import "net/http"
+
+// Serve the openapi document describing the api
+func (a *API) OpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(a.spec)
+}
+
+// Serve a page that renders the openapi document in the browser
+func (a *API) APIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(a.docs)
+}
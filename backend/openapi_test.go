@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type specSchema struct {
+	Ref        string                `json:"$ref"`
+	Type       any                   `json:"type"`
+	Items      *specSchema           `json:"items"`
+	Properties map[string]specSchema `json:"properties"`
+}
+
+type openAPIDocument struct {
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]specSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadSpec(t *testing.T) openAPIDocument {
+	t.Helper()
+	data, err := os.ReadFile("docs/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc openAPIDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("docs/openapi.json isn't valid: %v", err)
+	}
+	return doc
+}
+
+var httpMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+func TestSpecCoversRoutes(t *testing.T) {
+	doc := loadSpec(t)
+	api := &API{}
+
+	// the document describes the latest version, whose prefix is the
+	// document's server url. unversioned routes override the server url.
+	versions := api.versions()
+	latest := versions[len(versions)-1]
+	routes := append(latest.routes, api.unversionedRoutes()...)
+
+	registered := map[string]bool{}
+	for i, route := range routes {
+		unversioned := i >= len(latest.routes)
+		method, path, _ := strings.Cut(route.pattern, " ")
+		method = strings.ToLower(method)
+		registered[method+" "+path] = true
+
+		item, ok := doc.Paths[path]
+		if _, documented := item[method]; !ok || !documented {
+			t.Errorf("%s is registered but isn't in the spec", route.pattern)
+			continue
+		}
+		if _, overridden := item["servers"]; overridden != unversioned {
+			t.Errorf("%s should only override the server url if it's unversioned", path)
+		}
+	}
+
+	for path, item := range doc.Paths {
+		for _, method := range httpMethods {
+			if _, ok := item[method]; ok && !registered[method+" "+path] {
+				t.Errorf("%s %s is in the spec but isn't registered", method, path)
+			}
+		}
+	}
+
+	if doc.Servers[0].URL != latest.prefix {
+		t.Errorf("the spec's server url should be %s", latest.prefix)
+	}
+}
+
+// The openapi type a field of the given go type should be documented as
+func specType(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return specType(e.X)
+	case *ast.ArrayType:
+		return "array"
+	case *ast.MapType:
+		return "object"
+	case *ast.SelectorExpr:
+		return "" // types from other packages, anything goes
+	case *ast.Ident:
+		switch e.Name {
+		case "string":
+			return "string"
+		case "bool":
+			return "boolean"
+		case "int", "int32", "int64":
+			return "integer"
+		case "float32", "float64":
+			return "number"
+		}
+		return "#/components/schemas/" + e.Name
+	}
+	return ""
+}
+
+// Check that the schema documents a field of the given go type
+func schemaMatches(schema specSchema, expr ast.Expr) bool {
+	expected := specType(expr)
+	if expected == "" {
+		return true
+	}
+	if strings.HasPrefix(expected, "#") {
+		return schema.Ref == expected
+	}
+
+	// the type can be a single type or a list of types
+	matches := false
+	switch typ := schema.Type.(type) {
+	case string:
+		matches = typ == expected
+	case []any:
+		for _, t := range typ {
+			matches = matches || t == expected
+		}
+	}
+
+	if array, ok := expr.(*ast.ArrayType); ok && matches {
+		return schema.Items != nil && schemaMatches(*schema.Items, array.Elt)
+	}
+	return matches
+}
+
+func TestSpecCoversDataTypes(t *testing.T) {
+	doc := loadSpec(t)
+
+	file, err := parser.ParseFile(token.NewFileSet(), "data.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, s := range gen.Specs {
+			spec := s.(*ast.TypeSpec)
+			structType, ok := spec.Type.(*ast.StructType)
+			if !ok {
+				continue
+			}
+
+			name := spec.Name.Name
+			schema, ok := doc.Components.Schemas[name]
+			if !ok {
+				t.Errorf("%s isn't in the spec's schemas", name)
+				continue
+			}
+
+			fields := map[string]bool{}
+			for _, field := range structType.Fields.List {
+				if field.Tag == nil {
+					continue
+				}
+				tag := reflect.StructTag(strings.Trim(field.Tag.Value, "`")).Get("json")
+				jsonName, _, _ := strings.Cut(tag, ",")
+				if jsonName == "" || jsonName == "-" {
+					continue
+				}
+				fields[jsonName] = true
+
+				property, ok := schema.Properties[jsonName]
+				if !ok {
+					t.Errorf("%s.%s isn't in the spec", name, jsonName)
+					continue
+				}
+				if !schemaMatches(property, field.Type) {
+					t.Errorf("%s.%s doesn't match its go type", name, jsonName)
+				}
+			}
+
+			for property := range schema.Properties {
+				if !fields[property] {
+					t.Errorf("%s.%s is in the spec but isn't a field", name, property)
+				}
+			}
+		}
+	}
+}
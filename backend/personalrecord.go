@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
+)
+
+// Personal records are found by comparing the sets of an exercise in each
+// workout to its sets in the workouts before it. Exercises are the same when
+// they have the same catalog id, or the same name when they aren't in the
+// catalog. Warmup sets don't count. Since a workout can beat or stop beating
+// the records of the workouts after it, whenever a workout is created,
+// changed or deleted, the records of its exercises are found again from
+// scratch from its date on.
+
+const kgPerLb = 0.45359237
+
+func toKg(weight float64, unit string) float64 {
+	if unit == "lbs" {
+		return weight * kgPerLb
+	}
+	return weight
+}
+
+// Estimate the one rep max of a set with the Epley formula
+func epley(weight float64, reps int32) float64 {
+	if reps <= 1 {
+		return weight
+	}
+	return weight * (1 + float64(reps)/30)
+}
+
+// Estimate the one rep max of a set with the Brzycki formula,
+// which doesn't work for sets of 37 reps or more
+func brzycki(weight float64, reps int32) float64 {
+	if reps <= 1 {
+		return weight
+	}
+	if reps >= 37 {
+		return 0
+	}
+	return weight * 36 / (37 - float64(reps))
+}
+
+// The set with the best value of a kind of record
+type bestSet struct {
+	value      float64
+	weight     float64 // in kg
+	reps       int32
+	exerciseID int32
+}
+
+type personalBests struct {
+	kinds  map[string]bestSet
+	repsAt map[float64]bestSet // most reps by weight, rounded to 10 grams
+}
+
+func newPersonalBests() personalBests {
+	return personalBests{kinds: map[string]bestSet{}, repsAt: map[float64]bestSet{}}
+}
+
+func (b personalBests) add(kind string, set bestSet) {
+	if set.value > b.kinds[kind].value {
+		b.kinds[kind] = set
+	}
+}
+
+// Count a set, with its weight already in kg
+func (b personalBests) addSet(weight float64, reps int32, exerciseID int32) {
+	if reps <= 0 {
+		return
+	}
+	set := bestSet{weight: weight, reps: reps, exerciseID: exerciseID}
+	b.add("weight", bestSet{weight, weight, reps, exerciseID})
+	b.add("e1rm-epley", bestSet{epley(weight, reps), weight, reps, exerciseID})
+	b.add("e1rm-brzycki", bestSet{brzycki(weight, reps), weight, reps, exerciseID})
+
+	rounded := math.Round(weight*100) / 100
+	set.value = float64(reps)
+	if set.value > b.repsAt[rounded].value {
+		b.repsAt[rounded] = set
+	}
+}
+
+// Keep the best of the values of the sets counted in both
+func (b personalBests) merge(o personalBests) {
+	for kind, set := range o.kinds {
+		b.add(kind, set)
+	}
+	for weight, set := range o.repsAt {
+		if set.value > b.repsAt[weight].value {
+			b.repsAt[weight] = set
+		}
+	}
+}
+
+// An exercise that personal records are kept for
+type recordExercise struct {
+	catalogID int32
+	name      string
+}
+
+// The different exercises among the exercises
+func recordExercises(exercises []ExerciseJSON) []recordExercise {
+	found := []recordExercise{}
+	seen := map[string]bool{}
+	for _, e := range exercises {
+		name := strings.TrimSpace(e.Name)
+		key := fmt.Sprintf("name:%s", strings.ToLower(name))
+		if e.CatalogID != 0 {
+			key = fmt.Sprintf("catalog:%d", e.CatalogID)
+		}
+		if !seen[key] {
+			seen[key] = true
+			found = append(found, recordExercise{catalogID: e.CatalogID, name: name})
+		}
+	}
+	return found
+}
+
+// Find the personal records of the exercises in the workouts again, from the
+// earliest of their dates on, after the workouts were created, changed or
+// deleted. A changed workout should be passed as it was before the change and
+// as it is after, so the exercises it no longer has are included. Templates
+// are ignored. Should be called in a transaction.
+func updateRecords(
+	ctx context.Context, q *database.Queries, userID int32, workouts ...WorkoutJSON,
+) error {
+	exercises := []ExerciseJSON{}
+	from := int64(math.MaxInt64)
+	for _, workout := range workouts {
+		if !workout.IsTemplate {
+			exercises = append(exercises, workout.Exercises...)
+			from = min(from, workout.Date)
+		}
+	}
+	for _, exercise := range recordExercises(exercises) {
+		if err := findRecords(ctx, q, userID, exercise, from); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replace the personal records of the exercise set on or after the date
+// with the ones found by going through the exercise's history in order.
+// Should be called in a transaction.
+func findRecords(
+	ctx context.Context, q *database.Queries,
+	userID int32, exercise recordExercise, from int64,
+) error {
+	if err := q.DeleteExerciseRecords(ctx, database.DeleteExerciseRecordsParams{
+		Userid: userID, Catalogid: exercise.catalogID, Name: exercise.name, Date: from,
+	}); err != nil {
+		return err
+	}
+	rows, err := q.GetExerciseHistory(ctx, database.GetExerciseHistoryParams{
+		Userid: userID, Catalogid: exercise.catalogID, Name: exercise.name,
+	})
+	if err != nil {
+		return err
+	}
+
+	params := []database.CreatePersonalRecordsParams{}
+	previous := newPersonalBests()
+	for start := 0; start < len(rows); {
+		// the rows of each workout are together
+		end := start
+		for end < len(rows) && rows[end].Workoutid == rows[start].Workoutid {
+			end++
+		}
+		workout := rows[start:end]
+		start = end
+
+		current := newPersonalBests()
+		for _, row := range workout {
+			if row.Exercisetype == "cardio" {
+				e := withCardioStats(ExerciseJSON{
+					ExerciseType: row.Exercisetype, Duration: row.Duration, Distance: row.Distance,
+				})
+				current.add("duration", bestSet{value: e.Duration, exerciseID: row.Exerciseid})
+				current.add("distance", bestSet{value: e.Distance, exerciseID: row.Exerciseid})
+				current.add("speed", bestSet{value: e.Speed, exerciseID: row.Exerciseid})
+				continue
+			}
+			current.addSet(toKg(row.Weight, row.Weightunit), row.Reps, row.Exerciseid)
+		}
+
+		newRecord := func(kind string, set bestSet, beaten float64) {
+			params = append(params, database.CreatePersonalRecordsParams{
+				Userid:     userID,
+				Workoutid:  workout[0].Workoutid,
+				Exerciseid: set.exerciseID,
+				Catalogid:  exercise.catalogID,
+				Name:       exercise.name,
+				Kind:       kind,
+				Value:      set.value,
+				Previous:   beaten,
+				Weight:     set.weight,
+				Reps:       set.reps,
+				Date:       workout[0].Date,
+			})
+		}
+		if workout[0].Date >= from {
+			for _, kind := range []string{
+				"weight", "e1rm-epley", "e1rm-brzycki", "duration", "distance", "speed",
+			} {
+				best, ok := current.kinds[kind]
+				if ok && best.value > previous.kinds[kind].value {
+					newRecord(kind, best, previous.kinds[kind].value)
+				}
+			}
+			// more reps is only a record when the weight was lifted before
+			for weight, best := range current.repsAt {
+				beaten, ok := previous.repsAt[weight]
+				if ok && best.value > beaten.value {
+					newRecord("reps", best, beaten.value)
+				}
+			}
+		}
+		previous.merge(current)
+	}
+
+	q.CreatePersonalRecords(ctx, params).Exec(func(i int, e error) {
+		if e != nil {
+			err = e
+		}
+	})
+	return err
+}
+
+// Find every personal record again. The records found before changed
+// workouts updated the records after them can be out of date.
+// Should be called in a transaction.
+func backfillRecords(ctx context.Context, q *database.Queries) error {
+	if err := q.ClearPersonalRecords(ctx); err != nil {
+		return err
+	}
+	rows, err := q.GetRecordExercises(ctx)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		exercise := recordExercise{catalogID: row.Catalogid, name: row.Name}
+		if err := findRecords(ctx, q, row.Userid, exercise, math.MinInt64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func personalRecordRowToJson(row database.Personalrecord) PersonalRecordJSON {
+	return PersonalRecordJSON{
+		ID:         row.ID,
+		WorkoutID:  row.Workoutid,
+		ExerciseID: row.Exerciseid,
+		CatalogID:  row.Catalogid,
+		Name:       row.Name,
+		Kind:       row.Kind,
+		Value:      row.Value,
+		Previous:   row.Previous,
+		Weight:     row.Weight,
+		Reps:       row.Reps,
+		Date:       row.Date,
+	}
+}
+
+// Get the history of the personal records of an exercise, newest first, so
+// the first record of each kind is the current one. The name can be the name
+// or an alias of a catalog exercise, or the name of an exercise that isn't
+// in the catalog.
+func (a *API) GetExerciseRecords(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	name, ok := getPathValue[string](w, r, "name")
+	if !ok {
+		return
+	}
+
+	catalogID, err := a.queries.FindCatalogExercise(a.ctx, database.FindCatalogExerciseParams{
+		Userid: userID, Name: name,
+	})
+	if err != nil && err != pgx.ErrNoRows {
+		respond(w, http.StatusInternalServerError, "Couldn't get records")
+		return
+	}
+
+	rows, err := a.queries.GetExerciseRecords(a.ctx, database.GetExerciseRecordsParams{
+		Userid: userID, Catalogid: catalogID, Name: name,
+	})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get records")
+		return
+	}
+
+	records := []PersonalRecordJSON{}
+	for _, row := range rows {
+		records = append(records, personalRecordRowToJson(row))
+	}
+	respond(w, http.StatusOK, map[string]any{"records": records})
+}
+
+// Get the personal records the user set most recently, across every
+// exercise. Older records are paged through with the id of the last record.
+func (a *API) GetRecordFeed(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	before, okBefore := getOptionalQuery[int64](w, r, "before", math.MaxInt32)
+	limit, okLimit := getOptionalQuery[int64](w, r, "limit", 50)
+	if !okBefore || !okLimit {
+		return
+	}
+
+	rows, err := a.queries.GetRecordFeed(a.ctx, database.GetRecordFeedParams{
+		Userid: userID,
+		ID:     int32(min(before, math.MaxInt32)),
+		Limit:  int32(min(max(limit, 1), 200)),
+	})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get records")
+		return
+	}
+
+	records := []PersonalRecordJSON{}
+	for _, row := range rows {
+		records = append(records, personalRecordRowToJson(row))
+	}
+	respond(w, http.StatusOK, map[string]any{"records": records})
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
+)
+
+// Programs schedule the user's templates across weeks, like a push pull
+// legs split or 5/3/1. Users enroll in one program at a time from a start
+// date, then start the workouts of the program's days as they're due, or
+// skip them. Dates are local midnights, so the client sends today's date.
+
+const dayMs = 24 * 60 * 60 * 1000
+
+func (a *API) CreateProgram(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	req, ok := parseRequest[ProgramJSON](w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := a.conn.Begin(a.ctx)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't create program")
+		return
+	}
+	defer tx.Rollback(a.ctx)
+
+	program, err := createProgram(a.ctx, a.queries.WithTx(tx), userID, req)
+	var message mutationError
+	if errors.As(err, &message) {
+		respond(w, http.StatusBadRequest, message.Error())
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't create program")
+		return
+	}
+
+	if err := tx.Commit(a.ctx); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't create program")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{"program": program})
+}
+
+// Create the program and its days. Every day must be one of the user's
+// templates, in one of the program's weeks. Should be called in a transaction.
+func createProgram(
+	ctx context.Context, q *database.Queries, userID int32, req ProgramJSON,
+) (ProgramJSON, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if len(req.Name) == 0 {
+		return ProgramJSON{}, mutationError("missing name")
+	}
+	if req.Weeks < 1 || req.Weeks > 52 {
+		return ProgramJSON{}, mutationError("a program must be 1 to 52 weeks long")
+	}
+	if len(req.Days) == 0 {
+		return ProgramJSON{}, mutationError("a program must have days")
+	}
+
+	params := []database.CreateProgramDaysParams{}
+	for _, day := range req.Days {
+		if day.Week < 0 || day.Week >= req.Weeks || day.Day < 0 || day.Day > 6 {
+			return ProgramJSON{}, mutationError(fmt.Sprintf(
+				"week %d, day %d isn't in the program", day.Week, day.Day))
+		}
+		template, err := q.GetWorkout(ctx, database.GetWorkoutParams{
+			ID: day.TemplateID, Userid: userID,
+		})
+		if err == pgx.ErrNoRows || (err == nil && (!template.Istemplate || template.Deleted)) {
+			return ProgramJSON{}, mutationError(fmt.Sprintf("template %d doesn't exist", day.TemplateID))
+		}
+		if err != nil {
+			return ProgramJSON{}, err
+		}
+		params = append(params, database.CreateProgramDaysParams{
+			Userid: userID, Week: day.Week, Day: day.Day, Templateid: day.TemplateID,
+		})
+	}
+
+	id, err := q.CreateProgram(ctx, database.CreateProgramParams{
+		Userid: userID, Name: req.Name, Description: req.Description, Weeks: req.Weeks,
+	})
+	if err != nil {
+		return ProgramJSON{}, err
+	}
+	for i := range params {
+		params[i].Programid = id
+	}
+	q.CreateProgramDays(ctx, params).Exec(func(i int, e error) {
+		if e != nil {
+			err = e
+		}
+	})
+	if err != nil {
+		return ProgramJSON{}, err
+	}
+
+	row, err := q.GetProgram(ctx, database.GetProgramParams{ID: id, Userid: userID})
+	if err != nil {
+		return ProgramJSON{}, err
+	}
+	return getProgram(ctx, q, row)
+}
+
+func getProgram(ctx context.Context, q *database.Queries, row database.Program) (ProgramJSON, error) {
+	rows, err := q.GetProgramDays(ctx, database.GetProgramDaysParams{
+		Programid: row.ID, Userid: row.Userid,
+	})
+	if err != nil {
+		return ProgramJSON{}, err
+	}
+
+	program := ProgramJSON{
+		ID: row.ID, Name: row.Name, Description: row.Description,
+		Weeks: row.Weeks, Days: []ProgramDayJSON{},
+	}
+	for _, day := range rows {
+		program.Days = append(program.Days, ProgramDayJSON{
+			ID: day.ID, Week: day.Week, Day: day.Day,
+			TemplateID: day.Templateid, TemplateName: day.Templatename,
+		})
+	}
+	return program, nil
+}
+
+func (a *API) ListPrograms(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := a.queries.GetPrograms(a.ctx, userID)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get programs")
+		return
+	}
+	programs := []ProgramJSON{}
+	for _, row := range rows {
+		program, err := getProgram(a.ctx, a.queries, row)
+		if err != nil {
+			respond(w, http.StatusInternalServerError, "Couldn't get programs")
+			return
+		}
+		programs = append(programs, program)
+	}
+	respond(w, http.StatusOK, map[string]any{"programs": programs})
+}
+
+func (a *API) FetchProgram(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	programID, ok := getPathValue[int64](w, r, "id")
+	if !ok {
+		return
+	}
+
+	row, err := a.queries.GetProgram(a.ctx, database.GetProgramParams{
+		ID: int32(programID), Userid: userID,
+	})
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Program not found")
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get program")
+		return
+	}
+	program, err := getProgram(a.ctx, a.queries, row)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get program")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{"program": program})
+}
+
+// Delete the program, ending the enrollment in it. The templates are kept.
+func (a *API) RemoveProgram(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	programID, ok := getPathValue[int64](w, r, "id")
+	if !ok {
+		return
+	}
+
+	tx, err := a.conn.Begin(a.ctx)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't delete program")
+		return
+	}
+	defer tx.Rollback(a.ctx)
+	qtx := a.queries.WithTx(tx)
+
+	deleted, err := qtx.DeleteProgram(a.ctx, database.DeleteProgramParams{
+		ID: int32(programID), Userid: userID,
+	})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't delete program")
+		return
+	}
+	if deleted == 0 {
+		respond(w, http.StatusNotFound, "Program not found")
+		return
+	}
+	if err := qtx.DeleteProgramDays(a.ctx, database.DeleteProgramDaysParams{
+		Programid: int32(programID), Userid: userID,
+	}); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't delete program")
+		return
+	}
+	if err := qtx.EndEnrollments(a.ctx, database.EndEnrollmentsParams{
+		Userid: userID, Programid: int32(programID),
+	}); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't delete program")
+		return
+	}
+
+	if err := tx.Commit(a.ctx); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't delete program")
+		return
+	}
+	respond(w, http.StatusOK, nil)
+}
+
+// Enroll in the program, ending the enrollment in any other program
+func (a *API) EnrollInProgram(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	programID, ok := getPathValue[int64](w, r, "id")
+	if !ok {
+		return
+	}
+	req, ok := parseRequest[EnrollmentJSON](w, r)
+	if !ok {
+		return
+	}
+	if len(req.Mode) == 0 {
+		req.Mode = "calendar"
+	}
+	if req.Mode != "calendar" && req.Mode != "sequential" {
+		respond(w, http.StatusBadRequest, "bad request: mode must be calendar or sequential")
+		return
+	}
+
+	tx, err := a.conn.Begin(a.ctx)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't enroll in program")
+		return
+	}
+	defer tx.Rollback(a.ctx)
+	qtx := a.queries.WithTx(tx)
+
+	if _, err := qtx.GetProgram(a.ctx, database.GetProgramParams{
+		ID: int32(programID), Userid: userID,
+	}); err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Program not found")
+		return
+	} else if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't enroll in program")
+		return
+	}
+
+	if err := qtx.EndEnrollments(a.ctx, database.EndEnrollmentsParams{Userid: userID}); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't enroll in program")
+		return
+	}
+	row, err := qtx.CreateEnrollment(a.ctx, database.CreateEnrollmentParams{
+		Userid: userID, Programid: int32(programID), Startdate: req.StartDate, Mode: req.Mode,
+	})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't enroll in program")
+		return
+	}
+
+	if err := tx.Commit(a.ctx); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't enroll in program")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{"enrollment": enrollmentRowToJson(row)})
+}
+
+func (a *API) LeaveProgram(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	if err := a.queries.EndEnrollments(a.ctx, database.EndEnrollmentsParams{
+		Userid: userID,
+	}); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't leave program")
+		return
+	}
+	respond(w, http.StatusOK, nil)
+}
+
+func enrollmentRowToJson(row database.Enrollment) EnrollmentJSON {
+	return EnrollmentJSON{
+		ID: row.ID, ProgramID: row.Programid, StartDate: row.Startdate, Mode: row.Mode,
+	}
+}
+
+// The days of the program the user is enrolled in, in order
+type schedule struct {
+	enrollment database.Enrollment
+	program    ProgramJSON
+	days       []ScheduledDayJSON
+}
+
+// Schedule the days of the program the user is enrolled in, as of today.
+// In calendar mode the days are on fixed dates and the days before today
+// that weren't done are missed. In sequential mode the pending days are
+// pushed back so none of them are before today, keeping the rest days
+// between them.
+func getSchedule(
+	ctx context.Context, q *database.Queries, userID int32, today int64,
+) (schedule, error) {
+	enrollment, err := q.GetActiveEnrollment(ctx, userID)
+	if err != nil {
+		return schedule{}, err
+	}
+	row, err := q.GetProgram(ctx, database.GetProgramParams{
+		ID: enrollment.Programid, Userid: userID,
+	})
+	if err != nil {
+		return schedule{}, err
+	}
+	program, err := getProgram(ctx, q, row)
+	if err != nil {
+		return schedule{}, err
+	}
+	logRows, err := q.GetProgramLogs(ctx, database.GetProgramLogsParams{
+		Enrollmentid: enrollment.ID, Userid: userID,
+	})
+	if err != nil {
+		return schedule{}, err
+	}
+	logs := map[int32]database.Programlog{} // by day id
+	for _, log := range logRows {
+		logs[log.Dayid] = log
+	}
+
+	result := schedule{enrollment: enrollment, program: program}
+	shift := int64(math.MinInt64) // the days pending days are shifted by
+	// the offset and date of the last day that was done or skipped
+	lastOffset, lastDate := int64(-1), int64(0)
+	for _, day := range program.Days {
+		offset := int64(day.Week*7 + day.Day)
+		scheduled := ScheduledDayJSON{
+			ID: day.ID, Week: day.Week, Day: day.Day,
+			TemplateID: day.TemplateID, TemplateName: day.TemplateName,
+			Date:   enrollment.Startdate + offset*dayMs,
+			Status: "pending",
+		}
+
+		if log, ok := logs[day.ID]; ok {
+			scheduled.Date = log.Date
+			scheduled.WorkoutID = log.Workoutid
+			scheduled.Status = "done"
+			if log.Workoutid == 0 {
+				scheduled.Status = "skipped"
+			}
+			lastOffset, lastDate = offset, log.Date
+		} else if enrollment.Mode == "sequential" {
+			if shift == math.MinInt64 {
+				// not before today, and not closer to the last day
+				// that was done than the program has it
+				earliest := max(offset, daysBetween(enrollment.Startdate, today))
+				if lastOffset >= 0 {
+					earliest = max(earliest,
+						daysBetween(enrollment.Startdate, lastDate)+offset-lastOffset)
+				}
+				shift = earliest - offset
+			}
+			scheduled.Date = enrollment.Startdate + (offset+shift)*dayMs
+		} else if daysBetween(scheduled.Date, today) > 0 {
+			scheduled.Status = "missed"
+		}
+		result.days = append(result.days, scheduled)
+	}
+	return result, nil
+}
+
+// The whole days from one local midnight to another. Days aren't
+// always 24 hours long because of daylight saving time, so it's rounded.
+func daysBetween(from int64, to int64) int64 {
+	return int64(math.Round(float64(to-from) / dayMs))
+}
+
+// Get the day that's due today, preferring the ones that haven't been
+// done. Nil when today is a rest day.
+func (s schedule) today(today int64) *ScheduledDayJSON {
+	var due *ScheduledDayJSON
+	for i, day := range s.days {
+		if daysBetween(day.Date, today) != 0 {
+			continue
+		}
+		if day.Status == "pending" {
+			return &s.days[i]
+		}
+		if due == nil {
+			due = &s.days[i]
+		}
+	}
+	return due
+}
+
+// Get the first pending day after today, nil when there isn't one
+func (s schedule) next(today int64) *ScheduledDayJSON {
+	for i, day := range s.days {
+		if day.Status == "pending" && daysBetween(today, day.Date) > 0 {
+			return &s.days[i]
+		}
+	}
+	return nil
+}
+
+func (s schedule) progress() ProgramProgressJSON {
+	progress := ProgramProgressJSON{Total: len(s.days)}
+	for _, day := range s.days {
+		switch day.Status {
+		case "done":
+			progress.Done++
+		case "skipped":
+			progress.Skipped++
+		case "missed":
+			progress.Missed++
+		case "pending":
+			progress.Pending++
+		}
+	}
+	if progress.Total > 0 {
+		progress.Percent = float64(progress.Done) / float64(progress.Total) * 100
+	}
+	progress.Finished = progress.Pending == 0
+	return progress
+}
+
+// Get the workout that's due today in the program the user is enrolled in
+func (a *API) GetTodaysWorkout(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	date, ok := getQuery[int64](w, r, "date")
+	if !ok {
+		return
+	}
+
+	schedule, err := getSchedule(a.ctx, a.queries, userID, date)
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Not enrolled in a program")
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get today's workout")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{
+		"enrollment": enrollmentRowToJson(schedule.enrollment),
+		"program":    schedule.program,
+		"today":      schedule.today(date),
+		"next":       schedule.next(date),
+		"progress":   schedule.progress(),
+	})
+}
+
+// Get every day of the program the user is enrolled in and how far along it they are
+func (a *API) GetProgramProgress(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	date, ok := getQuery[int64](w, r, "date")
+	if !ok {
+		return
+	}
+
+	schedule, err := getSchedule(a.ctx, a.queries, userID, date)
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Not enrolled in a program")
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get progress")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{
+		"enrollment": enrollmentRowToJson(schedule.enrollment),
+		"days":       schedule.days,
+		"progress":   schedule.progress(),
+	})
+}
+
+// Start the workout that's due today from its template
+func (a *API) StartTodaysWorkout(w http.ResponseWriter, r *http.Request) {
+	a.logToday(w, r, true)
+}
+
+// Skip the workout that's due today by logging the day without a workout.
+// The schedule moves past it like a day that was done, so the days after it
+// stay where they were.
+func (a *API) SkipTodaysWorkout(w http.ResponseWriter, r *http.Request) {
+	a.logToday(w, r, false)
+}
+
+func (a *API) logToday(w http.ResponseWriter, r *http.Request, start bool) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	req, ok := parseRequest[DateJSON](w, r)
+	if !ok {
+		return
+	}
+	if req.Date == 0 {
+		respond(w, http.StatusBadRequest, "bad request: missing date")
+		return
+	}
+	date := req.Date
+
+	tx, err := a.conn.Begin(a.ctx)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't update program")
+		return
+	}
+	defer tx.Rollback(a.ctx)
+	qtx := a.queries.WithTx(tx)
+
+	schedule, err := getSchedule(a.ctx, qtx, userID, date)
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Not enrolled in a program")
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't update program")
+		return
+	}
+	today := schedule.today(date)
+	if today == nil || today.Status != "pending" {
+		respond(w, http.StatusBadRequest, "No workout is due today")
+		return
+	}
+
+	var workout *WorkoutJSON
+	if start {
+		started, err := startWorkout(a.ctx, qtx, userID, today.TemplateID, date)
+		var message mutationError
+		if errors.As(err, &message) || err == pgx.ErrNoRows {
+			respond(w, http.StatusBadRequest, "The day's template doesn't exist anymore")
+			return
+		}
+		if err != nil {
+			respond(w, http.StatusInternalServerError, "Couldn't update program")
+			return
+		}
+		workout = &started
+		today.WorkoutID = started.ID
+		today.Status = "done"
+	} else {
+		today.Status = "skipped"
+	}
+	today.Date = date
+
+	logged, err := qtx.CreateProgramLog(a.ctx, database.CreateProgramLogParams{
+		Userid:       userID,
+		Enrollmentid: schedule.enrollment.ID,
+		Dayid:        today.ID,
+		Workoutid:    today.WorkoutID,
+		Date:         date,
+	})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't update program")
+		return
+	}
+	if logged == 0 {
+		// another request logged the day first, so the
+		// workout started here is rolled back
+		respond(w, http.StatusConflict, "Today's workout was already started or skipped")
+		return
+	}
+
+	if err := tx.Commit(a.ctx); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't update program")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{"day": today, "workout": workout})
+}
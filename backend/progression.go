@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/aabiji/logbuddy/database"
+)
+
+// When a workout is started from a template, the exercises with a
+// progression get a recommended weight and reps, worked out by their scheme
+// from the last workouts they were done in. Every scheme deloads after the
+// exercise has been failed too many workouts in a row.
+
+// The working sets of an exercise in a workout, with their weights
+// in the weight unit of the exercise being recommended
+type trainingSession []SetJSON
+
+func (s trainingSession) topWeight() float64 {
+	heaviest := 0.0
+	for _, set := range s {
+		heaviest = max(heaviest, set.Weight)
+	}
+	return heaviest
+}
+
+func (s trainingSession) fewestReps() int32 {
+	fewest := int32(math.MaxInt32)
+	for _, set := range s {
+		fewest = min(fewest, set.Reps)
+	}
+	return fewest
+}
+
+// Check if every set reached the reps of the set in the same
+// position in the template. Extra sets need the last set's reps.
+func (s trainingSession) reached(targets []int32) bool {
+	for i, set := range s {
+		if set.Reps < targets[min(i, len(targets)-1)] {
+			return false
+		}
+	}
+	return true
+}
+
+type progressionScheme interface {
+	// Check if the session should count toward a deload
+	failed(p ProgressionJSON, targets []int32, s trainingSession) bool
+	// Recommend the next weight and reps, given the
+	// sessions the exercise was done in, newest first
+	recommend(p ProgressionJSON, targets []int32, sessions []trainingSession) RecommendationJSON
+}
+
+var progressionSchemes = map[string]progressionScheme{
+	"linear":     linearProgression{},
+	"double":     doubleProgression{},
+	"percentage": percentageProgression{},
+}
+
+// Add the increment whenever every set reaches its reps
+type linearProgression struct{}
+
+func (linearProgression) failed(p ProgressionJSON, targets []int32, s trainingSession) bool {
+	return !s.reached(targets)
+}
+
+func (linearProgression) recommend(
+	p ProgressionJSON, targets []int32, sessions []trainingSession,
+) RecommendationJSON {
+	last := sessions[0]
+	if last.reached(targets) {
+		return RecommendationJSON{
+			Weight: last.topWeight() + p.Increment, Reps: slices.Max(targets),
+			Reason: "Every set reached its reps last time",
+		}
+	}
+	return RecommendationJSON{
+		Weight: last.topWeight(), Reps: slices.Max(targets),
+		Reason: "Not every set reached its reps last time",
+	}
+}
+
+// Add reps until every set reaches the top of the rep range,
+// then add the increment and go back to the bottom of the range
+type doubleProgression struct{}
+
+func (doubleProgression) failed(p ProgressionJSON, targets []int32, s trainingSession) bool {
+	return s.fewestReps() < p.MinReps
+}
+
+func (doubleProgression) recommend(
+	p ProgressionJSON, targets []int32, sessions []trainingSession,
+) RecommendationJSON {
+	last := sessions[0]
+	if last.fewestReps() >= p.MaxReps {
+		return RecommendationJSON{
+			Weight: last.topWeight() + p.Increment, Reps: p.MinReps,
+			Reason: fmt.Sprintf("Every set reached %d reps last time", p.MaxReps),
+		}
+	}
+	return RecommendationJSON{
+		Weight: last.topWeight(), Reps: min(max(last.fewestReps()+1, p.MinReps), p.MaxReps),
+		Reason: fmt.Sprintf("Add reps until every set reaches %d", p.MaxReps),
+	}
+}
+
+// Use a percentage of the best one rep max estimated from the last
+// workouts, so one bad workout doesn't lower the weight
+type percentageProgression struct{}
+
+// The number of workouts the one rep max is estimated from
+const estimateWindow = 6
+
+func (percentageProgression) failed(p ProgressionJSON, targets []int32, s trainingSession) bool {
+	return !s.reached(targets)
+}
+
+func (percentageProgression) recommend(
+	p ProgressionJSON, targets []int32, sessions []trainingSession,
+) RecommendationJSON {
+	estimate := 0.0
+	for _, s := range sessions[:min(len(sessions), estimateWindow)] {
+		for _, set := range s {
+			estimate = max(estimate, epley(set.Weight, set.Reps))
+		}
+	}
+	return RecommendationJSON{
+		Weight: roundWeight(estimate*p.Percent/100, p.Increment),
+		Reps:   slices.Max(targets),
+		Reason: fmt.Sprintf("%g%% of an estimated one rep max of %.1f", p.Percent, estimate),
+	}
+}
+
+// Round the weight to a multiple of the increment, the smallest weight
+// that can be added to the bar
+func roundWeight(weight float64, increment float64) float64 {
+	return math.Round(weight/increment) * increment
+}
+
+func convertWeight(weight float64, from string, to string) float64 {
+	if from == to {
+		return weight
+	}
+	kg := toKg(weight, from)
+	if to == "lbs" {
+		return kg / kgPerLb
+	}
+	return kg
+}
+
+// Fill in the zero values of the progression
+func withProgressionDefaults(p ProgressionJSON, e ExerciseJSON) ProgressionJSON {
+	if p.Increment == 0 {
+		p.Increment = 2.5
+		if e.WeightUnit == "lbs" {
+			p.Increment = 5
+		}
+	}
+	if p.MinReps == 0 {
+		p.MinReps = 8
+		if targets := targetReps(e); len(targets) > 0 {
+			p.MinReps = slices.Min(targets)
+		}
+	}
+	if p.MaxReps == 0 {
+		p.MaxReps = p.MinReps + 4
+	}
+	// a max set without a min can be below the default min
+	p.MinReps = min(p.MinReps, p.MaxReps)
+	if p.Percent == 0 {
+		p.Percent = 75
+	}
+	if p.DeloadAfter == 0 {
+		p.DeloadAfter = 3
+	}
+	if p.DeloadPercent == 0 {
+		p.DeloadPercent = 10
+	}
+	return p
+}
+
+// Get the reps of the working sets in the template
+func targetReps(e ExerciseJSON) []int32 {
+	targets := []int32{}
+	for _, set := range e.Sets {
+		if set.Type != "warmup" && set.Reps > 0 {
+			targets = append(targets, set.Reps)
+		}
+	}
+	return targets
+}
+
+// Encode the progression so it can be stored with the exercise
+func encodeProgression(e ExerciseJSON) ([]byte, error) {
+	if e.Progression == nil {
+		return nil, nil
+	}
+	p := e.Progression
+	if _, ok := progressionSchemes[p.Scheme]; !ok {
+		return nil, mutationError(fmt.Sprintf("unknown progression scheme %q", p.Scheme))
+	}
+	if p.Increment < 0 || p.MinReps < 0 || p.MaxReps < 0 || p.Percent < 0 ||
+		p.DeloadAfter < 0 || p.DeloadPercent < 0 || p.DeloadPercent >= 100 {
+		return nil, mutationError("invalid progression")
+	}
+	if p.MinReps > 0 && p.MaxReps > 0 && p.MinReps > p.MaxReps {
+		return nil, mutationError("minReps can't be more than maxReps")
+	}
+	if p.MinReps > 0 && p.MaxReps > 0 && p.MinReps > p.MaxReps {
+		return nil, mutationError("the progression's min reps are above its max reps")
+	}
+	return json.Marshal(p)
+}
+
+func decodeProgression(data []byte) (*ProgressionJSON, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var p *ProgressionJSON
+	err := json.Unmarshal(data, &p)
+	return p, err
+}
+
+// Recommend the weight and reps of the template's exercise, then use them
+// for its working sets. Exercises without a progression or history are
+// left as they are. The weights should already be prefilled, so the
+// exercise has the weight unit it was last done in.
+func recommendProgression(
+	ctx context.Context, q *database.Queries, userID int32, e ExerciseJSON,
+) (ExerciseJSON, error) {
+	if e.Progression == nil || e.ExerciseType != "strength" {
+		return e, nil
+	}
+	scheme, ok := progressionSchemes[e.Progression.Scheme]
+	if !ok {
+		return e, nil
+	}
+	p := withProgressionDefaults(*e.Progression, e)
+	targets := targetReps(e)
+	if len(targets) == 0 {
+		targets = []int32{p.MinReps}
+	}
+
+	rows, err := q.GetRecentSets(ctx, database.GetRecentSetsParams{
+		Userid: userID, Catalogid: e.CatalogID, Name: e.Name,
+		Limit: max(p.DeloadAfter, estimateWindow),
+	})
+	if err != nil || len(rows) == 0 {
+		return e, err
+	}
+	sessions := []trainingSession{}
+	for i, row := range rows {
+		if i == 0 || row.Workoutid != rows[i-1].Workoutid {
+			sessions = append(sessions, trainingSession{})
+		}
+		sessions[len(sessions)-1] = append(sessions[len(sessions)-1], SetJSON{
+			Weight: convertWeight(row.Weight, row.Weightunit, e.WeightUnit), Reps: row.Reps,
+		})
+	}
+
+	failures := 0
+	for _, s := range sessions {
+		if !scheme.failed(p, targets, s) {
+			break
+		}
+		failures++
+	}
+
+	var recommendation RecommendationJSON
+	if failures >= int(p.DeloadAfter) {
+		recommendation = RecommendationJSON{
+			Weight: roundWeight(sessions[0].topWeight()*(1-p.DeloadPercent/100), p.Increment),
+			Reps:   slices.Max(targets),
+			Deload: true,
+			Reason: fmt.Sprintf("Failed the last %d workouts", failures),
+		}
+	} else {
+		recommendation = scheme.recommend(p, targets, sessions)
+	}
+	recommendation.Scheme = p.Scheme
+
+	e.Sets = slices.Clone(e.Sets)
+	for i := range e.Sets {
+		if e.Sets[i].Type == "warmup" {
+			continue
+		}
+		e.Sets[i].Weight = recommendation.Weight
+		if p.Scheme == "double" {
+			e.Sets[i].Reps = recommendation.Reps
+		}
+	}
+	e.Recommendation = &recommendation
+	return e, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
+)
+
+// Requests are rate limited using token buckets. A bucket holds up to `burst`
+// tokens, gets `rate` tokens back every second, and each request takes one.
+// Instead of storing the number of tokens, a bucket is stored as the time at
+// which it'll be full again, which is enough to work out how many tokens it
+// has, and lets the postgres implementation take a token in one query.
+type rateLimit struct {
+	rate  float64 // tokens per second
+	burst float64
+}
+
+func (l rateLimit) interval() time.Duration {
+	return time.Duration(float64(time.Second) / l.rate)
+}
+
+func (l rateLimit) capacity() time.Duration {
+	return time.Duration(l.burst * float64(l.interval()))
+}
+
+// Routes with their own limits, keyed by their unversioned pattern.
+// Every other route shares a bucket limited by defaultRateLimit.
+var routeRateLimits = map[string]rateLimit{
+	"POST /user/login": {rate: 5.0 / 60, burst: 5},
+	"POST /user/new":   {rate: 3.0 / 3600, burst: 3},
+	"GET /food/search": {rate: 5, burst: 30},
+	"GET /foods":       {rate: 5, burst: 30},
+	// exports and imports go through the user's whole history
+	"GET /export/workouts":  {rate: 1.0 / 60, burst: 5},
+	"POST /import/workouts": {rate: 1.0 / 60, burst: 5},
+}
+
+var defaultRateLimit = rateLimit{rate: 2, burst: 60}
+
+type RateLimiter interface {
+	// Take a token from the bucket, or say how long until there's one to take
+	Take(ctx context.Context, bucket string, limit rateLimit) (bool, time.Duration, error)
+	// Forget about the buckets that are full
+	Cleanup(ctx context.Context) error
+}
+
+// Keeps the buckets in memory, so each backend instance has its own buckets
+type MemoryRateLimiter struct {
+	mutex  sync.Mutex
+	fullAt map[string]time.Time
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{fullAt: map[string]time.Time{}}
+}
+
+func (m *MemoryRateLimiter) Take(
+	ctx context.Context, bucket string, limit rateLimit,
+) (bool, time.Duration, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	fullAt, ok := m.fullAt[bucket]
+	if !ok || fullAt.Before(now) {
+		fullAt = now
+	}
+
+	// taking a token pushes back the time the bucket will be full again,
+	// so it's empty when that time is more than a full bucket away
+	next := fullAt.Add(limit.interval())
+	if wait := next.Sub(now) - limit.capacity(); wait > 0 {
+		return false, wait, nil
+	}
+
+	m.fullAt[bucket] = next
+	return true, 0, nil
+}
+
+func (m *MemoryRateLimiter) Cleanup(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	for bucket, fullAt := range m.fullAt {
+		if fullAt.Before(now) {
+			delete(m.fullAt, bucket)
+		}
+	}
+	return nil
+}
+
+// Keeps the buckets in the database, so they're shared by every backend instance
+type PostgresRateLimiter struct {
+	queries *database.Queries
+}
+
+func NewPostgresRateLimiter(queries *database.Queries) *PostgresRateLimiter {
+	return &PostgresRateLimiter{queries}
+}
+
+func (p *PostgresRateLimiter) Take(
+	ctx context.Context, bucket string, limit rateLimit,
+) (bool, time.Duration, error) {
+	now := float64(time.Now().UnixMicro()) / 1e6
+	_, err := p.queries.TakeRateLimitToken(ctx, database.TakeRateLimitTokenParams{
+		Bucket:   bucket,
+		Now:      now,
+		Interval: limit.interval().Seconds(),
+		Capacity: limit.capacity().Seconds(),
+	})
+	if err == nil {
+		return true, 0, nil
+	}
+	if err != pgx.ErrNoRows {
+		return false, 0, err
+	}
+
+	// the bucket's empty, so work out when there'll be another token
+	fullAt, err := p.queries.GetRateLimitFullAt(ctx, bucket)
+	if err != nil {
+		return false, 0, err
+	}
+	wait := fullAt + limit.interval().Seconds() - limit.capacity().Seconds() - now
+	return false, time.Duration(wait * float64(time.Second)), nil
+}
+
+func (p *PostgresRateLimiter) Cleanup(ctx context.Context) error {
+	now := float64(time.Now().Unix())
+	return p.queries.DeleteFullRateLimits(ctx, now)
+}
+
+// Use the database when RATE_LIMIT_STORE is "postgres",
+// which is needed when running multiple backend instances
+func NewRateLimiter(a *API) RateLimiter {
+	if os.Getenv("RATE_LIMIT_STORE") == "postgres" {
+		return NewPostgresRateLimiter(a.queries)
+	}
+	return NewMemoryRateLimiter()
+}
+
+// Parse the comma separated list of addresses or
+// CIDR ranges in TRUSTED_PROXIES that are allowed to
+// set the X-Forwarded-For header
+func trustedProxies() ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, value := range envList("TRUSTED_PROXIES", nil) {
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, err
+			}
+			value = fmt.Sprintf("%s/%d", value, addr.BitLen())
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func isTrusted(proxies []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range proxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the address of the client that made the request. When the request comes
+// through trusted proxies, the client is the last address in X-Forwarded-For
+// that wasn't added by a trusted proxy.
+func clientIP(r *http.Request, proxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !isTrusted(proxies, addr) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		addr = hop
+		if !isTrusted(proxies, hop) {
+			break
+		}
+	}
+	return addr.Unmap().String()
+}
+
+var versionPrefix = regexp.MustCompile(`^/v[0-9]+/`)
+
+// Strip the version prefix from a route pattern
+func unversionedPattern(pattern string) string {
+	method, path, _ := strings.Cut(pattern, " ")
+	path = versionPrefix.ReplaceAllString(path, "/")
+	return fmt.Sprintf("%s %s", method, path)
+}
+
+// Rate limit requests by user, or by ip address when the request isn't
+// authenticated, using the limit of the route the mux would route to
+func rateLimitMiddleware(
+	logger *log.Logger, limiter RateLimiter,
+	proxies []netip.Prefix, mux *http.ServeMux,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		pattern = unversionedPattern(pattern)
+
+		bucket := "default"
+		limit, ok := routeRateLimits[pattern]
+		if ok {
+			bucket = pattern
+		} else {
+			limit = defaultRateLimit
+		}
+
+		if userID, ok := tokenUserID(r); ok {
+			bucket = fmt.Sprintf("%s:user:%d", bucket, userID)
+		} else {
+			bucket = fmt.Sprintf("%s:ip:%s", bucket, clientIP(r, proxies))
+		}
+
+		allowed, wait, err := limiter.Take(r.Context(), bucket, limit)
+		if err != nil {
+			// don't lock everyone out when the limiter's broken
+			logger.Printf("rate limiter failed: %s\n", err.Error())
+			mux.ServeHTTP(w, r)
+			return
+		}
+		if !allowed {
+			seconds := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", max(seconds, 1)))
+			respond(w, http.StatusTooManyRequests, "Too many requests")
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Forget about full buckets every so often so they don't pile up
+func cleanupRateLimits(logger *log.Logger, ctx context.Context, limiter RateLimiter) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := limiter.Cleanup(ctx); err != nil {
+				logger.Printf("rate limiter cleanup failed: %s\n", err.Error())
+			}
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
@@ -21,17 +23,74 @@ func (a *API) SetWeightEntry(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
+	a.setWeight(w, userID, date, weight, 0)
+}
 
-	v := database.SetWeightParams{
-		Date: date, Value: float64(weight), Userid: userID,
-		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+// Set the weight on the date in the path to the value in the request body.
+// The version in the body is the version of the record the change is based on.
+func (a *API) PutWeight(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	date, ok := getPathValue[int64](w, r, "date")
+	if !ok {
+		return
+	}
+	req, ok := parseRequest[RecordJSON](w, r)
+	if !ok {
+		return
 	}
-	if err := a.queries.SetWeight(a.ctx, v); err != nil {
+	a.setWeight(w, userID, date, req.Value, req.Version)
+}
+
+func (a *API) setWeight(
+	w http.ResponseWriter, userID int32, date int64, weight float64, version int64,
+) {
+	tx, err := a.conn.Begin(a.ctx)
+	if err != nil {
 		respond(w, http.StatusInternalServerError, "Failed to set weight entry")
 		return
 	}
+	defer tx.Rollback(a.ctx)
 
-	respond(w, http.StatusOK, nil)
+	record, err := setWeightTx(a.ctx, a.queries.WithTx(tx), userID, date, weight, version)
+	if respondConflict(w, err) {
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Failed to set weight entry")
+		return
+	}
+
+	if err := tx.Commit(a.ctx); err != nil {
+		respond(w, http.StatusInternalServerError, "Failed to set weight entry")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{"record": record})
+}
+
+// Set the weight on the date, unless it changed since the version the
+// change is based on, and estimate the calories of the workouts it's the
+// closest weight of again. Should be called in a transaction.
+func setWeightTx(
+	ctx context.Context, q *database.Queries,
+	userID int32, date int64, weight float64, version int64,
+) (RecordJSON, error) {
+	var err error
+	record := RecordJSON{Date: date, Value: weight}
+	record.Version, err = q.SetWeight(ctx, database.SetWeightParams{
+		Date: date, Value: weight, Userid: userID,
+		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+		BaseVersion:  baseVersion(version),
+	})
+	if err == pgx.ErrNoRows {
+		return record, recordConflict(ctx, q, userID, "weight", date)
+	}
+	if err != nil {
+		return record, err
+	}
+	return record, updateCalories(ctx, q, userID, date)
 }
 
 func (a *API) DeleteWeightEntry(w http.ResponseWriter, r *http.Request) {
@@ -43,16 +102,77 @@ func (a *API) DeleteWeightEntry(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
+	version, ok := getOptionalQuery[int64](w, r, "version", 0)
+	if !ok {
+		return
+	}
+	a.deleteWeight(w, userID, date, version)
+}
+
+func (a *API) RemoveWeight(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	date, ok := getPathValue[int64](w, r, "date")
+	if !ok {
+		return
+	}
+	version, ok := getOptionalQuery[int64](w, r, "version", 0)
+	if !ok {
+		return
+	}
+	a.deleteWeight(w, userID, date, version)
+}
+
+func (a *API) deleteWeight(w http.ResponseWriter, userID int32, date int64, version int64) {
+	tx, err := a.conn.Begin(a.ctx)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Failed to delete weight entry")
+		return
+	}
+	defer tx.Rollback(a.ctx)
 
-	v := database.DeleteRecordParams{Date: date, Userid: userID}
-	if err := a.queries.DeleteRecord(a.ctx, v); err != nil {
+	err = deleteWeightTx(a.ctx, a.queries.WithTx(tx), userID, date, version)
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Weight entry not found")
+		return
+	}
+	if respondConflict(w, err) {
+		return
+	}
+	if err != nil {
 		respond(w, http.StatusInternalServerError, "Failed to delete weight entry")
 		return
 	}
 
+	if err := tx.Commit(a.ctx); err != nil {
+		respond(w, http.StatusInternalServerError, "Failed to delete weight entry")
+		return
+	}
 	respond(w, http.StatusOK, nil)
 }
 
+// Soft delete the weight on the date, unless it changed since the version
+// the delete is based on, and estimate the calories of the workouts it was
+// the closest weight of again. Should be called in a transaction.
+func deleteWeightTx(
+	ctx context.Context, q *database.Queries, userID int32, date int64, version int64,
+) error {
+	deleted, err := q.DeleteRecord(ctx, database.DeleteRecordParams{
+		Date: date, Userid: userID, Recordtype: "weight",
+		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+		BaseVersion:  baseVersion(version),
+	})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return recordConflict(ctx, q, userID, "weight", date)
+	}
+	return updateCalories(ctx, q, userID, date)
+}
+
 func (a *API) TogglePeriodDate(w http.ResponseWriter, r *http.Request) {
 	userID, ok := parseJWT(a, w, r)
 	if !ok {
@@ -77,3 +197,73 @@ func (a *API) TogglePeriodDate(w http.ResponseWriter, r *http.Request) {
 
 	respond(w, http.StatusOK, nil)
 }
+
+// Mark or unmark the date in the path as a period day. Unlike
+// TogglePeriodDate, the request body says which one it should be,
+// along with the version of the record the change is based on.
+func (a *API) PutPeriod(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	date, ok := getPathValue[int64](w, r, "date")
+	if !ok {
+		return
+	}
+	req, ok := parseRequest[RecordJSON](w, r)
+	if !ok {
+		return
+	}
+
+	record, err := setPeriod(a.ctx, a.queries, userID, date, req.IsPeriod, req.Version)
+	if respondConflict(w, err) {
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Failed to set date")
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]any{"record": record})
+}
+
+func setPeriod(
+	ctx context.Context, q *database.Queries,
+	userID int32, date int64, isPeriod bool, version int64,
+) (RecordJSON, error) {
+	value := 0.0
+	if isPeriod {
+		value = 1
+	}
+
+	var err error
+	record := RecordJSON{IsPeriod: true, Date: date, Value: value}
+	record.Version, err = q.SetPeriod(ctx, database.SetPeriodParams{
+		Userid: userID, Date: date, Value: value,
+		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+		BaseVersion:  baseVersion(version),
+	})
+	if err == pgx.ErrNoRows {
+		return record, recordConflict(ctx, q, userID, "period", date)
+	}
+	return record, err
+}
+
+// A conflict holding the server's copy of the record
+func recordConflict(
+	ctx context.Context, q *database.Queries, userID int32, recordType string, date int64,
+) error {
+	row, err := q.GetRecord(ctx, database.GetRecordParams{
+		Userid: userID, Recordtype: recordType, Date: date})
+	if err != nil {
+		return err
+	}
+	return conflictError{recordRowToJson(row)}
+}
+
+func recordRowToJson(row database.Record) RecordJSON {
+	return RecordJSON{
+		Deleted: row.Deleted, IsPeriod: row.Recordtype == "period",
+		Date: row.Date, Value: row.Value, Version: row.Version,
+	}
+}
@@ -1,16 +1,21 @@
 -- name: CreateUser :one
 insert into users (email, password) values ($1, $2) returning id;
 
--- name: SetUserSettings :exec
+-- name: SetUserSettings :one
+-- (returns no rows when the settings aren't at the base version)
 insert into settings
-(userID, mealTags, macroTargets, useImperial, trackPeriod, darkMode)
-values ($1, $2, $3, $4, $5, $6)
+(userID, mealTags, macroTargets, useImperial, trackPeriod, darkMode, lastModified)
+values ($1, $2, $3, $4, $5, $6, $7)
 on conflict (userID) do update
 set mealTags = excluded.mealTags,
     macroTargets = excluded.macroTargets,
     useImperial = excluded.useImperial,
     darkMode = excluded.darkMode,
-    trackPeriod = excluded.trackPeriod;
+    trackPeriod = excluded.trackPeriod,
+    lastModified = excluded.lastModified,
+    version = settings.version + 1
+where settings.version = coalesce(sqlc.narg('baseVersion'), settings.version)
+returning version;
 
 -- name: GetUserSettings :one
 select * from settings where userID = $1;
@@ -24,8 +29,8 @@ select id, email, Password from users where email = $1 or id = $2;
 -- name: CreateFood :one
 insert into foods
 (userID, name, servingSizes, servingUnits, defaultServingIndex,
-calories, carbohydrate, protein, fat, calcium, potassium, iron)
-values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+calories, carbohydrate, protein, fat, calcium, potassium, iron, lastModified)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 returning id;
 
 -- name: GetFoodByID :one
@@ -41,49 +46,142 @@ where to_tsvector(name) @@ to_tsquery($1) and userID = $2 limit 100;
 
 -- name: CreateMeal :one
 insert into meals
-(userID, foodID, date, mealTag, servings, unit)
-values ($1, $2, $3, $4, $5, $6) returning id;
+(userID, foodID, date, mealTag, servings, unit, lastModified)
+values ($1, $2, $3, $4, $5, $6, $7) returning id;
 
--- name: DeleteMeal :exec
-update meals set deleted = true, lastModified = $1
-where userID = $2 and id = $3;
+-- name: DeleteMeal :execrows
+-- (deletes no rows when the meal isn't at the base version)
+update meals set deleted = true, lastModified = $1, version = version + 1
+where userID = $2 and id = $3 and version = coalesce(sqlc.narg('baseVersion'), version);
 
--- name: UpdateMeal :exec
+-- name: UpdateMeal :one
+-- (returns no rows when the meal isn't at the base version)
 update meals
-set lastModified = $1, mealTag = $2, servings = $3, unit = $4
-where ID = $5;
+set lastModified = $1, mealTag = $2, servings = $3, unit = $4, version = version + 1
+where ID = $5 and userID = $6 and deleted = false and version = sqlc.arg('baseVersion')
+returning version;
+
+-- name: GetMeal :one
+select * from meals where id = $1 and userID = $2 and deleted = false;
 
 -- name: GetMealsForDay :many
 select * from meals where date = $1 and userID = $2 and deleted = false;
 
+-- name: GetMealsInRange :many
+select * from meals
+where userID = $1 and date >= sqlc.arg('fromDate') and date <= sqlc.arg('toDate')
+  and deleted = false
+order by date, id;
+
 -- name: CreateWorkout :one
-insert into workouts (userID, name, notes, date, isTemplate)
-values ($1, $2, $3, $4, $5) returning id;
+insert into workouts
+(userID, name, notes, date, isTemplate, templateID, calories, groups, lastModified)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id;
 
 -- name: CreateExercises :batchmany
 insert into exercises
-(userID, workoutID, exerciseType, name, weight, weightUnit, reps, duration)
-values ($1, $2, $3, $4, $5, $6, $7, $8) returning id;
+(userID, workoutID, position, catalogID, exerciseType, name, weight, weightUnit, reps, duration,
+ progression, distance, elevationGain, avgHeartRate, maxHeartRate, groupNumber, lastModified)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17) returning id;
+
+-- name: UpdateExercise :execrows
+update exercises
+set lastModified = $1, position = $2, catalogID = $3, exerciseType = $4, name = $5,
+    weight = $6, weightUnit = $7, reps = $8, duration = $9, progression = $10,
+    distance = $11, elevationGain = $12, avgHeartRate = $13, maxHeartRate = $14,
+    groupNumber = $15
+where id = $16 and workoutID = $17 and userID = $18 and deleted = false;
+
+-- name: CreateSets :batchexec
+insert into sets
+(userID, workoutID, exerciseID, position, weight, reps, rpe, restSeconds, setType)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+
+-- name: DeleteSets :exec
+delete from sets where exerciseID = $1 and userID = $2;
+
+-- name: GetLastPerformance :one
+-- (the last time the user did the catalog exercise, or the exercise
+-- with the name when it isn't in the catalog, outside of templates)
+select exercises.* from exercises
+join workouts on workouts.id = exercises.workoutID
+where exercises.userID = $1 and not exercises.deleted
+  and not workouts.deleted and not workouts.isTemplate
+  and case when $2::int <> 0 then exercises.catalogID = $2
+      else lower(exercises.name) = lower($3) end
+order by workouts.date desc, exercises.id desc limit 1;
+
+-- name: GetExerciseSets :many
+select * from sets where exerciseID = $1 and userID = $2 order by position;
+
+-- name: GetSets :many
+select * from sets where userID = $1 and workoutID = $2
+order by exerciseID, position;
+
+-- name: DeleteOtherExercises :exec
+-- (soft deletes the workout's exercises that aren't in keep)
+update exercises set deleted = true, lastModified = $1
+where workoutID = $2 and userID = $3 and deleted = false
+  and id <> all(sqlc.arg('keep')::int[]);
+
+-- name: DeleteOtherExerciseSets :exec
+-- (the sets of the exercises DeleteOtherExercises removes)
+delete from sets where workoutID = $1 and userID = $2
+  and exerciseID <> all(sqlc.arg('keep')::int[]);
+
+-- name: GetWorkout :one
+select * from workouts where id = $1 and userID = $2 and deleted = false;
 
--- name: DeleteWorkout :exec
-update workouts set deleted = true, lastModified = $1 where userID = $2 and id = $3;
+-- name: UpdateWorkout :one
+-- (returns no rows when the workout isn't at the base version)
+update workouts
+set lastModified = $1, name = $2, notes = $3, date = $4, isTemplate = $5, calories = $6,
+    groups = $7, version = version + 1
+where id = $8 and userID = $9 and deleted = false and version = sqlc.arg('baseVersion')
+returning version;
+
+-- name: DeleteWorkout :execrows
+-- (deletes no rows when the workout isn't at the base version)
+update workouts set deleted = true, lastModified = $1, version = version + 1
+where userID = $2 and id = $3 and version = coalesce(sqlc.narg('baseVersion'), version);
 
 -- name: DeleteExercise :exec
 update exercises set deleted = true, lastModified = $1 where workoutID = $2 and userID = $3;
 
--- name: SetWeight :exec
-insert into records (userID, recordType, date, value)
-values ($1, 'weight', $2, $3) on conflict(userID, recordType, date) do update
-set value = excluded.value, lastModified = $4;
+-- name: SetWeight :one
+-- (returns no rows when the record isn't at the base version)
+insert into records (userID, recordType, date, value, lastModified)
+values ($1, 'weight', $2, $3, $4) on conflict(userID, recordType, date) do update
+set value = excluded.value, deleted = false, lastModified = $4,
+    version = records.version + 1
+where records.version = coalesce(sqlc.narg('baseVersion'), records.version)
+returning version;
+
+-- name: SetPeriod :one
+-- (returns no rows when the record isn't at the base version)
+insert into records (userID, recordType, date, value, lastModified)
+values ($1, 'period', $2, $3, $4)
+on conflict (userID, recordType, date) do update
+set value = excluded.value, deleted = false, lastModified = $4,
+    version = records.version + 1
+where records.version = coalesce(sqlc.narg('baseVersion'), records.version)
+returning version;
 
 -- name: TogglePeriodDate :exec
 -- (toggles the value column between 0/1)
-insert into records (userID, recordType, date, value) values ($1, 'period', $2, $3)
+insert into records (userID, recordType, date, value, lastModified)
+values ($1, 'period', $2, $3, $4)
 on conflict (userID, recordType, date) do update
-set value = 1 - excluded.value, lastModified = $4;
+set value = 1 - excluded.value, lastModified = $4, version = records.version + 1;
 
--- name: DeleteRecord :exec
-update records set deleted = true, lastModified = $1 where userID = $2 and date = $3;
+-- name: GetRecord :one
+select * from records where userID = $1 and recordType = $2 and date = $3;
+
+-- name: DeleteRecord :execrows
+-- (deletes no rows when the record isn't at the base version)
+update records set deleted = true, lastModified = $1, version = version + 1
+where userID = $2 and recordType = $3 and date = $4
+  and version = coalesce(sqlc.narg('baseVersion'), version);
 
 -- name: GetUpdatedWorkouts :many
 select * from workouts
@@ -93,7 +191,8 @@ where userID = $1 and lastModified >= $2
 -- name: GetExercises :many
 select * from exercises
 where userID = $1 and workoutID = $2
-  and deleted = coalesce(sqlc.narg('ignoreDeleted'), deleted);
+  and deleted = coalesce(sqlc.narg('ignoreDeleted'), deleted)
+order by position, id;
 
 -- name: GetUpdatedMeals :many
 select * from meals where userID = $1 and lastModified >= $2
@@ -121,5 +220,471 @@ delete from records where userID = $1;
 -- name: HardDeleteExercises :exec
 delete from exercises where userID = $1;
 
+-- name: HardDeleteSets :exec
+delete from sets where userID = $1;
+
 -- name: HardDeleteWorkouts :exec
 delete from workouts where userID = $1;
+
+-- name: TakeRateLimitToken :one
+-- (returns no rows when the bucket is empty)
+insert into rateLimits (bucket, fullAt)
+values (sqlc.arg('bucket'), sqlc.arg('now')::float + sqlc.arg('interval')::float)
+on conflict (bucket) do update
+set fullAt = greatest(rateLimits.fullAt, sqlc.arg('now')::float) + sqlc.arg('interval')::float
+where greatest(rateLimits.fullAt, sqlc.arg('now')::float) + sqlc.arg('interval')::float
+    - sqlc.arg('now')::float <= sqlc.arg('capacity')::float
+returning fullAt;
+
+-- name: GetRateLimitFullAt :one
+select fullAt from rateLimits where bucket = $1;
+
+-- name: DeleteFullRateLimits :exec
+delete from rateLimits where fullAt < $1;
+
+-- name: GetChanges :many
+select * from changes where userID = $1 and seq > $2 order by seq limit $3;
+
+-- name: GetMealsByID :many
+select * from meals where userID = $1 and id = any(sqlc.arg('ids')::int[]);
+
+-- name: GetWorkoutsByID :many
+select * from workouts where userID = $1 and id = any(sqlc.arg('ids')::int[]);
+
+-- name: GetRecordsByID :many
+select * from records where userID = $1 and id = any(sqlc.arg('ids')::int[]);
+
+-- name: GetFoodsByID :many
+select * from foods where id = any(sqlc.arg('ids')::int[]);
+
+-- name: HardDeleteChanges :exec
+delete from changes where userID = $1;
+
+-- name: SetDeviceSeq :exec
+insert into devices (userID, deviceID, seq, lastSeen) values ($1, $2, $3, $4)
+on conflict (userID, deviceID) do update
+set seq = excluded.seq, lastSeen = excluded.lastSeen;
+
+-- name: GetPurged :one
+select purgedSeq, purgedAt from users where id = $1;
+
+-- name: DeleteStaleDevices :exec
+delete from devices where lastSeen < $1;
+
+-- name: PurgeDeletedExercises :one
+-- (hard deletes the soft deleted exercises whose workout's last change
+-- has been pulled by every device, returning how many were deleted)
+with purged as (
+    delete from exercises using changes
+    where exercises.deleted and exercises.lastModified < sqlc.arg('before')
+      and changes.userID = exercises.userID and changes.entity = 'workout'
+      and changes.entityID = exercises.workoutID
+      and changes.seq <= coalesce(
+        (select min(seq) from devices where devices.userID = exercises.userID), changes.seq)
+    returning exercises.userID, exercises.id, changes.seq
+), orphans as (
+    delete from sets using purged where sets.exerciseID = purged.id
+), orphanedTracks as (
+    delete from tracks using purged where tracks.exerciseID = purged.id
+), latest as (
+    update users set purgedSeq = greatest(purgedSeq, last.seq), purgedAt = sqlc.arg('now')
+    from (select userID, max(seq) as seq from purged group by userID) last
+    where users.id = last.userID
+)
+select count(*) from purged;
+
+-- name: PurgeDeletedWorkouts :one
+with purged as (
+    delete from workouts using changes
+    where workouts.deleted and workouts.lastModified < sqlc.arg('before')
+      and changes.userID = workouts.userID and changes.entity = 'workout'
+      and changes.entityID = workouts.id
+      and changes.seq <= coalesce(
+        (select min(seq) from devices where devices.userID = workouts.userID), changes.seq)
+    returning workouts.userID, workouts.id, changes.seq
+), orphans as (
+    delete from exercises using purged where exercises.workoutID = purged.id
+), orphanedSets as (
+    delete from sets using purged where sets.workoutID = purged.id
+), orphanedTracks as (
+    delete from tracks using purged where tracks.workoutID = purged.id
+), forgotten as (
+    delete from changes using purged
+    where changes.userID = purged.userID and changes.entity = 'workout'
+      and changes.entityID = purged.id
+), latest as (
+    update users set purgedSeq = greatest(purgedSeq, last.seq), purgedAt = sqlc.arg('now')
+    from (select userID, max(seq) as seq from purged group by userID) last
+    where users.id = last.userID
+)
+select count(*) from purged;
+
+-- name: PurgeDeletedMeals :one
+with purged as (
+    delete from meals using changes
+    where meals.deleted and meals.lastModified < sqlc.arg('before')
+      and changes.userID = meals.userID and changes.entity = 'meal'
+      and changes.entityID = meals.id
+      and changes.seq <= coalesce(
+        (select min(seq) from devices where devices.userID = meals.userID), changes.seq)
+    returning meals.userID, meals.id, changes.seq
+), forgotten as (
+    delete from changes using purged
+    where changes.userID = purged.userID and changes.entity = 'meal'
+      and changes.entityID = purged.id
+), latest as (
+    update users set purgedSeq = greatest(purgedSeq, last.seq), purgedAt = sqlc.arg('now')
+    from (select userID, max(seq) as seq from purged group by userID) last
+    where users.id = last.userID
+)
+select count(*) from purged;
+
+-- name: PurgeDeletedRecords :one
+with purged as (
+    delete from records using changes
+    where records.deleted and records.lastModified < sqlc.arg('before')
+      and changes.userID = records.userID and changes.entity = 'record'
+      and changes.entityID = records.id
+      and changes.seq <= coalesce(
+        (select min(seq) from devices where devices.userID = records.userID), changes.seq)
+    returning records.userID, records.id, changes.seq
+), forgotten as (
+    delete from changes using purged
+    where changes.userID = purged.userID and changes.entity = 'record'
+      and changes.entityID = purged.id
+), latest as (
+    update users set purgedSeq = greatest(purgedSeq, last.seq), purgedAt = sqlc.arg('now')
+    from (select userID, max(seq) as seq from purged group by userID) last
+    where users.id = last.userID
+)
+select count(*) from purged;
+
+-- name: HardDeleteDevices :exec
+delete from devices where userID = $1;
+
+-- name: ClaimIdempotencyKey :execrows
+-- Keys whose requests have been handled for longer than
+-- staleBefore were abandoned, so they can be claimed again.
+insert into IdempotencyKeys (userID, key, route, requestHash, createdAt)
+values ($1, $2, $3, $4, $5)
+on conflict (userID, key) do update
+set route = excluded.route, requestHash = excluded.requestHash,
+    createdAt = excluded.createdAt
+where IdempotencyKeys.status = 0 and IdempotencyKeys.createdAt < sqlc.arg('staleBefore');
+
+-- name: GetIdempotencyKey :one
+select * from IdempotencyKeys where userID = $1 and key = $2;
+
+-- name: SaveIdempotentResponse :exec
+update IdempotencyKeys set status = $1, response = $2
+where userID = $3 and key = $4;
+
+-- name: DeleteIdempotencyKey :exec
+delete from IdempotencyKeys where userID = $1 and key = $2;
+
+-- name: DeleteExpiredIdempotencyKeys :exec
+delete from IdempotencyKeys where createdAt < $1;
+
+-- name: HardDeleteIdempotencyKeys :exec
+delete from IdempotencyKeys where userID = $1;
+
+-- name: UpsertCatalogExercises :batchexec
+insert into CatalogExercises
+(userID, name, aliases, primaryMuscles, secondaryMuscles, equipment, exerciseType, met)
+values (0, $1, $2, $3, $4, $5, $6, $7)
+on conflict (userID, lower(name)) do update
+set aliases = excluded.aliases, primaryMuscles = excluded.primaryMuscles,
+    secondaryMuscles = excluded.secondaryMuscles, equipment = excluded.equipment,
+    exerciseType = excluded.exerciseType, met = excluded.met;
+
+-- name: CreateCatalogExercise :one
+insert into CatalogExercises
+(userID, name, aliases, primaryMuscles, secondaryMuscles, equipment, exerciseType, met)
+values ($1, $2, $3, $4, $5, $6, $7, $8) returning id;
+
+-- name: GetCatalogExercise :one
+select * from CatalogExercises where id = $1 and userID in (0, $2);
+
+-- name: FindCatalogExercise :one
+-- (finds the exercise the user can log with the name or alias, ignoring
+-- case, preferring exercises with the name, then the user's own exercises)
+select id from CatalogExercises
+where userID in (0, $1)
+  and (lower(name) = lower($2)
+    or exists (select 1 from unnest(aliases) alias where lower(alias) = lower($2)))
+order by lower(name) = lower($2) desc, userID desc, id limit 1;
+
+-- name: SearchCatalog :many
+-- (the muscle and equipment filters are optional)
+select * from CatalogExercises
+where userID in (0, $1)
+  and (position(lower($2) in lower(name)) > 0
+    or exists (select 1 from unnest(aliases) alias where position(lower($2) in lower(alias)) > 0))
+  and ($3::text is null or $3 = any(primaryMuscles) or $3 = any(secondaryMuscles))
+  and ($4::text is null or equipment = $4)
+order by name limit 100;
+
+-- name: LinkExercisesToCatalog :many
+-- (links the exercises that aren't in the catalog to the catalog
+-- exercise with their name or alias, chosen like FindCatalogExercise,
+-- returning the exercises that were linked)
+update exercises set catalogID = linked.catalogID
+from (
+    select distinct on (exercises.id) exercises.id, catalog.id as catalogID
+    from exercises
+    join CatalogExercises catalog on catalog.userID in (0, exercises.userID)
+      and (lower(catalog.name) = lower(exercises.name)
+        or exists (select 1 from unnest(catalog.aliases) alias where lower(alias) = lower(exercises.name)))
+    where exercises.catalogID = 0
+    order by exercises.id, lower(catalog.name) = lower(exercises.name) desc, catalog.userID desc, catalog.id
+) linked
+where exercises.id = linked.id
+returning exercises.userID, exercises.catalogID, exercises.name;
+
+-- name: HardDeleteCatalogExercises :exec
+delete from CatalogExercises where userID = $1;
+
+-- name: GetExerciseHistory :many
+-- (the sets of the catalog exercise, or the exercise with the name when it
+-- isn't in the catalog, in the order the workouts were done. exercises
+-- without sets, like cardio exercises, have a row with 0 reps)
+select workouts.id as workoutID, workouts.date, exercises.id as exerciseID,
+  exercises.exerciseType, exercises.weightUnit, exercises.duration, exercises.distance,
+  coalesce(sets.weight, 0)::float as weight, coalesce(sets.reps, 0)::int as reps
+from exercises
+join workouts on workouts.id = exercises.workoutID
+left join sets on sets.exerciseID = exercises.id and sets.setType <> 'warmup'
+where exercises.userID = $1
+  and not exercises.deleted and not workouts.deleted and not workouts.isTemplate
+  and case when $2::int <> 0 then exercises.catalogID = $2
+      else exercises.catalogID = 0 and lower(trim(exercises.name)) = lower($3) end
+order by workouts.date, workouts.id, exercises.id, sets.position;
+
+-- name: GetRecordExercises :many
+-- (the exercises in every user's workouts, one for each catalog
+-- exercise or name of an exercise that isn't in the catalog)
+select distinct on (exercises.userID, exercises.catalogID,
+    case when exercises.catalogID <> 0 then '' else lower(trim(exercises.name)) end)
+  exercises.userID, exercises.catalogID, trim(exercises.name)::text as name
+from exercises
+join workouts on workouts.id = exercises.workoutID
+where not exercises.deleted and not workouts.deleted and not workouts.isTemplate;
+
+-- name: CreatePersonalRecords :batchexec
+insert into PersonalRecords
+(userID, workoutID, exerciseID, catalogID, name, kind, value, previous, weight, reps, date)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);
+
+-- name: DeleteExerciseRecords :exec
+-- (the records of the exercise, matched like GetExerciseHistory, from the date on)
+delete from PersonalRecords
+where userID = $1
+  and case when $2::int <> 0 then catalogID = $2
+      else catalogID = 0 and lower(name) = lower($3) end
+  and date >= $4;
+
+-- name: ClearPersonalRecords :exec
+delete from PersonalRecords;
+
+-- name: GetExerciseRecords :many
+select * from PersonalRecords
+where userID = $1
+  and case when $2::int <> 0 then catalogID = $2
+      else catalogID = 0 and lower(name) = lower(trim($3)) end
+order by date desc, id desc;
+
+-- name: GetRecordFeed :many
+select * from PersonalRecords
+where userID = $1 and id < $2
+order by id desc limit $3;
+
+-- name: HardDeletePersonalRecords :exec
+delete from PersonalRecords where userID = $1;
+
+-- name: GetTrainingSets :many
+-- (the sets of the strength exercises done in the date range,
+-- with the muscles of their catalog exercises)
+select workouts.id as workoutID, workouts.date, exercises.name, exercises.catalogID,
+  exercises.weightUnit, sets.weight, sets.reps,
+  coalesce(catalogExercises.primaryMuscles, '{}')::text[] as primaryMuscles,
+  coalesce(catalogExercises.secondaryMuscles, '{}')::text[] as secondaryMuscles
+from sets
+join exercises on exercises.id = sets.exerciseID
+join workouts on workouts.id = exercises.workoutID
+left join catalogExercises on catalogExercises.id = exercises.catalogID
+where sets.userID = $1 and sets.setType <> 'warmup' and exercises.exerciseType <> 'cardio'
+  and not exercises.deleted and not workouts.deleted and not workouts.isTemplate
+  and workouts.date >= sqlc.arg('fromDate') and workouts.date <= sqlc.arg('toDate')
+order by workouts.date, workouts.id, exercises.position, sets.position;
+
+-- name: GetWorkoutDates :many
+select date from workouts
+where userID = $1 and not deleted and not isTemplate
+  and date >= sqlc.arg('fromDate') and date <= sqlc.arg('toDate')
+order by date;
+
+-- name: GetRecentSets :many
+-- (the sets of the exercise in the last workouts it was done in, newest first)
+with recent as (
+  select distinct workouts.id, workouts.date from workouts
+  join exercises on exercises.workoutID = workouts.id
+  where workouts.userID = $1 and not workouts.deleted and not workouts.isTemplate
+    and not exercises.deleted
+    and case when $2::int <> 0 then exercises.catalogID = $2
+        else lower(exercises.name) = lower($3) end
+  order by workouts.date desc, workouts.id desc
+  limit $4
+)
+select recent.id as workoutID, exercises.weightUnit, sets.weight, sets.reps from recent
+join exercises on exercises.workoutID = recent.id
+join sets on sets.exerciseID = exercises.id
+where not exercises.deleted and sets.setType <> 'warmup'
+  and case when $2::int <> 0 then exercises.catalogID = $2
+      else lower(exercises.name) = lower($3) end
+order by recent.date desc, recent.id desc, exercises.position, sets.position;
+
+-- name: CreateProgram :one
+insert into Programs (userID, name, description, weeks)
+values ($1, $2, $3, $4) returning id;
+
+-- name: CreateProgramDays :batchexec
+insert into ProgramDays (userID, programID, week, day, templateID)
+values ($1, $2, $3, $4, $5);
+
+-- name: GetProgram :one
+select * from Programs where id = $1 and userID = $2;
+
+-- name: GetPrograms :many
+select * from Programs where userID = $1 order by id;
+
+-- name: GetProgramDays :many
+select programDays.id, programDays.week, programDays.day, programDays.templateID,
+  coalesce(workouts.name, '') as templateName
+from programDays
+left join workouts on workouts.id = programDays.templateID and not workouts.deleted
+where programDays.programID = $1 and programDays.userID = $2
+order by programDays.week, programDays.day, programDays.id;
+
+-- name: DeleteProgram :execrows
+delete from Programs where id = $1 and userID = $2;
+
+-- name: DeleteProgramDays :exec
+delete from ProgramDays where programID = $1 and userID = $2;
+
+-- name: CreateEnrollment :one
+insert into Enrollments (userID, programID, startDate, mode)
+values ($1, $2, $3, $4) returning *;
+
+-- name: GetActiveEnrollment :one
+select * from Enrollments where userID = $1 and active;
+
+-- name: EndEnrollments :exec
+-- (ends the user's enrollment in any program, or in the program when it isn't 0)
+update Enrollments set active = false
+where userID = $1 and active and ($2::int = 0 or programID = $2);
+
+-- name: GetProgramLogs :many
+select * from ProgramLogs where enrollmentID = $1 and userID = $2;
+
+-- name: CreateProgramLog :execrows
+insert into ProgramLogs (userID, enrollmentID, dayID, workoutID, date)
+values ($1, $2, $3, $4, $5)
+on conflict (enrollmentID, dayID) do nothing;
+
+-- name: HardDeletePrograms :exec
+delete from Programs where userID = $1;
+
+-- name: HardDeleteProgramDays :exec
+delete from ProgramDays where userID = $1;
+
+-- name: HardDeleteEnrollments :exec
+delete from Enrollments where userID = $1;
+
+-- name: HardDeleteProgramLogs :exec
+delete from ProgramLogs where userID = $1;
+
+-- name: SaveTrack :exec
+insert into Tracks (userID, workoutID, exerciseID, points) values ($1, $2, $3, $4)
+on conflict (exerciseID) do update set points = excluded.points;
+
+-- name: GetTrack :one
+select points from Tracks where exerciseID = $1 and userID = $2;
+
+-- name: HardDeleteTracks :exec
+delete from Tracks where userID = $1;
+
+-- name: GetBodyWeight :one
+-- (the weight closest to the date, preferring the ones before it)
+select value from records
+where userID = $1 and recordType = 'weight' and not deleted
+order by date > $2, abs(date - $2)
+limit 1;
+
+-- name: GetCaloriesBurned :many
+select date, sum(calories)::float as calories from workouts
+where userID = $1 and not deleted and not isTemplate
+  and date >= sqlc.arg('fromDate') and date <= sqlc.arg('toDate')
+group by date order by date;
+
+-- name: GetWeightsAround :one
+-- (the dates of the closest weights before and after
+-- the date, null when there aren't any)
+select
+  (select max(date) from records
+   where userID = $1 and recordType = 'weight' and not deleted and date < $2)::bigint as weightBefore,
+  (select min(date) from records
+   where userID = $1 and recordType = 'weight' and not deleted and date > $2)::bigint as weightAfter;
+
+-- name: GetWorkoutsBetween :many
+-- (the workouts from the first date up to, but not including, the second)
+select * from workouts
+where userID = $1 and not deleted and not isTemplate
+  and date >= sqlc.arg('fromDate') and date < sqlc.arg('toDate');
+
+-- name: GetWorkoutsWithoutCalories :many
+select * from workouts where not deleted and not isTemplate and calories = 0;
+
+-- name: SetWorkoutCalories :exec
+update workouts set calories = $1, lastModified = $2, version = version + 1
+where id = $3 and userID = $4;
+
+-- name: GetWorkoutPage :many
+-- (the workouts in the date range after the date and id, in order)
+select * from workouts
+where userID = $1 and not deleted and not isTemplate
+  and date >= sqlc.arg('fromDate') and date <= sqlc.arg('toDate')
+  and (date, id) > (sqlc.arg('afterDate')::bigint, sqlc.arg('afterID')::int)
+order by date, id limit $2;
+
+-- name: GetExercisesOfWorkouts :many
+select * from exercises
+where userID = $1 and workoutID = any(sqlc.arg('ids')::int[]) and not deleted
+order by workoutID, position, id;
+
+-- name: GetSetsOfWorkouts :many
+select * from sets where userID = $1 and workoutID = any(sqlc.arg('ids')::int[])
+order by exerciseID, position;
+
+-- name: FindWorkout :one
+-- (finds the workout with the name on the date that started at the start
+-- time, or whose start time isn't known)
+select id from workouts
+where userID = $1 and date = $2 and lower(name) = lower($3) and startTime in (0, $4)
+  and not deleted and not isTemplate
+limit 1;
+
+-- name: SetWorkoutStartTime :exec
+update workouts set startTime = $1 where id = $2 and userID = $3;
+
+-- name: BackfillSets :exec
+-- (turns the reps of the exercises that were made before there were sets
+-- into sets, which all have the exercise's weight. the reps of exercises
+-- with sets are always the reps of their sets)
+insert into sets (userID, workoutID, exerciseID, position, weight, reps)
+select exercises.userID, exercises.workoutID, exercises.id, r.position - 1, exercises.weight, r.reps
+from exercises, unnest(exercises.reps) with ordinality as r(reps, position)
+where not exists (select 1 from sets where sets.exerciseID = exercises.id);
+
+-- name: StartBackfill :execrows
+-- (marks the backfill as run, affecting no rows when it already was)
+insert into Backfills (name) values ($1) on conflict do nothing;
@@ -92,3 +92,290 @@ create table if not exists Records (
 
     unique (userID, recordType, date)
 );
+
+-- token buckets used for rate limiting, see ratelimit.go
+create table if not exists RateLimits (
+    bucket text primary key,
+    fullAt float not null -- unix timestamp in seconds
+);
+
+-- every change to a row bumps its version, and updates say which version
+-- they're based on, so changes based on an outdated copy can be rejected
+alter table Settings add column if not exists version bigint default 1 not null;
+alter table Meals add column if not exists version bigint default 1 not null;
+alter table Workouts add column if not exists version bigint default 1 not null;
+alter table Records add column if not exists version bigint default 1 not null;
+
+-- every change to a user's data is logged with the next number in the
+-- user's change sequence, so clients can ask for the changes made after
+-- the last one they've seen. only the last change to each entity is kept.
+alter table Users add column if not exists changeSeq bigint default 0 not null;
+alter table Records add column if not exists id serial;
+
+create table if not exists Changes (
+    userID int not null,
+    seq bigint not null,
+    entity text not null, -- meal, workout, food, record or settings
+    entityID int not null,
+
+    primary key (userID, entity, entityID)
+);
+create index if not exists changes_by_seq on Changes (userID, seq);
+
+-- called with the name of the entity and the column holding its id. rows
+-- that are part of another entity, like the exercises and sets of a workout,
+-- are also called with 'quiet', so they're logged as a change to the entity
+-- without notifying. every change to them bumps the entity's version, which
+-- notifies once, with the version the change made.
+create or replace function log_change() returns trigger as $$
+declare
+    next bigint;
+begin
+    update users set changeSeq = changeSeq + 1
+    where id = NEW.userID returning changeSeq into next;
+
+    insert into changes (userID, seq, entity, entityID)
+    values (NEW.userID, next, TG_ARGV[0], (to_jsonb(NEW) ->> TG_ARGV[1])::int)
+    on conflict (userID, entity, entityID) do update set seq = excluded.seq;
+
+    -- let the backend instances know, once the change is committed
+    if TG_NARGS < 3 or TG_ARGV[2] <> 'quiet' then
+        perform pg_notify('changes', json_build_object(
+            'userID', NEW.userID,
+            'entity', TG_ARGV[0],
+            'id', (to_jsonb(NEW) ->> TG_ARGV[1])::int,
+            'version', coalesce((to_jsonb(NEW) ->> 'version')::bigint, 0)
+        )::text);
+    end if;
+    return null;
+end;
+$$ language plpgsql;
+
+create or replace trigger log_settings_change after insert or update on Settings
+for each row execute function log_change('settings', 'id');
+create or replace trigger log_food_change after insert or update on Foods
+for each row execute function log_change('food', 'id');
+create or replace trigger log_meal_change after insert or update on Meals
+for each row execute function log_change('meal', 'id');
+create or replace trigger log_workout_change after insert or update on Workouts
+for each row execute function log_change('workout', 'id');
+create or replace trigger log_exercise_change after insert or update on Exercises
+for each row execute function log_change('workout', 'workoutid', 'quiet');
+create or replace trigger log_record_change after insert or update on Records
+for each row execute function log_change('record', 'id');
+
+-- log the rows that were made before there was a change log
+insert into changes (userID, seq, entity, entityID)
+select userID, row_number() over (partition by userID order by entity, entityID), entity, entityID
+from (
+    select userID, 'settings' as entity, id as entityID from settings
+    union all select userID, 'food', id from foods
+    union all select userID, 'meal', id from meals
+    union all select userID, 'workout', id from workouts
+    union all select userID, 'record', id from records
+) existing
+where not exists (select 1 from changes);
+
+update users set changeSeq = (select max(seq) from changes where userID = users.id)
+where changeSeq = 0 and exists (select 1 from changes where userID = users.id);
+
+-- the last change each of a user's devices has pulled, so soft deleted rows
+-- are only hard deleted once every device knows they were deleted
+create table if not exists Devices (
+    userID int not null,
+    deviceID text not null,
+    seq bigint not null,
+    lastSeen bigint not null, -- unix timestamp in seconds
+
+    primary key (userID, deviceID)
+);
+
+-- the last change to a soft deleted row that's been hard deleted, clients
+-- that haven't pulled it need to resync everything
+alter table Users add column if not exists purgedSeq bigint default 0 not null;
+alter table Users add column if not exists purgedAt bigint default 0 not null;
+
+-- the responses to requests with an Idempotency-Key header, so retried
+-- requests get the original response instead of creating duplicates
+create table if not exists IdempotencyKeys (
+    userID int not null,
+    key text not null,
+    route text not null,
+    requestHash text not null,
+    status int default 0 not null, -- 0 while the request's being handled
+    response text default '' not null,
+    createdAt bigint not null, -- unix timestamp in seconds
+
+    primary key (userID, key)
+);
+
+-- the order of the exercises in their workout
+alter table Exercises add column if not exists position int default 0 not null;
+
+-- number the exercises that were made before there were positions in the
+-- order they were made, which only has to be done for workouts that have
+-- more than one exercise and where they're all still at position 0
+update exercises set position = numbered.position
+from (
+    select id, row_number() over (partition by workoutID order by id) - 1 as position
+    from exercises
+    where workoutID in (
+        select workoutID from exercises
+        group by workoutID having count(*) > 1 and max(position) = 0
+    )
+) numbered
+where exercises.id = numbered.id;
+
+-- the sets of strength exercises. they're always sent as part of their
+-- exercise, so they're replaced instead of updated when the exercise changes
+create table if not exists Sets (
+    id serial primary key,
+    userID int not null,
+    workoutID int not null,
+    exerciseID int not null,
+    position int not null, -- the order of the set in the exercise
+
+    weight numeric not null,
+    reps int not null,
+    rpe float default 0 not null, -- rate of perceived exertion, 0 when not recorded
+    restSeconds int default 0 not null,
+    setType text default 'normal' not null -- normal, warmup, drop or failure
+);
+create index if not exists sets_by_workout on Sets (userID, workoutID);
+
+create or replace trigger log_set_change after insert or update on Sets
+for each row execute function log_change('workout', 'workoutid', 'quiet');
+
+-- the exercises that can be logged. the bundled ones (see data/exercises.json)
+-- have a userID of 0, custom ones have the userID of the user who made them
+create table if not exists CatalogExercises (
+    id serial primary key,
+    userID int default 0 not null,
+
+    name text not null,
+    aliases text[] default '{}' not null,
+    primaryMuscles text[] not null,
+    secondaryMuscles text[] not null,
+    equipment text not null,
+    exerciseType text not null -- strength or cardio
+);
+create unique index if not exists catalog_exercise_names
+on CatalogExercises (userID, lower(name));
+
+-- the catalog exercise an exercise is, 0 when it isn't in the catalog
+alter table Exercises add column if not exists catalogID int default 0 not null;
+
+-- the template a workout was started from, 0 when it wasn't
+alter table Workouts add column if not exists templateID int default 0 not null;
+
+-- the personal records set by the exercises in workouts. weights are in kg
+create table if not exists PersonalRecords (
+    id serial primary key,
+    userID int not null,
+    workoutID int not null,
+    exerciseID int not null,
+    catalogID int not null,
+    name text not null,
+
+    kind text not null, -- weight, e1rm-epley, e1rm-brzycki, reps or duration
+    value float not null,
+    previous float not null, -- the record that was beaten, 0 if there wasn't one
+    weight float not null, -- of the set that set the record
+    reps int not null,
+    date bigint not null -- of the workout
+);
+create index if not exists personal_records_by_user on PersonalRecords (userID, id);
+
+-- for the analytics, which read workouts by date
+create index if not exists workouts_by_date on Workouts (userID, date);
+
+-- how the weights of a template's exercise progress from one workout to the
+-- next, as json. null when the last weights are reused
+alter table Exercises add column if not exists progression jsonb;
+
+-- programs schedule templates across weeks
+create table if not exists Programs (
+    id serial primary key,
+    userID int not null,
+    name text not null,
+    description text default '' not null,
+    weeks int not null
+);
+
+create table if not exists ProgramDays (
+    id serial primary key,
+    userID int not null,
+    programID int not null,
+    week int not null, -- from 0
+    day int not null, -- the days since the start of the week, from 0 to 6
+    templateID int not null
+);
+create index if not exists program_days_by_program on ProgramDays (programID);
+
+-- users follow one program at a time, starting on a date
+create table if not exists Enrollments (
+    id serial primary key,
+    userID int not null,
+    programID int not null,
+    startDate bigint not null,
+    mode text not null, -- calendar or sequential
+    active boolean default true not null
+);
+create unique index if not exists active_enrollments on Enrollments (userID) where active;
+
+-- the program days that were done or skipped
+create table if not exists ProgramLogs (
+    id serial primary key,
+    userID int not null,
+    enrollmentID int not null,
+    dayID int not null,
+    workoutID int not null, -- 0 when the day was skipped
+    date bigint not null
+);
+create unique index if not exists program_logs_by_day on ProgramLogs (enrollmentID, dayID);
+
+-- the metrics of cardio exercises
+alter table Exercises add column if not exists distance float default 0 not null; -- in meters
+alter table Exercises add column if not exists elevationGain float default 0 not null; -- in meters
+alter table Exercises add column if not exists avgHeartRate int default 0 not null; -- 0 when unknown
+alter table Exercises add column if not exists maxHeartRate int default 0 not null;
+
+-- the gps tracks cardio exercises were recorded with, as json
+create table if not exists Tracks (
+    id serial primary key,
+    userID int not null,
+    workoutID int not null,
+    exerciseID int not null,
+    points jsonb not null
+);
+create unique index if not exists tracks_by_exercise on Tracks (exerciseID);
+
+-- the metabolic equivalent of an exercise, 0 to use the default of its type
+alter table CatalogExercises add column if not exists met float default 0 not null;
+
+-- the estimated energy burned in a workout, in kcal
+alter table Workouts add column if not exists calories float default 0 not null;
+
+-- exercises can be grouped into supersets and circuits, done in rounds. the
+-- groups are stored with their workout as json, null when there aren't any,
+-- and each exercise has the number of its group, 0 when it isn't in one
+alter table Workouts add column if not exists groups jsonb;
+alter table Exercises add column if not exists groupNumber int default 0 not null;
+
+-- set weights are exact, so weights like 2.5 lbs add up without rounding
+-- errors. the sets made before were stored as floats
+do $$ begin
+    if exists (select 1 from information_schema.columns
+               where table_name = 'sets' and column_name = 'weight' and data_type <> 'numeric') then
+        alter table Sets alter column weight type numeric;
+    end if;
+end $$;
+
+-- when an imported workout started according to its file, in milliseconds,
+-- so importing the file again finds it. 0 for workouts that weren't imported
+alter table Workouts add column if not exists startTime bigint default 0 not null;
+
+-- the one-off changes to the data that have been run at startup, by name
+create table if not exists Backfills (
+    name text primary key
+);
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// An error that's safe to show the client
+type mutationError string
+
+func (e mutationError) Error() string { return string(e) }
+
+// Apply the changes the client made while offline, in order, in one
+// transaction. Each change is applied in its own savepoint, so a change that
+// fails doesn't stop the rest from being applied. The response has the result
+// of each change, including the server id of every entity that was created.
+//
+// Entities created by earlier changes in the batch can be referred to by
+// their client id: either as the client id of an update or delete without an
+// id, or through refs, which maps fields of the data to client ids. For
+// example, a meal logged with a food created in the same batch would have
+// refs set to {"foodID": "<the food's client id>"}.
+//
+// Updates and deletes of entities with versions are rejected when the version
+// in their data isn't the server's version, with the error "conflict" and the
+// server's copy of the entity in the result.
+func (a *API) SyncPush(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	req, ok := parseRequest[SyncPushJSON](w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := a.conn.Begin(a.ctx)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't apply changes")
+		return
+	}
+	defer tx.Rollback(a.ctx)
+
+	ids := map[string]int32{} // server ids by client id
+	results := []MutationResultJSON{}
+	for _, mutation := range req.Mutations {
+		savepoint, err := tx.Begin(a.ctx)
+		if err != nil {
+			respond(w, http.StatusInternalServerError, "Couldn't apply changes")
+			return
+		}
+
+		result := MutationResultJSON{ClientID: mutation.ClientID}
+		id, err := applyMutation(a.ctx, a.queries.WithTx(savepoint), userID, mutation, ids)
+		if err != nil {
+			if err := savepoint.Rollback(a.ctx); err != nil {
+				respond(w, http.StatusInternalServerError, "Couldn't apply changes")
+				return
+			}
+
+			var message mutationError
+			var conflict conflictError
+			if errors.As(err, &message) {
+				result.Error = message.Error()
+			} else if errors.As(err, &conflict) {
+				result.Error = conflict.Error()
+				result.Current, err = json.Marshal(conflict.current)
+				if err != nil {
+					respond(w, http.StatusInternalServerError, "Couldn't apply changes")
+					return
+				}
+			} else if err == pgx.ErrNoRows {
+				result.Error = fmt.Sprintf("%s not found", mutation.Entity)
+			} else {
+				result.Error = fmt.Sprintf("couldn't %s %s", mutation.Op, mutation.Entity)
+			}
+			results = append(results, result)
+			continue
+		}
+
+		if err := savepoint.Commit(a.ctx); err != nil {
+			respond(w, http.StatusInternalServerError, "Couldn't apply changes")
+			return
+		}
+		if mutation.Op == "create" && len(mutation.ClientID) > 0 {
+			ids[mutation.ClientID] = id
+		}
+		result.ID = id
+		result.Applied = true
+		results = append(results, result)
+	}
+
+	if err := tx.Commit(a.ctx); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't apply changes")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{"results": results})
+}
+
+// Replace the fields named in the mutation's refs with the
+// server ids of the entities their client ids refer to
+func resolveRefs(mutation MutationJSON, ids map[string]int32) (json.RawMessage, error) {
+	if len(mutation.Refs) == 0 {
+		return mutation.Data, nil
+	}
+
+	fields := map[string]any{}
+	if err := json.Unmarshal(mutation.Data, &fields); err != nil {
+		return nil, mutationError("data isn't a json object")
+	}
+	for field, clientID := range mutation.Refs {
+		id, ok := ids[clientID]
+		if !ok {
+			return nil, mutationError(fmt.Sprintf("%s refers to unknown client id %s", field, clientID))
+		}
+		fields[field] = id
+	}
+	return json.Marshal(fields)
+}
+
+func decodeMutation[T any](data json.RawMessage) (T, error) {
+	var value T
+	if err := json.Unmarshal(data, &value); err != nil {
+		return value, mutationError("invalid data")
+	}
+	return value, nil
+}
+
+// The version a delete is based on, from data like {"version": 3}.
+// Deletes without data aren't based on a version.
+func deleteVersion(data json.RawMessage) (int64, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return 0, nil
+	}
+	base, err := decodeMutation[struct {
+		Version int64 `json:"version"`
+	}](data)
+	return base.Version, err
+}
+
+// Apply the mutation and return the server id of the entity it changed, if it has one
+func applyMutation(
+	ctx context.Context, q *database.Queries, userID int32,
+	mutation MutationJSON, ids map[string]int32,
+) (int32, error) {
+	data, err := resolveRefs(mutation, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	id := mutation.ID
+	if id == 0 && mutation.Op != "create" {
+		id = ids[mutation.ClientID]
+	}
+
+	switch fmt.Sprintf("%s %s", mutation.Entity, mutation.Op) {
+	case "meal create":
+		meal, err := decodeMutation[MealJSON](data)
+		if err != nil {
+			return 0, err
+		}
+		return createMeal(ctx, q, userID, meal)
+
+	case "meal update":
+		patch, err := decodeMutation[MealPatchJSON](data)
+		if err != nil {
+			return 0, err
+		}
+		_, err = patchMeal(ctx, q, userID, id, patch)
+		return id, err
+
+	case "meal delete":
+		version, err := deleteVersion(data)
+		if err != nil {
+			return 0, err
+		}
+		return id, deleteMealTx(ctx, q, userID, id, version)
+
+	case "workout create":
+		workout, err := decodeMutation[WorkoutJSON](data)
+		if err != nil {
+			return 0, err
+		}
+		workout, err = createWorkout(ctx, q, userID, workout)
+		return workout.ID, err
+
+	case "workout update":
+		patch, err := decodeMutation[WorkoutPatchJSON](data)
+		if err != nil {
+			return 0, err
+		}
+		_, err = patchWorkout(ctx, q, userID, id, patch)
+		return id, err
+
+	case "workout delete":
+		version, err := deleteVersion(data)
+		if err != nil {
+			return 0, err
+		}
+		return id, deleteWorkoutTx(ctx, q, userID, id, version)
+
+	case "food create":
+		food, err := decodeMutation[FoodJSON](data)
+		if err != nil {
+			return 0, err
+		}
+		return createFood(ctx, q, userID, food)
+
+	case "record create", "record update":
+		record, err := decodeMutation[RecordJSON](data)
+		if err != nil {
+			return 0, err
+		}
+		if record.IsPeriod {
+			_, err = setPeriod(ctx, q, userID, record.Date, record.Value != 0, record.Version)
+			return 0, err
+		}
+		_, err = setWeightTx(ctx, q, userID, record.Date, record.Value, record.Version)
+		return 0, err
+
+	case "record delete":
+		record, err := decodeMutation[RecordJSON](data)
+		if err != nil {
+			return 0, err
+		}
+		if record.IsPeriod {
+			_, err = setPeriod(ctx, q, userID, record.Date, false, record.Version)
+			return 0, err
+		}
+		return 0, deleteWeightTx(ctx, q, userID, record.Date, record.Version)
+
+	case "settings update":
+		settings, err := decodeMutation[SettingsJSON](data)
+		if err != nil {
+			return 0, err
+		}
+		_, err = saveSettings(ctx, q, userID, settings)
+		return 0, err
+	}
+
+	return 0, mutationError(fmt.Sprintf("can't %s %s", mutation.Op, mutation.Entity))
+}
+
+const (
+	defaultPullLimit = 500
+	maxPullLimit     = 1000
+)
+
+// Cursors are opaque to clients, so the way they're encoded can change
+func encodeCursor(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "seq:%d", seq))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	if len(cursor) == 0 {
+		return 0, nil // start from the first change
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	var seq int64
+	_, err = fmt.Sscanf(string(decoded), "seq:%d", &seq)
+	return seq, err
+}
+
+// Get the entities that changed after the cursor, in the order they
+// changed, limit at a time. Every change to a user's data is logged with the
+// next number in the user's change sequence (see schema.sql), and the cursor
+// holds the number of the last change the client has seen, so unlike
+// UpdatedUserData, nothing is missed or sent twice. Without a cursor,
+// everything is sent. Clients send a device id that's unique to the
+// device, so tombstones are kept until every device has pulled them.
+func (a *API) SyncPull(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	cursor, ok := getOptionalQuery(w, r, "cursor", "")
+	if !ok {
+		return
+	}
+	limit, ok := getOptionalQuery[int64](w, r, "limit", defaultPullLimit)
+	if !ok {
+		return
+	}
+	device, ok := getOptionalQuery(w, r, "device", "")
+	if !ok {
+		return
+	}
+
+	seq, err := decodeCursor(cursor)
+	if err != nil {
+		respond(w, http.StatusBadRequest, "bad request: invalid cursor")
+		return
+	}
+
+	// the deletes made before the cursor that were purged
+	// can't be sent, so the client has to start over
+	purged, err := a.queries.GetPurged(a.ctx, userID)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get changes")
+		return
+	}
+	if seq > 0 && seq < purged.Purgedseq {
+		respond(w, http.StatusGone, "full resync required")
+		return
+	}
+
+	// the device has pulled everything before the cursor, so tombstones
+	// before it can be purged once the user's other devices have too
+	if len(device) > 0 {
+		if err := a.queries.SetDeviceSeq(a.ctx, database.SetDeviceSeqParams{
+			Userid: userID, Deviceid: device, Seq: seq, Lastseen: time.Now().Unix(),
+		}); err != nil {
+			respond(w, http.StatusInternalServerError, "Couldn't get changes")
+			return
+		}
+	}
+
+	// read the changes and the entities from the same snapshot
+	tx, err := a.conn.BeginTx(a.ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get changes")
+		return
+	}
+	defer tx.Rollback(a.ctx)
+
+	limit = min(max(limit, 1), maxPullLimit)
+	response, err := pullChanges(a.ctx, a.queries.WithTx(tx), userID, seq, int32(limit))
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get changes")
+		return
+	}
+
+	if err := tx.Commit(a.ctx); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't get changes")
+		return
+	}
+	respond(w, http.StatusOK, response)
+}
+
+func pullChanges(
+	ctx context.Context, q *database.Queries, userID int32, seq int64, limit int32,
+) (SyncPullJSON, error) {
+	response := SyncPullJSON{
+		Workouts: []WorkoutJSON{}, Foods: []FoodJSON{}, Meals: []MealJSON{},
+		Records: []RecordJSON{}, Cursor: encodeCursor(seq),
+	}
+
+	// get one more change than the limit to know if there are more
+	changes, err := q.GetChanges(ctx, database.GetChangesParams{
+		Userid: userID, Seq: seq, Limit: limit + 1})
+	if err != nil {
+		return response, err
+	}
+	if len(changes) > int(limit) {
+		response.HasMore = true
+		changes = changes[:limit]
+	}
+
+	ids := map[string][]int32{} // ids of the changed entities by entity
+	for _, change := range changes {
+		ids[change.Entity] = append(ids[change.Entity], change.Entityid)
+		response.Cursor = encodeCursor(change.Seq)
+	}
+
+	workoutRows, err := q.GetWorkoutsByID(ctx, database.GetWorkoutsByIDParams{
+		Userid: userID, Ids: ids["workout"]})
+	if err != nil {
+		return response, err
+	}
+	for _, row := range workoutRows {
+		workout, err := getWorkout(ctx, q, row, pgtype.Bool{Bool: false, Valid: true})
+		if err != nil {
+			return response, err
+		}
+		response.Workouts = append(response.Workouts, workout)
+	}
+
+	// send the foods of the meals too, since they can be other users' foods
+	mealRows, err := q.GetMealsByID(ctx, database.GetMealsByIDParams{
+		Userid: userID, Ids: ids["meal"]})
+	if err != nil {
+		return response, err
+	}
+	foodIDs := ids["food"]
+	for _, row := range mealRows {
+		response.Meals = append(response.Meals, mealRowToJson(row))
+		foodIDs = append(foodIDs, row.Foodid)
+	}
+
+	foodRows, err := q.GetFoodsByID(ctx, foodIDs)
+	if err != nil {
+		return response, err
+	}
+	for _, row := range foodRows {
+		response.Foods = append(response.Foods, foodRowToJson(row))
+	}
+
+	recordRows, err := q.GetRecordsByID(ctx, database.GetRecordsByIDParams{
+		Userid: userID, Ids: ids["record"]})
+	if err != nil {
+		return response, err
+	}
+	for _, row := range recordRows {
+		response.Records = append(response.Records, recordRowToJson(row))
+	}
+
+	if len(ids["settings"]) > 0 {
+		row, err := q.GetUserSettings(ctx, userID)
+		if err != nil {
+			return response, err
+		}
+		settings, err := settingsRowToJson(row)
+		if err != nil {
+			return response, err
+		}
+		response.Settings = &settings
+	}
+
+	return response, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Deleted meals, workouts, exercises and records are only soft deleted, so
+// that devices pulling changes find out they were deleted. Once they're older
+// than the retention window and every device the user syncs with has pulled
+// past them, they're hard deleted. Devices that haven't synced in a long time
+// are forgotten so they don't keep tombstones around forever; if they come
+// back, their cursor predates the purge and they have to resync everything.
+type TombstonePolicy struct {
+	retention    time.Duration
+	deviceExpiry time.Duration
+}
+
+// Read the policy from TOMBSTONE_RETENTION and DEVICE_EXPIRY,
+// which are durations like "720h"
+func NewTombstonePolicy() (TombstonePolicy, error) {
+	retention, err := envDuration("TOMBSTONE_RETENTION", 30*24*time.Hour)
+	if err != nil {
+		return TombstonePolicy{}, err
+	}
+	expiry, err := envDuration("DEVICE_EXPIRY", 180*24*time.Hour)
+	if err != nil {
+		return TombstonePolicy{}, err
+	}
+	return TombstonePolicy{retention, expiry}, nil
+}
+
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return fallback, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// Hard delete the tombstones that every device has pulled,
+// returning how many were deleted
+func (a *API) purgeTombstones(policy TombstonePolicy) (int64, error) {
+	tx, err := a.conn.Begin(a.ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(a.ctx)
+	qtx := a.queries.WithTx(tx)
+
+	now := time.Now()
+	if err := qtx.DeleteStaleDevices(a.ctx, now.Add(-policy.deviceExpiry).Unix()); err != nil {
+		return 0, err
+	}
+
+	// exercises go first, since they're purged based on their workout's change
+	before := pgtype.Int8{Int64: now.Add(-policy.retention).Unix(), Valid: true}
+	exercises, err := qtx.PurgeDeletedExercises(a.ctx,
+		database.PurgeDeletedExercisesParams{Before: before, Now: now.Unix()})
+	if err != nil {
+		return 0, err
+	}
+	workouts, err := qtx.PurgeDeletedWorkouts(a.ctx,
+		database.PurgeDeletedWorkoutsParams{Before: before, Now: now.Unix()})
+	if err != nil {
+		return 0, err
+	}
+	meals, err := qtx.PurgeDeletedMeals(a.ctx,
+		database.PurgeDeletedMealsParams{Before: before, Now: now.Unix()})
+	if err != nil {
+		return 0, err
+	}
+	records, err := qtx.PurgeDeletedRecords(a.ctx,
+		database.PurgeDeletedRecordsParams{Before: before, Now: now.Unix()})
+	if err != nil {
+		return 0, err
+	}
+
+	return exercises + workouts + meals + records, tx.Commit(a.ctx)
+}
+
+// Purge tombstones every so often
+func collectTombstones(logger *log.Logger, a *API, policy TombstonePolicy) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+			count, err := a.purgeTombstones(policy)
+			if err != nil {
+				logger.Printf("tombstone collection failed: %s\n", err.Error())
+			} else if count > 0 {
+				logger.Printf("purged %d tombstones\n", count)
+			}
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
@@ -28,13 +31,14 @@ func newUser(a *API, email string, hashedPassword string) (int32, error) {
 		return -1, err
 	}
 
-	if err := qtx.SetUserSettings(a.ctx, database.SetUserSettingsParams{
+	if _, err := qtx.SetUserSettings(a.ctx, database.SetUserSettingsParams{
 		Userid:       id,
 		Mealtags:     []string{"Breakfast", "Lunch", "Dinner"},
 		Useimperial:  true,
 		Trackperiod:  true,
 		Darkmode:     false,
 		Macrotargets: encoded,
+		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
 	}); err != nil {
 		return -1, err
 	}
@@ -42,16 +46,27 @@ func newUser(a *API, email string, hashedPassword string) (int32, error) {
 	return id, tx.Commit(a.ctx)
 }
 
+// Times after this many seconds since the epoch, in the year 5138,
+// are taken to be in milliseconds
+const maxUnixSeconds = 1e11
+
+// Superseded by SyncPull, since changes made in the same second as
+// the time can be missed, and clock skew makes the time unreliable
 func (a *API) UpdatedUserData(w http.ResponseWriter, r *http.Request) {
 	// get all user data that has been updated after a certain timestamp
 	userID, ok := parseJWT(a, w, r)
 	if !ok {
 		return
 	}
-	time, ok := getQuery[int64](w, r, "time")
+	since, ok := getQuery[int64](w, r, "time")
 	if !ok {
 		return
 	}
+	// the app sends the time in milliseconds, while rows are
+	// modified at unix timestamps in seconds
+	if since > maxUnixSeconds {
+		since /= 1000
+	}
 	flag, ok := getQuery[string](w, r, "ignoreDeleted")
 	if !ok {
 		return
@@ -59,6 +74,17 @@ func (a *API) UpdatedUserData(w http.ResponseWriter, r *http.Request) {
 	// optionally only get data that hasn't been soft deleted
 	ignoreDeleted := pgtype.Bool{Valid: flag == "true", Bool: true}
 
+	// deletes made before the tombstones were purged can't be sent
+	purged, err := a.queries.GetPurged(a.ctx, userID)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "failed to fetch data")
+		return
+	}
+	if since > 0 && since < purged.Purgedat {
+		respond(w, http.StatusGone, "full resync required")
+		return
+	}
+
 	tx, err := a.conn.Begin(a.ctx)
 	if err != nil {
 		respond(w, http.StatusInternalServerError, "failed to fetch data")
@@ -69,7 +95,7 @@ func (a *API) UpdatedUserData(w http.ResponseWriter, r *http.Request) {
 
 	// get the user's workouts
 	workoutRows, err := txq.GetUpdatedWorkouts(a.ctx, database.GetUpdatedWorkoutsParams{
-		Lastmodified: pgtype.Int8{Int64: time, Valid: true},
+		Lastmodified: pgtype.Int8{Int64: since, Valid: true},
 		Userid:       userID, IgnoreDeleted: ignoreDeleted,
 	})
 	if err != nil {
@@ -88,7 +114,7 @@ func (a *API) UpdatedUserData(w http.ResponseWriter, r *http.Request) {
 
 	// get the user's meals and the foods associated to them
 	mealRows, err := txq.GetUpdatedMeals(a.ctx, database.GetUpdatedMealsParams{
-		Lastmodified: pgtype.Int8{Int64: time, Valid: true},
+		Lastmodified: pgtype.Int8{Int64: since, Valid: true},
 		Userid:       userID, IgnoreDeleted: ignoreDeleted,
 	})
 	if err != nil {
@@ -103,16 +129,13 @@ func (a *API) UpdatedUserData(w http.ResponseWriter, r *http.Request) {
 			respond(w, http.StatusInternalServerError, "failed to fetch foods")
 			return
 		}
-		meals = append(meals, MealJSON{
-			Deleted: row.Deleted, ID: row.ID, Date: row.Date, FoodID: row.Foodid,
-			MealTag: row.Mealtag, Servings: row.Servings, Unit: row.Unit,
-		})
+		meals = append(meals, mealRowToJson(row))
 		foods = append(foods, foodRowToJson(frow))
 	}
 
 	// get the user's records
 	recordRows, err := txq.GetUpdatedRecords(a.ctx, database.GetUpdatedRecordsParams{
-		Lastmodified: pgtype.Int8{Int64: time, Valid: true},
+		Lastmodified: pgtype.Int8{Int64: since, Valid: true},
 		Userid:       userID, IgnoreDeleted: ignoreDeleted,
 	})
 	if err != nil {
@@ -121,10 +144,7 @@ func (a *API) UpdatedUserData(w http.ResponseWriter, r *http.Request) {
 	}
 	records := []RecordJSON{}
 	for _, row := range recordRows {
-		records = append(records, RecordJSON{
-			Deleted: row.Deleted, IsPeriod: row.Recordtype == "period",
-			Date: row.Date, Value: row.Value,
-		})
+		records = append(records, recordRowToJson(row))
 	}
 
 	// always get settings
@@ -133,13 +153,8 @@ func (a *API) UpdatedUserData(w http.ResponseWriter, r *http.Request) {
 		respond(w, http.StatusInternalServerError, "failed to fetch settings")
 		return
 	}
-	settings := SettingsJSON{
-		MealTags:    row.Mealtags,
-		UseImperial: row.Useimperial,
-		TrackPeriod: row.Trackperiod,
-		DarkMode:    row.Darkmode,
-	}
-	if err := json.Unmarshal(row.Macrotargets, &settings.MacroTargets); err != nil {
+	settings, err := settingsRowToJson(row)
+	if err != nil {
 		respond(w, http.StatusInternalServerError, "failed to fetch settings")
 		return
 	}
@@ -169,25 +184,82 @@ func (a *API) UpdateUserSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	encoded, err := json.Marshal(settings.MacroTargets)
+	tx, err := a.conn.Begin(a.ctx)
 	if err != nil {
 		respond(w, http.StatusInternalServerError, "failed to update settings")
 		return
 	}
+	defer tx.Rollback(a.ctx)
 
-	if err := a.queries.SetUserSettings(a.ctx, database.SetUserSettingsParams{
+	settings, err = saveSettings(a.ctx, a.queries.WithTx(tx), userID, settings)
+	if respondConflict(w, err) {
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "failed to update settings")
+		return
+	}
+
+	if err := tx.Commit(a.ctx); err != nil {
+		respond(w, http.StatusInternalServerError, "failed to update settings")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{"settings": settings})
+}
+
+// Save the settings, unless they've changed since the version they're based
+// on. The calories of the workouts are estimated again when the units change,
+// since weights are recorded in them. Should be called in a transaction.
+func saveSettings(
+	ctx context.Context, q *database.Queries, userID int32, settings SettingsJSON,
+) (SettingsJSON, error) {
+	encoded, err := json.Marshal(settings.MacroTargets)
+	if err != nil {
+		return settings, err
+	}
+	previous, err := q.GetUserSettings(ctx, userID)
+	if err != nil && err != pgx.ErrNoRows {
+		return settings, err
+	}
+
+	base := baseVersion(settings.Version)
+	settings.Version, err = q.SetUserSettings(ctx, database.SetUserSettingsParams{
 		Userid:       userID,
 		Mealtags:     settings.MealTags,
 		Useimperial:  settings.UseImperial,
 		Trackperiod:  settings.TrackPeriod,
 		Darkmode:     settings.DarkMode,
 		Macrotargets: encoded,
-	}); err != nil {
-		respond(w, http.StatusInternalServerError, "failed to update settings")
-		return
+		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+		BaseVersion:  base,
+	})
+	if err == pgx.ErrNoRows {
+		row, err := q.GetUserSettings(ctx, userID)
+		if err != nil {
+			return settings, err
+		}
+		current, err := settingsRowToJson(row)
+		if err != nil {
+			return settings, err
+		}
+		return settings, conflictError{current}
 	}
+	if err != nil || previous.Useimperial == settings.UseImperial {
+		return settings, err
+	}
+	return settings, updateAllCalories(ctx, q, userID)
+}
 
-	respond(w, http.StatusOK, nil)
+func settingsRowToJson(row database.Setting) (SettingsJSON, error) {
+	settings := SettingsJSON{
+		MealTags:    row.Mealtags,
+		UseImperial: row.Useimperial,
+		TrackPeriod: row.Trackperiod,
+		DarkMode:    row.Darkmode,
+		Version:     row.Version,
+	}
+	err := json.Unmarshal(row.Macrotargets, &settings.MacroTargets)
+	return settings, err
 }
 
 func deleteUser(a *API, userID int32) error {
@@ -217,9 +289,42 @@ func deleteUser(a *API, userID int32) error {
 	if err := txq.HardDeleteExercises(a.ctx, userID); err != nil {
 		return err
 	}
+	if err := txq.HardDeleteSets(a.ctx, userID); err != nil {
+		return err
+	}
+	if err := txq.HardDeleteCatalogExercises(a.ctx, userID); err != nil {
+		return err
+	}
+	if err := txq.HardDeletePersonalRecords(a.ctx, userID); err != nil {
+		return err
+	}
+	if err := txq.HardDeletePrograms(a.ctx, userID); err != nil {
+		return err
+	}
+	if err := txq.HardDeleteProgramDays(a.ctx, userID); err != nil {
+		return err
+	}
+	if err := txq.HardDeleteEnrollments(a.ctx, userID); err != nil {
+		return err
+	}
+	if err := txq.HardDeleteProgramLogs(a.ctx, userID); err != nil {
+		return err
+	}
+	if err := txq.HardDeleteTracks(a.ctx, userID); err != nil {
+		return err
+	}
 	if err := txq.HardDeleteWorkouts(a.ctx, userID); err != nil {
 		return err
 	}
+	if err := txq.HardDeleteChanges(a.ctx, userID); err != nil {
+		return err
+	}
+	if err := txq.HardDeleteDevices(a.ctx, userID); err != nil {
+		return err
+	}
+	if err := txq.HardDeleteIdempotencyKeys(a.ctx, userID); err != nil {
+		return err
+	}
 
 	return tx.Commit(a.ctx)
 }
@@ -230,17 +335,34 @@ func (a *API) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// verify the user's password then delete all their data
-	user, err := a.queries.GetUser(a.ctx, database.GetUserParams{ID: userID})
-	if err != nil {
-		respond(w, http.StatusInternalServerError, "failed to delete user")
+	password, ok := getQuery[string](w, r, "password")
+	if !ok {
 		return
 	}
+	a.deleteAccount(w, userID, password)
+}
 
-	password, ok := getQuery[string](w, r, "password")
+// Like DeleteUser, but the password is sent in the request body so it
+// doesn't end up in access logs
+func (a *API) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	req, ok := parseRequest[PasswordJSON](w, r)
 	if !ok {
 		return
 	}
+	a.deleteAccount(w, userID, req.Password)
+}
+
+// Verify the user's password then delete all their data
+func (a *API) deleteAccount(w http.ResponseWriter, userID int32, password string) {
+	user, err := a.queries.GetUser(a.ctx, database.GetUserParams{ID: userID})
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "failed to delete user")
+		return
+	}
 
 	correct, err := verifyPassword(password, user.Password)
 	if err != nil || !correct {
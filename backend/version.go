@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Routes are grouped into api versions that are each mounted under their own
+// prefix (/v1, /v2, ...), so app builds pinned to an older version keep
+// working while newer builds move on. The routes from before versioning are
+// also served without a prefix, with headers telling clients to move on.
+
+type route struct {
+	pattern string // "METHOD /path", relative to the version prefix
+	handler http.HandlerFunc
+	legacy  bool // also served without a prefix, like it was before versioning
+}
+
+type apiVersion struct {
+	prefix string
+	routes []route
+}
+
+// When the unprefixed routes were deprecated, and when they'll be removed
+var (
+	legacyDeprecation = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	legacySunset      = time.Date(2027, time.October, 19, 0, 0, 0, 0, time.UTC)
+)
+
+// Tell clients that the route is going away and what to use instead.
+// See RFC 9745 (Deprecation), RFC 8594 (Sunset) and RFC 8288 (Link).
+func deprecated(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyDeprecation.Unix()))
+		w.Header().Set("Sunset", legacySunset.Format(http.TimeFormat))
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		next(w, r)
+	}
+}
+
+func mountVersions(mux *http.ServeMux, versions []apiVersion) {
+	for _, version := range versions {
+		for _, r := range version.routes {
+			method, path, _ := strings.Cut(r.pattern, " ")
+			versioned := version.prefix + path
+			mux.HandleFunc(fmt.Sprintf("%s %s", method, versioned), r.handler)
+
+			if r.legacy {
+				mux.HandleFunc(r.pattern, deprecated(versioned, r.handler))
+			}
+		}
+	}
+}
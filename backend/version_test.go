@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMountVersions(t *testing.T) {
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}
+	}
+	mux := http.NewServeMux()
+	mountVersions(mux, []apiVersion{
+		{"/v1", []route{{"GET /things", handler("v1"), true}}},
+		{"/v2", []route{{"GET /things", handler("v2"), false}}},
+	})
+
+	tests := []struct {
+		path       string
+		body       string
+		deprecated bool
+	}{
+		{"/things", "v1", true},
+		{"/v1/things", "v1", false},
+		{"/v2/things", "v2", false},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest("GET", test.path, nil))
+		if recorder.Code != http.StatusOK || recorder.Body.String() != test.body {
+			t.Errorf("GET %s: got %d %q, want 200 %q",
+				test.path, recorder.Code, recorder.Body.String(), test.body)
+		}
+
+		headers := recorder.Header()
+		if !test.deprecated {
+			if headers.Get("Deprecation") != "" {
+				t.Errorf("GET %s shouldn't be deprecated", test.path)
+			}
+			continue
+		}
+		want := map[string]string{
+			"Deprecation": fmt.Sprintf("@%d", legacyDeprecation.Unix()),
+			"Sunset":      legacySunset.Format(http.TimeFormat),
+			"Link":        `</v1/things>; rel="successor-version"`,
+		}
+		for name, value := range want {
+			if headers.Get(name) != value {
+				t.Errorf("GET %s: %s is %q, want %q", test.path, name, headers.Get(name), value)
+			}
+		}
+	}
+}
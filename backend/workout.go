@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/aabiji/logbuddy/database"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
@@ -28,86 +33,726 @@ func (a *API) CreateWorkout(w http.ResponseWriter, r *http.Request) {
 	defer tx.Rollback(a.ctx)
 	qtx := a.queries.WithTx(tx)
 
-	response := req
-	response.ID, err = qtx.CreateWorkout(a.ctx, database.CreateWorkoutParams{
-		Userid:     userID,
-		Name:       req.Name,
-		Notes:      req.Notes,
-		Date:       req.Date,
-		Istemplate: req.IsTemplate,
-	})
+	response, err := createWorkout(a.ctx, qtx, userID, req)
+	var message mutationError
+	if errors.As(err, &message) {
+		respond(w, http.StatusBadRequest, message.Error())
+		return
+	}
 	if err != nil {
 		respond(w, http.StatusInternalServerError, "Couldn't create workout")
 		return
 	}
 
+	if err := tx.Commit(a.ctx); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't create workout")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{"workout": response})
+}
+
+// Create the workout and its exercises, returning
+// the workout with the ids filled in. Should be
+// called in a transaction.
+func createWorkout(
+	ctx context.Context, q *database.Queries, userID int32, req WorkoutJSON,
+) (WorkoutJSON, error) {
+	response, err := insertWorkout(ctx, q, userID, req)
+	if err != nil {
+		return response, err
+	}
+	return response, updateRecords(ctx, q, userID, response)
+}
+
+// Like createWorkout, but without finding the personal records, so
+// they can be found once for many workouts. Should be called in a
+// transaction.
+func insertWorkout(
+	ctx context.Context, q *database.Queries, userID int32, req WorkoutJSON,
+) (WorkoutJSON, error) {
+	if req.TemplateID != 0 {
+		template, err := q.GetWorkout(ctx, database.GetWorkoutParams{
+			ID: req.TemplateID, Userid: userID,
+		})
+		if err == pgx.ErrNoRows || (err == nil && (!template.Istemplate || template.Deleted)) {
+			return req, mutationError(fmt.Sprintf("template %d doesn't exist", req.TemplateID))
+		}
+		if err != nil {
+			return req, err
+		}
+	}
+
+	var err error
+	response := req
+	for i := range response.Exercises {
+		response.Exercises[i], err = fillSets(response.Exercises[i])
+		if err != nil {
+			return response, err
+		}
+		response.Exercises[i] = withCardioStats(response.Exercises[i])
+		response.Exercises[i].CatalogID, err = linkCatalog(ctx, q, userID, response.Exercises[i])
+		if err != nil {
+			return response, err
+		}
+	}
+	response.Calories, err = estimateCalories(ctx, q, userID, response)
+	if err != nil {
+		return response, err
+	}
+	groups, err := encodeGroups(response)
+	if err != nil {
+		return response, err
+	}
+	if response.Groups == nil {
+		response.Groups = []ExerciseGroupJSON{}
+	}
+	response.ID, err = q.CreateWorkout(ctx, database.CreateWorkoutParams{
+		Userid:       userID,
+		Name:         req.Name,
+		Notes:        req.Notes,
+		Date:         req.Date,
+		Istemplate:   req.IsTemplate,
+		Templateid:   req.TemplateID,
+		Calories:     response.Calories,
+		Groups:       groups,
+		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+	})
+	if err != nil {
+		return response, err
+	}
+	response.Version = 1 // new rows start at version 1
+
 	params := []database.CreateExercisesParams{}
 	for i := range response.Exercises {
+		response.Exercises[i].WorkoutID = response.ID
+		progression, err := encodeProgression(response.Exercises[i])
+		if err != nil {
+			return response, err
+		}
 		params = append(params, database.CreateExercisesParams{
-			Userid:       userID,
-			Workoutid:    response.ID,
-			Exercisetype: response.Exercises[i].ExerciseType,
-			Name:         response.Exercises[i].Name,
-			Weight:       response.Exercises[i].Weight,
-			Weightunit:   response.Exercises[i].WeightUnit,
-			Reps:         response.Exercises[i].Reps,
-			Duration:     response.Exercises[i].Duration,
+			Userid:        userID,
+			Workoutid:     response.ID,
+			Position:      int32(i),
+			Catalogid:     response.Exercises[i].CatalogID,
+			Exercisetype:  response.Exercises[i].ExerciseType,
+			Name:          response.Exercises[i].Name,
+			Weight:        response.Exercises[i].Weight,
+			Weightunit:    response.Exercises[i].WeightUnit,
+			Reps:          response.Exercises[i].Reps,
+			Duration:      response.Exercises[i].Duration,
+			Progression:   progression,
+			Distance:      response.Exercises[i].Distance,
+			Elevationgain: response.Exercises[i].ElevationGain,
+			Avgheartrate:  response.Exercises[i].AvgHeartRate,
+			Maxheartrate:  response.Exercises[i].MaxHeartRate,
+			Groupnumber:   response.Exercises[i].Group,
+			Lastmodified:  pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
 		})
 	}
-	problem := false
-	qtx.CreateExercises(a.ctx, params).Query(func(i1 int, ids []int32, err error) {
-		if err != nil {
-			problem = true
+	q.CreateExercises(ctx, params).Query(func(i int, ids []int32, e error) {
+		if e != nil {
+			err = e
 			return
 		}
-		for i, id := range ids {
-			response.Exercises[i].ID = id
+		// each insert in the batch returns the one id
+		response.Exercises[i].ID = ids[0]
+	})
+	if err != nil {
+		return response, err
+	}
+	return response, createSets(ctx, q, userID, response.ID, response.Exercises)
+}
+
+var setTypes = []string{"normal", "warmup", "drop", "failure"}
+
+// Fill in the sets from the reps and weight when there aren't any,
+// then the reps and weight from the sets, so they're both stored.
+// Sets without a type are normal sets.
+func fillSets(e ExerciseJSON) (ExerciseJSON, error) {
+	if len(e.Sets) == 0 {
+		e.Sets = []SetJSON{}
+		for _, reps := range e.Reps {
+			e.Sets = append(e.Sets, SetJSON{Weight: float64(e.Weight), Reps: reps})
+		}
+	}
+
+	e.Reps = []int32{}
+	heaviest := 0.0
+	for i := range e.Sets {
+		if len(e.Sets[i].Type) == 0 {
+			e.Sets[i].Type = "normal"
+		}
+		if !slices.Contains(setTypes, e.Sets[i].Type) {
+			return e, mutationError(fmt.Sprintf("unknown set type %q", e.Sets[i].Type))
+		}
+		e.Reps = append(e.Reps, e.Sets[i].Reps)
+		heaviest = max(heaviest, e.Sets[i].Weight)
+	}
+	if len(e.Sets) > 0 {
+		e.Weight = int32(math.Round(heaviest))
+	}
+	return e, nil
+}
+
+// Insert the sets of the exercises, which must have ids
+func createSets(
+	ctx context.Context, q *database.Queries,
+	userID int32, workoutID int32, exercises []ExerciseJSON,
+) error {
+	params := []database.CreateSetsParams{}
+	for _, e := range exercises {
+		for position, set := range e.Sets {
+			params = append(params, database.CreateSetsParams{
+				Userid:      userID,
+				Workoutid:   workoutID,
+				Exerciseid:  e.ID,
+				Position:    int32(position),
+				Weight:      set.Weight,
+				Reps:        set.Reps,
+				Rpe:         set.RPE,
+				Restseconds: set.RestSeconds,
+				Settype:     set.Type,
+			})
+		}
+	}
+
+	var err error
+	q.CreateSets(ctx, params).Exec(func(i int, e error) {
+		if e != nil {
+			err = e
 		}
 	})
-	if problem {
-		respond(w, http.StatusInternalServerError, "Couldn't create workout")
+	return err
+}
+
+// Turn the reps of the exercises made before there were sets into sets.
+// Should be called in a transaction.
+func backfillSets(ctx context.Context, q *database.Queries) error {
+	return q.BackfillSets(ctx)
+}
+
+// Create a workout from a template on the date in the request body
+func (a *API) StartWorkout(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	templateID, ok := getPathValue[int64](w, r, "id")
+	if !ok {
+		return
+	}
+	req, ok := parseRequest[DateJSON](w, r)
+	if !ok {
+		return
+	}
+	if req.Date == 0 {
+		respond(w, http.StatusBadRequest, "bad request: missing date")
+		return
+	}
+
+	tx, err := a.conn.Begin(a.ctx)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't start workout")
+		return
+	}
+	defer tx.Rollback(a.ctx)
+	qtx := a.queries.WithTx(tx)
+
+	workout, err := startWorkout(a.ctx, qtx, userID, int32(templateID), req.Date)
+	var message mutationError
+	if errors.As(err, &message) {
+		respond(w, http.StatusBadRequest, message.Error())
+		return
+	}
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Template not found")
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't start workout")
 		return
 	}
 
 	if err := tx.Commit(a.ctx); err != nil {
-		respond(w, http.StatusInternalServerError, "Couldn't create workout")
+		respond(w, http.StatusInternalServerError, "Couldn't start workout")
 		return
 	}
-	respond(w, http.StatusOK, map[string]any{"workout": response})
+	respond(w, http.StatusOK, map[string]any{"workout": workout})
 }
 
-func (a *API) DeleteWorkout(w http.ResponseWriter, r *http.Request) {
+// Create a workout from the template, with the weights of the last time
+// each exercise was done, or the recommended weights of the exercises that
+// have a progression. Should be called in a transaction.
+func startWorkout(
+	ctx context.Context, q *database.Queries,
+	userID int32, templateID int32, date int64,
+) (WorkoutJSON, error) {
+	row, err := q.GetWorkout(ctx, database.GetWorkoutParams{ID: templateID, Userid: userID})
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+	if !row.Istemplate {
+		return WorkoutJSON{}, mutationError("workout isn't a template")
+	}
+	template, err := getWorkout(ctx, q, row, pgtype.Bool{Bool: false, Valid: true})
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+
+	workout := WorkoutJSON{
+		Name: template.Name, Notes: template.Notes, Date: date,
+		TemplateID: template.ID, Exercises: []ExerciseJSON{}, Groups: template.Groups,
+	}
+	for _, e := range template.Exercises {
+		e.ID, e.WorkoutID = 0, 0
+		e, err = prefillWeights(ctx, q, userID, e)
+		if err != nil {
+			return WorkoutJSON{}, err
+		}
+		e, err = recommendProgression(ctx, q, userID, e)
+		if err != nil {
+			return WorkoutJSON{}, err
+		}
+		e.Progression = nil // only templates progress
+		workout.Exercises = append(workout.Exercises, e)
+	}
+	return createWorkout(ctx, q, userID, prescribeRounds(workout))
+}
+
+// Use the weights of the sets of the last time the exercise was done.
+// Sets past the last time's sets get the weight of its last set.
+func prefillWeights(
+	ctx context.Context, q *database.Queries, userID int32, e ExerciseJSON,
+) (ExerciseJSON, error) {
+	if e.ExerciseType != "strength" {
+		return e, nil
+	}
+	last, err := q.GetLastPerformance(ctx, database.GetLastPerformanceParams{
+		Userid: userID, Catalogid: e.CatalogID, Name: e.Name,
+	})
+	if err == pgx.ErrNoRows {
+		return e, nil
+	}
+	if err != nil {
+		return e, err
+	}
+	sets, err := q.GetExerciseSets(ctx, database.GetExerciseSetsParams{
+		Exerciseid: last.ID, Userid: userID,
+	})
+	if err != nil || len(sets) == 0 {
+		return e, err
+	}
+
+	e.Sets = slices.Clone(e.Sets)
+	for i := range e.Sets {
+		e.Sets[i].Weight = sets[min(i, len(sets)-1)].Weight
+	}
+	e.WeightUnit = last.Weightunit
+	return e, nil
+}
+
+// Only update the fields that are set in the request
+func (a *API) PatchWorkout(w http.ResponseWriter, r *http.Request) {
 	userID, ok := parseJWT(a, w, r)
 	if !ok {
 		return
 	}
-	workoutID, ok := getQuery[int64](w, r, "id")
+	workoutID, ok := getPathValue[int64](w, r, "id")
+	if !ok {
+		return
+	}
+	req, ok := parseRequest[WorkoutPatchJSON](w, r)
 	if !ok {
 		return
 	}
 
 	tx, err := a.conn.Begin(a.ctx)
 	if err != nil {
-		respond(w, http.StatusInternalServerError, "Couldn't delete workout")
+		respond(w, http.StatusInternalServerError, "Couldn't update workout")
 		return
 	}
 	defer tx.Rollback(a.ctx)
 	qtx := a.queries.WithTx(tx)
 
-	if err := qtx.DeleteWorkout(a.ctx, database.DeleteWorkoutParams{
+	workout, err := patchWorkout(a.ctx, qtx, userID, int32(workoutID), req)
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Workout not found")
+		return
+	}
+	if respondConflict(w, err) {
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't update workout")
+		return
+	}
+
+	if err := tx.Commit(a.ctx); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't update workout")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{"workout": workout})
+}
+
+func patchWorkout(
+	ctx context.Context, q *database.Queries,
+	userID int32, workoutID int32, req WorkoutPatchJSON,
+) (WorkoutJSON, error) {
+	row, err := q.GetWorkout(ctx, database.GetWorkoutParams{ID: workoutID, Userid: userID})
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+	if req.Version != 0 && req.Version != row.Version {
+		return WorkoutJSON{}, workoutConflict(ctx, q, row)
+	}
+	original := row
+
+	if req.Name != nil {
+		row.Name = *req.Name
+	}
+	if req.Notes != nil {
+		row.Notes = *req.Notes
+	}
+	if req.Date != nil {
+		row.Date = *req.Date
+	}
+	if req.IsTemplate != nil {
+		row.Istemplate = *req.IsTemplate
+	}
+
+	// the weight the calories are estimated with depends on the date
+	patched, err := getWorkout(ctx, q, row, pgtype.Bool{Bool: false, Valid: true})
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+	row.Calories, err = estimateCalories(ctx, q, userID, patched)
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+
+	row.Version, err = q.UpdateWorkout(ctx, database.UpdateWorkoutParams{
 		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+		Name:         row.Name,
+		Notes:        row.Notes,
+		Date:         row.Date,
+		Istemplate:   row.Istemplate,
+		Calories:     row.Calories,
+		Groups:       row.Groups,
+		ID:           row.ID,
 		Userid:       userID,
-		ID:           int32(workoutID),
-	}); err != nil {
-		respond(w, http.StatusInternalServerError, "Couldn't delete workout")
+		BaseVersion:  row.Version,
+	})
+	if err == pgx.ErrNoRows {
+		return WorkoutJSON{}, changedWorkoutConflict(ctx, q, userID, workoutID)
+	}
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+
+	// the date or whether it's a template could have changed
+	workout, err := getWorkout(ctx, q, row, pgtype.Bool{Bool: false, Valid: true})
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+	before := workout
+	before.Date, before.IsTemplate = original.Date, original.Istemplate
+	return workout, updateRecords(ctx, q, userID, before, workout)
+}
+
+// Replace the workout's fields and exercises with the ones in the request
+func (a *API) UpdateWorkout(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	workoutID, ok := getPathValue[int64](w, r, "id")
+	if !ok {
+		return
+	}
+	req, ok := parseRequest[WorkoutJSON](w, r)
+	if !ok {
 		return
 	}
 
-	if err := qtx.DeleteExercise(a.ctx, database.DeleteExerciseParams{
-		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+	tx, err := a.conn.Begin(a.ctx)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't update workout")
+		return
+	}
+	defer tx.Rollback(a.ctx)
+	qtx := a.queries.WithTx(tx)
+
+	workout, err := updateWorkout(a.ctx, qtx, userID, int32(workoutID), req)
+	var message mutationError
+	if errors.As(err, &message) {
+		respond(w, http.StatusBadRequest, message.Error())
+		return
+	}
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Workout not found")
+		return
+	}
+	if respondConflict(w, err) {
+		return
+	}
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't update workout")
+		return
+	}
+
+	if err := tx.Commit(a.ctx); err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't update workout")
+		return
+	}
+	respond(w, http.StatusOK, map[string]any{"workout": workout})
+}
+
+// Update the workout and diff its exercises against the ones in the request.
+// Exercises with ids are updated, exercises without ids are added, and the
+// exercises that aren't in the request are removed. The exercises are kept
+// in the order of the request. Should be called in a transaction.
+func updateWorkout(
+	ctx context.Context, q *database.Queries,
+	userID int32, workoutID int32, req WorkoutJSON,
+) (WorkoutJSON, error) {
+	row, err := q.GetWorkout(ctx, database.GetWorkoutParams{ID: workoutID, Userid: userID})
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+	if req.Version != 0 && req.Version != row.Version {
+		return WorkoutJSON{}, workoutConflict(ctx, q, row)
+	}
+
+	existing, err := q.GetExercises(ctx, database.GetExercisesParams{
+		Userid: userID, Workoutid: workoutID,
+		IgnoreDeleted: pgtype.Bool{Bool: false, Valid: true},
+	})
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+	exists := map[int32]bool{}
+	before := WorkoutJSON{Date: row.Date, IsTemplate: row.Istemplate}
+	for _, e := range existing {
+		exists[e.ID] = true
+		before.Exercises = append(before.Exercises, ExerciseJSON{Name: e.Name, CatalogID: e.Catalogid})
+	}
+
+	keep := []int32{}
+	for i, e := range req.Exercises {
+		req.Exercises[i], err = fillSets(e)
+		if err != nil {
+			return WorkoutJSON{}, err
+		}
+		req.Exercises[i].CatalogID, err = linkCatalog(ctx, q, userID, e)
+		if err != nil {
+			return WorkoutJSON{}, err
+		}
+		if e.ID <= 0 {
+			continue
+		}
+		if !exists[e.ID] {
+			return WorkoutJSON{}, mutationError(fmt.Sprintf("exercise %d isn't in the workout", e.ID))
+		}
+		if slices.Contains(keep, e.ID) {
+			return WorkoutJSON{}, mutationError(fmt.Sprintf("exercise %d is repeated", e.ID))
+		}
+		keep = append(keep, e.ID)
+	}
+
+	calories, err := estimateCalories(ctx, q, userID, req)
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+	groups, err := encodeGroups(req)
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+
+	now := pgtype.Int8{Int64: time.Now().Unix(), Valid: true}
+	_, err = q.UpdateWorkout(ctx, database.UpdateWorkoutParams{
+		Lastmodified: now,
+		Name:         req.Name,
+		Notes:        req.Notes,
+		Date:         req.Date,
+		Istemplate:   req.IsTemplate,
+		Calories:     calories,
+		Groups:       groups,
+		ID:           workoutID,
 		Userid:       userID,
-		Workoutid:    int32(workoutID),
+		BaseVersion:  row.Version,
+	})
+	if err == pgx.ErrNoRows {
+		return WorkoutJSON{}, changedWorkoutConflict(ctx, q, userID, workoutID)
+	}
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+
+	if err := q.DeleteOtherExercises(ctx, database.DeleteOtherExercisesParams{
+		Lastmodified: now, Workoutid: workoutID, Userid: userID, Keep: keep,
 	}); err != nil {
+		return WorkoutJSON{}, err
+	}
+	if err := q.DeleteOtherExerciseSets(ctx, database.DeleteOtherExerciseSetsParams{
+		Workoutid: workoutID, Userid: userID, Keep: keep,
+	}); err != nil {
+		return WorkoutJSON{}, err
+	}
+
+	added := []database.CreateExercisesParams{}
+	addedAt := []int{} // the index of each added exercise in the request
+	for i, e := range req.Exercises {
+		progression, err := encodeProgression(e)
+		if err != nil {
+			return WorkoutJSON{}, err
+		}
+		if e.ID <= 0 {
+			addedAt = append(addedAt, i)
+			added = append(added, database.CreateExercisesParams{
+				Userid:        userID,
+				Workoutid:     workoutID,
+				Position:      int32(i),
+				Catalogid:     e.CatalogID,
+				Exercisetype:  e.ExerciseType,
+				Name:          e.Name,
+				Weight:        e.Weight,
+				Weightunit:    e.WeightUnit,
+				Reps:          e.Reps,
+				Duration:      e.Duration,
+				Progression:   progression,
+				Distance:      e.Distance,
+				Elevationgain: e.ElevationGain,
+				Avgheartrate:  e.AvgHeartRate,
+				Maxheartrate:  e.MaxHeartRate,
+				Groupnumber:   e.Group,
+				Lastmodified:  now,
+			})
+			continue
+		}
+
+		if _, err := q.UpdateExercise(ctx, database.UpdateExerciseParams{
+			Lastmodified:  now,
+			Position:      int32(i),
+			Catalogid:     e.CatalogID,
+			Exercisetype:  e.ExerciseType,
+			Name:          e.Name,
+			Weight:        e.Weight,
+			Weightunit:    e.WeightUnit,
+			Reps:          e.Reps,
+			Duration:      e.Duration,
+			Progression:   progression,
+			Distance:      e.Distance,
+			Elevationgain: e.ElevationGain,
+			Avgheartrate:  e.AvgHeartRate,
+			Maxheartrate:  e.MaxHeartRate,
+			Groupnumber:   e.Group,
+			ID:            e.ID,
+			Workoutid:     workoutID,
+			Userid:        userID,
+		}); err != nil {
+			return WorkoutJSON{}, err
+		}
+		// the sets are replaced, since they're always sent with the exercise
+		if err := q.DeleteSets(ctx, database.DeleteSetsParams{
+			Exerciseid: e.ID, Userid: userID,
+		}); err != nil {
+			return WorkoutJSON{}, err
+		}
+	}
+	q.CreateExercises(ctx, added).Query(func(i int, ids []int32, e error) {
+		if e != nil {
+			err = e
+			return
+		}
+		req.Exercises[addedAt[i]].ID = ids[0]
+	})
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+	if err := createSets(ctx, q, userID, workoutID, req.Exercises); err != nil {
+		return WorkoutJSON{}, err
+	}
+
+	row, err = q.GetWorkout(ctx, database.GetWorkoutParams{ID: workoutID, Userid: userID})
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+	workout, err := getWorkout(ctx, q, row, pgtype.Bool{Bool: false, Valid: true})
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+	return workout, updateRecords(ctx, q, userID, before, workout)
+}
+
+// A conflict holding the server's copy of the workout
+func workoutConflict(ctx context.Context, q *database.Queries, row database.Workout) error {
+	current, err := getWorkout(ctx, q, row, pgtype.Bool{Bool: false, Valid: true})
+	if err != nil {
+		return err
+	}
+	return conflictError{current}
+}
+
+// The conflict for a workout that was changed after it was read
+func changedWorkoutConflict(
+	ctx context.Context, q *database.Queries, userID int32, workoutID int32,
+) error {
+	current, err := q.GetWorkout(ctx, database.GetWorkoutParams{ID: workoutID, Userid: userID})
+	if err != nil {
+		return err
+	}
+	return workoutConflict(ctx, q, current)
+}
+
+func (a *API) DeleteWorkout(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	workoutID, ok := getQuery[int64](w, r, "id")
+	if !ok {
+		return
+	}
+	version, ok := getOptionalQuery[int64](w, r, "version", 0)
+	if !ok {
+		return
+	}
+	a.deleteWorkout(w, userID, int32(workoutID), version)
+}
+
+func (a *API) RemoveWorkout(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseJWT(a, w, r)
+	if !ok {
+		return
+	}
+	workoutID, ok := getPathValue[int64](w, r, "id")
+	if !ok {
+		return
+	}
+	version, ok := getOptionalQuery[int64](w, r, "version", 0)
+	if !ok {
+		return
+	}
+	a.deleteWorkout(w, userID, int32(workoutID), version)
+}
+
+func (a *API) deleteWorkout(w http.ResponseWriter, userID int32, workoutID int32, version int64) {
+	tx, err := a.conn.Begin(a.ctx)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, "Couldn't delete workout")
+		return
+	}
+	defer tx.Rollback(a.ctx)
+
+	err = deleteWorkoutTx(a.ctx, a.queries.WithTx(tx), userID, workoutID, version)
+	if err == pgx.ErrNoRows {
+		respond(w, http.StatusNotFound, "Workout not found")
+		return
+	}
+	if respondConflict(w, err) {
+		return
+	}
+	if err != nil {
 		respond(w, http.StatusInternalServerError, "Couldn't delete workout")
 		return
 	}
@@ -119,6 +764,46 @@ func (a *API) DeleteWorkout(w http.ResponseWriter, r *http.Request) {
 	respond(w, http.StatusOK, nil)
 }
 
+// Soft delete the workout and its exercises, and find the personal records
+// of its exercises again, unless it changed since the version the delete is
+// based on. Returns pgx.ErrNoRows when the user doesn't have the workout.
+// Should be called in a transaction.
+func deleteWorkoutTx(
+	ctx context.Context, q *database.Queries, userID int32, workoutID int32, version int64,
+) error {
+	row, err := q.GetWorkout(ctx, database.GetWorkoutParams{ID: workoutID, Userid: userID})
+	if err != nil {
+		return err
+	}
+	workout, err := getWorkout(ctx, q, row, pgtype.Bool{Bool: false, Valid: true})
+	if err != nil {
+		return err
+	}
+
+	deleted, err := q.DeleteWorkout(ctx, database.DeleteWorkoutParams{
+		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+		Userid:       userID,
+		ID:           workoutID,
+		BaseVersion:  baseVersion(version),
+	})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return changedWorkoutConflict(ctx, q, userID, workoutID)
+	}
+
+	if err := q.DeleteExercise(ctx, database.DeleteExerciseParams{
+		Lastmodified: pgtype.Int8{Int64: time.Now().Unix(), Valid: true},
+		Userid:       userID,
+		Workoutid:    workoutID,
+	}); err != nil {
+		return err
+	}
+
+	return updateRecords(ctx, q, userID, workout)
+}
+
 func getWorkout(
 	ctx context.Context, q *database.Queries,
 	w database.Workout, ignoreDeleted pgtype.Bool,
@@ -128,17 +813,51 @@ func getWorkout(
 	if err != nil {
 		return WorkoutJSON{}, err
 	}
+	setRows, err := q.GetSets(ctx, database.GetSetsParams{Userid: w.Userid, Workoutid: w.ID})
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+	return buildWorkout(w, rows, setRows)
+}
+
+// Put the workout together from its exercises and their sets, in order
+func buildWorkout(
+	w database.Workout, rows []database.Exercise, setRows []database.Set,
+) (WorkoutJSON, error) {
+	sets := map[int32][]SetJSON{} // by exercise id, in order
+	for _, row := range setRows {
+		sets[row.Exerciseid] = append(sets[row.Exerciseid], SetJSON{
+			Weight: row.Weight, Reps: row.Reps, RPE: row.Rpe,
+			RestSeconds: row.Restseconds, Type: row.Settype,
+		})
+	}
 	workout := WorkoutJSON{
 		Deleted: w.Deleted, ID: w.ID, Name: w.Name, Notes: w.Notes,
-		Date: w.Date, IsTemplate: w.Istemplate, Exercises: []ExerciseJSON{},
+		Date: w.Date, IsTemplate: w.Istemplate, TemplateID: w.Templateid,
+		Exercises: []ExerciseJSON{}, Version: w.Version, Calories: w.Calories,
 	}
+	groups, err := decodeGroups(w.Groups)
+	if err != nil {
+		return WorkoutJSON{}, err
+	}
+	workout.Groups = groups
 	for _, row := range rows {
-		workout.Exercises = append(workout.Exercises, ExerciseJSON{
+		if sets[row.ID] == nil {
+			sets[row.ID] = []SetJSON{}
+		}
+		progression, err := decodeProgression(row.Progression)
+		if err != nil {
+			return WorkoutJSON{}, err
+		}
+		workout.Exercises = append(workout.Exercises, withCardioStats(ExerciseJSON{
 			ID: row.ID, WorkoutID: w.ID, Name: row.Name,
 			Weight: row.Weight, WeightUnit: row.Weightunit,
 			Reps: row.Reps, ExerciseType: row.Exercisetype,
-			Duration: row.Duration,
-		})
+			Duration: row.Duration, Sets: sets[row.ID], CatalogID: row.Catalogid,
+			Progression: progression, Distance: row.Distance,
+			ElevationGain: row.Elevationgain, AvgHeartRate: row.Avgheartrate,
+			MaxHeartRate: row.Maxheartrate, Group: row.Groupnumber,
+		}))
 	}
 	return workout, nil
 }
@@ -1,8 +1,8 @@
 import { useEffect } from "react";
 import { Route, useHistory, useLocation } from "react-router";
 import { IonReactRouter } from "@ionic/react-router";
-import { useAppState, UserDataUpdate } from "./lib/state";
-import { request, useAuthRequest } from "./lib/request";
+import { useAppState } from "./lib/state";
+import { pullChanges, streamChanges, useAuthRequest } from "./lib/request";
 
 import {
   IonApp, IonIcon, IonRouterOutlet, IonTabBar,
@@ -35,7 +35,7 @@ setupIonicReact();
 
 function TabsWrapper() {
   const {
-    lastSyncTime, clearNotifications, settings,
+    syncCursor, deviceID, clearUserData, clearNotifications, settings,
     indexedbLoaded, token, updateUserData
   } = useAppState();
   const authRequest = useAuthRequest();
@@ -45,10 +45,8 @@ function TabsWrapper() {
   const showTabBar = !["/auth"].includes(location.pathname);
 
   const syncUserData = async () => {
-    const endpoint = `/user/data?time=${lastSyncTime}&ignoreDeleted=false`;
-    const json = await authRequest((jwt: string) =>
-      request("GET", endpoint, undefined, jwt));
-    if (json) updateUserData(json as UserDataUpdate);
+    await authRequest((jwt: string) =>
+      pullChanges(syncCursor, deviceID, jwt, updateUserData, clearUserData));
   }
 
   const setupUI = () => {
@@ -69,6 +67,31 @@ function TabsWrapper() {
     }
   }, [indexedbLoaded]);
 
+  // pull as soon as another device changes something
+  useEffect(() => {
+    if (!indexedbLoaded || token.length == 0) return;
+    const controller = new AbortController();
+
+    const pull = () => {
+      const state = useAppState.getState();
+      pullChanges(state.syncCursor, state.deviceID, token,
+        state.updateUserData, state.clearUserData).catch(() => {});
+    };
+
+    (async () => {
+      while (!controller.signal.aborted) {
+        try {
+          await streamChanges(token, pull, controller.signal);
+        } catch (err: any) {
+          if (err.statusCode == 401) return;
+        }
+        // wait a bit before reconnecting
+        await new Promise((resolve) => setTimeout(resolve, 5000));
+      }
+    })();
+    return () => controller.abort();
+  }, [indexedbLoaded, token]);
+
   return (
     <IonTabs>
       <IonRouterOutlet animated={false}>
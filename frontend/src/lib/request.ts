@@ -1,5 +1,5 @@
 import { useHistory } from "react-router";
-import { useAppState } from "./state";
+import { useAppState, UserDataUpdate } from "./state";
 
 export class ApiError extends Error {
   statusCode: number;
@@ -11,28 +11,104 @@ export class ApiError extends Error {
   }
 }
 
-// Create a request to the backend
+// How many times requests that create something are retried
+// when the network fails before there's a response
+const createRetries = 2;
+
+// Create a request to the backend. Requests that create something are sent
+// with an Idempotency-Key, so they can be retried without creating duplicates.
 export async function request(
   method: string,
   endpoint: string,
   payload: object | undefined,
-  token: string | undefined
+  token: string | undefined,
+  creates: boolean = false
 ): Promise<object> {
-  const url = `${process.env.BACKEND_API_URL}${endpoint}`;
+  const url = `${process.env.BACKEND_API_URL}/v1${endpoint}`;
   let headers: Record<string, string> = { "Content-Type": "application/json" };
 
   let body: RequestInit = { method };
   if (payload) body.body = JSON.stringify(payload);
   if (token) headers["Authorization"] = `Bearer ${token}`;
+  if (creates) headers["Idempotency-Key"] = crypto.randomUUID();
   body.headers = headers;
 
-  const response = await fetch(url, body);
+  const send = async (attempt: number): Promise<Response> => {
+    try {
+      return await fetch(url, body);
+    } catch (err) {
+      if (!creates || attempt == createRetries) throw err;
+      return send(attempt + 1);
+    }
+  };
+
+  const response = await send(0);
   const json = await response.json();
   if (!response.ok)
     throw new ApiError(json["error"] || "Unknown error", response.status);
   return json;
 }
 
+// Pull the changes made after the cursor, a page at a time. When the server
+// has purged deletes this device hasn't pulled, start over from scratch.
+export async function pullChanges(
+  cursor: string,
+  deviceID: string,
+  token: string,
+  apply: (json: UserDataUpdate) => void,
+  clear: () => void
+): Promise<UserDataUpdate> {
+  while (true) {
+    const endpoint =
+      `/sync/pull?cursor=${encodeURIComponent(cursor)}&device=${encodeURIComponent(deviceID)}`;
+    let json: UserDataUpdate;
+    try {
+      json = await request("GET", endpoint, undefined, token) as UserDataUpdate;
+    } catch (err: any) {
+      if (err.statusCode !== 410 || cursor === "") throw err;
+      clear();
+      cursor = "";
+      continue;
+    }
+
+    apply(json);
+    if (!json.hasMore) return json;
+    cursor = json.cursor;
+  }
+}
+
+// Call onChange when the stream of changes opens, to catch up on the changes
+// made while it wasn't, then whenever another change is made, until the stream
+// ends. EventSource can't send the Authorization header, so fetch is used.
+export async function streamChanges(
+  token: string,
+  onChange: () => void,
+  signal: AbortSignal
+) {
+  const url = `${process.env.BACKEND_API_URL}/v1/events`;
+  const headers = { "Authorization": `Bearer ${token}` };
+  const response = await fetch(url, { headers, signal });
+  if (!response.ok || !response.body) {
+    const json = await response.json().catch(() => ({}));
+    throw new ApiError(json["error"] || "Unknown error", response.status);
+  }
+
+  onChange();
+  const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffer = "";
+  while (true) {
+    const { value, done } = await reader.read();
+    if (done) return;
+
+    // events are separated by blank lines
+    buffer += value;
+    const events = buffer.split("\n\n");
+    buffer = events.pop()!;
+    if (events.some((event) => event.split("\n").includes("event: change")))
+      onChange();
+  }
+}
+
 type UserRequest = (jwt: string) => Promise<object>;
 
 // factory function to make api requests with some added error handling
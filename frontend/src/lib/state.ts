@@ -26,6 +26,15 @@ export interface Meal {
   mealTag: string;
   servings: number;
   servingsUnit: string;
+  version?: number;
+}
+
+export interface ExerciseSet {
+  weight: number;
+  reps: number;
+  rpe: number; // 0 when not recorded
+  restSeconds: number;
+  type: string; // "normal", "warmup", "drop" or "failure"
 }
 
 export interface Exercise {
@@ -36,7 +45,36 @@ export interface Exercise {
   weight: number;
   weightUnit: string;
   reps: number[];
+  sets?: ExerciseSet[];
   duration: number; // in minutes
+  catalogID?: number; // 0 when it isn't in the exercise catalog
+  distance?: number; // in meters
+  elevationGain?: number; // in meters
+  avgHeartRate?: number;
+  maxHeartRate?: number;
+  pace?: number; // in seconds per km
+  speed?: number; // in km/h
+  group?: number; // of the workout's groups, from 1. 0 when it isn't in one
+  progression?: Progression; // only for exercises in templates
+  recommendation?: Recommendation; // only when just started from a template
+}
+
+export interface Progression {
+  scheme: string; // "linear", "double" or "percentage"
+  increment?: number;
+  minReps?: number;
+  maxReps?: number;
+  percent?: number;
+  deloadAfter?: number;
+  deloadPercent?: number;
+}
+
+export interface Recommendation {
+  scheme: string;
+  weight: number;
+  reps: number;
+  deload: boolean;
+  reason: string;
 }
 
 export interface Workout {
@@ -46,7 +84,17 @@ export interface Workout {
   date: number;
   notes: string;
   isTemplate: boolean;
+  templateID?: number; // 0 when it wasn't started from a template
   exercises: Exercise[];
+  groups?: ExerciseGroup[];
+  version?: number;
+}
+
+export interface ExerciseGroup {
+  type: string; // "superset" or "circuit"
+  rounds: number;
+  restSeconds: number; // between the exercises of a round
+  roundRestSeconds: number; // after each round
 }
 
 interface Settings {
@@ -55,6 +103,7 @@ interface Settings {
   trackPeriod: boolean;
   macroTargets: Record<string, number>;
   darkMode: boolean;
+  version?: number;
 }
 
 interface RecordJSON {
@@ -69,14 +118,17 @@ export interface UserDataUpdate {
   foods: Food[];
   meals: Meal[];
   records: RecordJSON[];
-  settings: Settings;
+  settings?: Settings; // only set when the settings changed
+  cursor: string;
+  hasMore: boolean;
 };
 
 interface Notification { message: string; error: boolean; };
 
 export interface AppState {
   token: string;
-  lastSyncTime: number;
+  syncCursor: string; // marks the last change that was pulled
+  deviceID: string; // tells the server which changes this device has pulled
   settings: Settings;
   foods: Map<number, Food>; // map food ids to foods
   meals: Map<number, Meal[]>; // map dates (unix timestamp) to meals
@@ -99,6 +151,7 @@ export interface AppState {
   updateToken: (token: string) => void;
   updateSettings: (updatedFields: Partial<Settings>) => void;
   updateUserData: (json: UserDataUpdate) => void;
+  clearUserData: () => void;
   addNotification: (n: Notification) => void;
   removeNotification: (index: number) => void;
   clearNotifications: () => void;
@@ -109,7 +162,8 @@ export interface AppState {
 // Define the persisted state type
 interface PersistedState {
   token: string;
-  lastSyncTime: number;
+  syncCursor: string;
+  deviceID: string;
   settings: Settings;
   foods: Map<number, Food>;
   meals: Map<number, Meal[]>;
@@ -123,7 +177,8 @@ interface PersistedState {
 
 const defaultProps = {
   token: "",
-  lastSyncTime: 0,
+  syncCursor: "",
+  deviceID: crypto.randomUUID(),
   foods: new Map(),
   meals: new Map(),
   templates: [],
@@ -146,6 +201,18 @@ const state: StateCreator<AppState> = (set, _) => ({
 
   resetState: () => set((_) => ({ ...defaultProps })),
 
+  // forget the synced data, so it can be pulled from scratch
+  clearUserData: () => set((state: AppState) => ({
+    ...state,
+    syncCursor: "",
+    foods: new Map(),
+    meals: new Map(),
+    templates: [],
+    workouts: new Map(),
+    weightLog: new Map(),
+    periodDates: new Map(),
+  })),
+
   updateToken: (token: string) => set((state: AppState) => ({ ...state, token })),
 
   updateSettings: (updatedFields: Partial<Settings>) =>
@@ -302,8 +369,8 @@ const state: StateCreator<AppState> = (set, _) => ({
 
       return {
         ...state,
-        lastSyncTime: new Date().getTime(),
-        settings: json.settings,
+        syncCursor: json.cursor,
+        settings: json.settings ?? state.settings,
         workouts, templates, meals,
         foods, weightLog, periodDates,
       };
@@ -350,7 +417,8 @@ export const useAppState = create(persist(state, {
   storage,
   partialize: (state): PersistedState => ({
     token: state.token,
-    lastSyncTime: state.lastSyncTime,
+    syncCursor: state.syncCursor,
+    deviceID: state.deviceID,
     settings: state.settings,
     foods: state.foods,
     meals: state.meals,
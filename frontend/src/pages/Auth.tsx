@@ -1,7 +1,7 @@
 import { useState } from 'react';
 import { useHistory } from 'react-router';
-import { request } from '../lib/request';
-import { useAppState, UserDataUpdate } from '../lib/state';
+import { pullChanges, request } from '../lib/request';
+import { useAppState } from '../lib/state';
 
 import { IonButton, IonContent, IonPage } from '@ionic/react';
 import { Input } from "../Components";
@@ -9,7 +9,9 @@ import "../theme/styles.css";
 
 export default function AuthPage() {
   const history = useHistory();
-  const { lastSyncTime, updateToken, updateUserData } = useAppState();
+  const {
+    syncCursor, deviceID, updateToken, updateUserData, clearUserData
+  } = useAppState();
 
   const [email, setEmail] = useState("");
   const [password, setPassword] = useState("");
@@ -33,10 +35,8 @@ export default function AuthPage() {
           await request("POST", endpoint, { email, password }, undefined) as { token: string; };
         updateToken(tokenJson.token);
 
-        endpoint = `/user/data?time=${lastSyncTime}&ignoreDeleted=false`;
-        const json =
-          await request("GET", endpoint, undefined, tokenJson.token) as UserDataUpdate;
-        updateUserData(json);
+        await pullChanges(
+          syncCursor, deviceID, tokenJson.token, updateUserData, clearUserData);
 
         history.replace("/exercise");
       } catch (err: any) {
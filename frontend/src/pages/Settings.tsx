@@ -1,4 +1,4 @@
-import { useIonViewWillLeave } from '@ionic/react';
+import { useIonViewWillLeave } from '@ionic/react';
 import { useEffect, useRef, useState } from "react";
 import { useHistory } from "react-router";
 import { request, useAuthRequest } from "./../lib/request";
@@ -70,9 +70,12 @@ export default function SettingsPage() {
   const settingsRef = useRef(settings);
   useEffect(() => { settingsRef.current = settings; }, [settings]);
 
-  useIonViewWillLeave(() => {
-    authRequest((jwt: string) =>
-      request("POST", "/user/settings", { ...settingsRef.current }, jwt))
+  useIonViewWillLeave(async () => {
+    const json = await authRequest((jwt: string) =>
+      request("POST", "/user/settings", { ...settingsRef.current }, jwt)) as { settings: { version: number } };
+    // the next change is based on the version that was just saved
+    if (json !== undefined)
+      updateSettings({ version: json.settings.version });
   });
   
   const possibleMacroTargets = () => ["carbohydrate", "protein", "fat"]
@@ -63,7 +63,7 @@ export default function TemplatePage() {
 
   const remove = async () => {
     const response = await authRequest((jwt: string) =>
-      request("DELETE", `/workout/delete?id=${template.id}`, undefined, jwt));
+      request("DELETE", `/workout/delete?id=${template.id}&version=${template.version ?? 0}`, undefined, jwt));
     if (response !== undefined)
       removeWorkout(template.id);
 
@@ -72,17 +72,20 @@ export default function TemplatePage() {
 
   const update = async () => {
     if (!validateForm()) return;
-    if (!creating) await remove();
 
+    // exercises that were just added have an id of -1, so they get created.
+    // only the reps and weight are edited, so the sets are made from them,
+    // and names can change, so the server links them to the catalog again
     const payload = JSON.parse(JSON.stringify(template));
     payload.date = dayUnixTimestamp(new Date());
-    payload.id = -1;
-    for (let i = 0; i < payload.exercises.length; i++) {
-      payload.exercises[i].id = -1;
+    for (const e of payload.exercises) {
+      delete e.sets;
+      delete e.catalogID;
     }
 
-    const json = await authRequest((jwt: string) =>
-      request("POST", "/workout/create", payload, jwt)) as { workout: Workout; };
+    const json = await authRequest((jwt: string) => creating
+      ? request("POST", "/workout/create", payload, jwt, true)
+      : request("PUT", `/workouts/${template.id}`, payload, jwt)) as { workout: Workout; };
     if (json !== undefined)
       upsertWorkout(json.workout);
 
@@ -23,6 +23,7 @@ export default function WorkoutPage() {
     const value = JSON.parse(JSON.stringify(base));
     value.date = dayUnixTimestamp(new Date());
     value.isTemplate = false;
+    value.templateID = templateID;
     value.id = -1;
     for (let i = 0; i < value.exercises.length; i++) {
       value.exercises[i].id = -1;
@@ -43,9 +44,8 @@ export default function WorkoutPage() {
     }
 
     const payload = { ...workout, id: -1, date: dayUnixTimestamp(new Date()) };
-    for (let i = 0; i < payload.exercises.length; i++) {
-      payload.exercises[i].id = -1;
-    }
+    // only the reps and weight are edited, so the sets are made from them
+    payload.exercises = payload.exercises.map((e: Exercise) => ({ ...e, id: -1, sets: undefined }));
 
     const json = await authRequest((jwt: string) =>
       request("POSt", "/workout/create", payload, jwt)) as { workout: Workout; };
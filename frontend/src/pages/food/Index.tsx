@@ -36,15 +36,15 @@ function EditMeal({ date, mealId, close, setPreviousMealTag }: {
   if (!food) return null;
 
   const update = async (m: Meal) => {
-    const response = await authRequest((jwt: string) =>
-      request("POST", "/meal/set", { ...m, updating: true }, jwt));
-    if (response !== undefined)
-      upsertMeal(date, m, index);
+    const json = await authRequest((jwt: string) =>
+      request("POST", "/meal/set", { ...m, updating: true }, jwt)) as { meal: Meal; };
+    if (json !== undefined)
+      upsertMeal(date, json.meal, index);
   }
 
   const remove = async () => {
     const response = await authRequest((jwt: string) =>
-      request("DELETE", `/meal/delete?mealID=${meal.id}`, undefined, jwt));
+      request("DELETE", `/meal/delete?mealID=${meal.id}&version=${meal.version ?? 0}`, undefined, jwt));
     if (response !== undefined) {
       removeMeal(date, index);
       close();
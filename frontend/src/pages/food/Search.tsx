@@ -43,7 +43,7 @@ export default function FoodSearchPage() {
     };
     const body = { ...mealInfo, updating: false };
     const json = await authRequest((jwt: string) =>
-      request("POST", "/meal/set", body, jwt)) as { mealID: number; };
+      request("POST", "/meal/set", body, jwt, true)) as { mealID: number; };
     if (json === undefined) return;
 
     const meal = { ...mealInfo, id: json.mealID };
@@ -70,7 +70,7 @@ export default function FoodViewPage() {
       }
 
       const json = await authRequest((jwt: string) =>
-        request("POST", "/food/new", normalizedFood, jwt)) as { id: number; };
+        request("POST", "/food/new", normalizedFood, jwt, true)) as { id: number; };
       if (json === undefined) return;
       upsertFood({ ...normalizedFood, id: json.id });
       history.goBack();
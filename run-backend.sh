@@ -11,6 +11,7 @@ POSTGRES_HOSTNAME=db # same as service
 DB_PORT=5432
 POSTGRES_DB=db
 APP_PORT=8100
+CORS_ALLOWED_ORIGINS=*
 EOF
 fi
 